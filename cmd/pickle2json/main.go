@@ -0,0 +1,194 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Pickle2json converts Python pickles to JSON.
+//
+// Usage:
+//
+//	pickle2json [flags] [file ...]
+//
+// It reads the pickle in each file, or in its standard input if there are no
+// files or a file is "-", and writes its JSON to its standard output, one
+// document per line. With --ndjson, each file may hold several pickles written
+// one after another, as successive calls to pickle.dump produce, and each is
+// written on a line of its own.
+//
+// The flags are:
+//
+//	--pretty
+//		indent the JSON
+//	--canonical
+//		write equal objects as the same JSON, sorting dict keys and sets and
+//		writing equal numbers alike, for hashing and comparing
+//	--ndjson
+//		decode every pickle in each file, rather than only the first
+//	--bytes=base64|base64url|hex|utf8|tagged
+//		how to write bytes, which JSON has no type for (default base64)
+//	--allow-class=pattern[,pattern...]
+//		allow only the classes matching the patterns, which are globs like
+//		"datetime.*" matched against the module and name of each class; may be
+//		repeated. Pickles of protocol 2 and lower refer to _codecs.encode for
+//		bytes, and for the datetimes they hold, so allowing the datetimes in
+//		them takes --allow-class=datetime.*,_codecs.encode
+//	--unknown-class=error|generic|null
+//		whether instances of classes this package doesn't implement are an
+//		error, are written as objects of their attributes, or are written as
+//		null (default error)
+//	--limit-depth=n
+//		fail on containers nested more than n deep
+//
+// On failure it writes the error, which says at what offset in the file the
+// pickle is malformed, or for a pickle whose JSON exceeds a limit, the offset
+// of its STOP opcode, to its standard error, and exits with status 1. The
+// offsets are the same with and without --ndjson.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mistsys/gopickle2json/pickle"
+)
+
+// classPatterns is a flag which collects the patterns of --allow-class
+type classPatterns []string
+
+func (p *classPatterns) String() string { return strings.Join(*p, ",") }
+
+func (p *classPatterns) Set(s string) error {
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern != "" {
+			*p = append(*p, pattern)
+		}
+	}
+	return nil
+}
+
+var bytesEncodings = map[string]pickle.BytesEncoding{
+	"base64":    pickle.BytesBase64,
+	"base64url": pickle.BytesBase64URL,
+	"hex":       pickle.BytesHex,
+	"utf8":      pickle.BytesUTF8,
+	"tagged":    pickle.BytesTagged,
+}
+
+// converter holds the settings given by the flags
+type converter struct {
+	ndjson              bool
+	opts                pickle.JSONOptions
+	classPolicy         pickle.ClassPolicy
+	allowUnknownClasses bool
+}
+
+func main() {
+	log := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, "pickle2json: "+format+"\n", args...)
+	}
+	flags := flag.NewFlagSet("pickle2json", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: pickle2json [flags] [file ...]\n")
+		flags.PrintDefaults()
+	}
+	pretty := flags.Bool("pretty", false, "indent the JSON")
+	canonical := flags.Bool("canonical", false, "write equal objects as the same JSON, sorting dict keys and sets")
+	ndjson := flags.Bool("ndjson", false, "decode every pickle in each file, writing each on a line of its own")
+	bytesFlag := flags.String("bytes", "base64", "how to write bytes: base64, base64url, hex, utf8 or tagged")
+	var allow classPatterns
+	flags.Var(&allow, "allow-class", "allow only the classes matching these comma separated `patterns`, like datetime.*,_codecs.encode; may be repeated")
+	unknownClass := flags.String("unknown-class", "error", "what to do with instances of unknown classes: error, generic or null")
+	limitDepth := flags.Int("limit-depth", 0, "fail on containers nested more than `n` deep (0 for no limit)")
+	flags.Parse(os.Args[1:])
+
+	usage := func(format string, args ...any) {
+		log(format, args...)
+		flags.Usage()
+		os.Exit(2)
+	}
+	c := converter{ndjson: *ndjson}
+	c.opts.Canonical = *canonical
+	if *pretty {
+		c.opts.Indent = "  "
+	}
+	c.classPolicy.Allow = allow
+	var ok bool
+	if c.opts.BytesEncoding, ok = bytesEncodings[*bytesFlag]; !ok {
+		usage("unknown --bytes encoding %q", *bytesFlag)
+	}
+	switch *unknownClass {
+	case "error":
+	case "generic":
+		c.allowUnknownClasses = true
+	case "null":
+		c.allowUnknownClasses = true
+		c.opts.Objects = pickle.ObjectNull
+	default:
+		usage("unknown --unknown-class policy %q", *unknownClass)
+	}
+	if *limitDepth < 0 {
+		usage("--limit-depth must not be negative")
+	}
+	c.opts.Limits.MaxDepth = *limitDepth
+	if *pretty && *ndjson {
+		usage("--pretty and --ndjson can't be used together")
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	enc := pickle.NewJSONEncoder(out, c.opts)
+	for _, name := range files {
+		err := c.convertFile(out, enc, name)
+		if ferr := out.Flush(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			if name == "-" {
+				name = "<stdin>"
+			}
+			log("%s: %v", name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// convertFile converts the pickles in the file called name, or in the standard input if name
+// is "-", writing their JSON to enc, and a newline after each to out
+func (c *converter) convertFile(out *bufio.Writer, enc *pickle.JSONEncoder, name string) error {
+	f := os.Stdin
+	if name != "-" {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	u := pickle.NewReaderUnpickler(f)
+	u.ClassPolicy = c.classPolicy
+	u.AllowUnknownClasses = c.allowUnknownClasses
+
+	if !c.ndjson {
+		if err := u.Transcode(enc); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	}
+	for {
+		err := u.TranscodeNext(enc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := out.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs pickle2json instead of the tests when the tests run themselves as it
+func TestMain(m *testing.M) {
+	if os.Getenv("PICKLE2JSON_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPickle2JSON(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested.pickle")
+	// [[[1]]] with protocol 0
+	if err := os.WriteFile(nested, []byte("(lp0\n(lp1\n(lp2\nI1\naaa."), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	// 1 followed by [[[1]]], with protocol 0
+	two := filepath.Join(dir, "two.pickle")
+	if err := os.WriteFile(two, []byte("I1\n.(lp0\n(lp1\n(lp2\nI1\naaa."), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	// datetime.datetime(2022, 1, 2, 3, 4, 5, 6) with protocol 2
+	datetime := filepath.Join(dir, "datetime.pickle")
+	if err := os.WriteFile(datetime, []byte("\x80\x02cdatetime\ndatetime\nq\x00c_codecs\nencode\nq\x01"+
+		"X\x0b\x00\x00\x00\x07\xc3\xa6\x01\x02\x03\x04\x05\x00\x00\x06q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07."), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	global := filepath.Join(dir, "global.pickle")
+	// os.system with protocol 2
+	if err := os.WriteFile(global, []byte("\x80\x02cos\nsystem\nq\x00."), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args   []string
+		status int
+		stdout string
+		stderr string
+	}{
+		{[]string{nested}, 0, "[[[1]]]\n", ""},
+		{[]string{"--limit-depth=3", nested}, 0, "[[[1]]]\n", ""},
+		{[]string{"--limit-depth=2", nested}, 1, "",
+			"pickle2json: " + nested + ": pickle: STOP at offset 21 (stack depth 1): exceeded Limits.MaxDepth of 2\n"},
+		// the errors are the same with --ndjson, and their offsets are in the whole file
+		{[]string{"--ndjson", "--limit-depth=2", nested}, 1, "",
+			"pickle2json: " + nested + ": pickle: STOP at offset 21 (stack depth 1): exceeded Limits.MaxDepth of 2\n"},
+		{[]string{"--ndjson", "--limit-depth=3", two}, 0, "1\n[[[1]]]\n", ""},
+		{[]string{"--ndjson", "--limit-depth=2", two}, 1, "1\n",
+			"pickle2json: " + two + ": pickle: STOP at offset 25 (stack depth 1): exceeded Limits.MaxDepth of 2\n"},
+		{[]string{"--limit-depth=2", two}, 0, "1\n", ""},
+		{[]string{"--allow-class=datetime.*,_codecs.encode", datetime}, 0, "\"2022-01-02T03:04:05.000006\"\n", ""},
+		{[]string{"--allow-class=datetime.*", datetime}, 1, "",
+			"pickle2json: " + datetime + ": pickle: GLOBAL at offset 23 (stack depth 1): class _codecs.encode is forbidden by the ClassPolicy\n"},
+		{[]string{"--allow-class=datetime.*", global}, 1, "",
+			"pickle2json: " + global + ": pickle: GLOBAL at offset 2 (stack depth 0): class os.system is forbidden by the ClassPolicy\n"},
+		{[]string{"--allow-class=os.[", global}, 1, "",
+			"pickle2json: " + global + ": pickle: GLOBAL at offset 2 (stack depth 0): ClassPolicy pattern \"os.[\": syntax error in pattern\n"},
+		{[]string{"--limit-depth=-1", nested}, 2, "", "pickle2json: --limit-depth must not be negative\n"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], tt.args...)
+			cmd.Env = append(os.Environ(), "PICKLE2JSON_TEST_MAIN=1")
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			err := cmd.Run()
+			status := 0
+			var ee *exec.ExitError
+			if errors.As(err, &ee) {
+				status = ee.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Errorf("exit status %d, want %d", status, tt.status)
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout %q, want %q", stdout.String(), tt.stdout)
+			}
+			// a usage error is followed by the usage
+			if got := stderr.String(); !strings.HasPrefix(got, tt.stderr) || (tt.status != 2 && got != tt.stderr) {
+				t.Errorf("stderr %q, want %q", got, tt.stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package codecs decodes the text encodings which pickles use, as Python's codecs do,
+// returning the same errors.
+//
+// Decoded strings are UTF-8, except that lone UTF-16 surrogates, which Python strings can
+// hold, are kept as the 3 bytes they would be encoded as, which Python calls surrogatepass.
+package codecs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DecodeError is Python's UnicodeDecodeError: the bytes data[Start:End] can't be decoded.
+type DecodeError struct {
+	Codec  string // like "utf-8"
+	Byte   byte   // the byte at Start
+	Start  int
+	End    int
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	if e.End-e.Start == 1 {
+		return fmt.Sprintf("'%s' codec can't decode byte 0x%02x in position %d: %s", e.Codec, e.Byte, e.Start, e.Reason)
+	}
+	return fmt.Sprintf("'%s' codec can't decode bytes in position %d-%d: %s", e.Codec, e.Start, e.End-1, e.Reason)
+}
+
+// EscapeDecode decodes Python's string escapes, which Python 2 writes the argument of the
+// STRING opcode with, as codecs.escape_decode does. It returns b itself if it has no escapes.
+func EscapeDecode(b []byte) ([]byte, error) {
+	i := bytes.IndexByte(b, '\\')
+	if i < 0 {
+		return b, nil
+	}
+	out := make([]byte, i, len(b))
+	copy(out, b)
+	for i < len(b) {
+		c := b[i]
+		if c != '\\' {
+			out = append(out, c)
+			i++
+			continue
+		}
+		start := i
+		i++
+		if i == len(b) {
+			return nil, errors.New("trailing \\ in string")
+		}
+		c = b[i]
+		i++
+		switch c {
+		case '\\', '\'', '"':
+			out = append(out, c)
+		case 'a':
+			out = append(out, '\a')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'v':
+			out = append(out, '\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// up to three octal digits; Python keeps the low 8 bits of larger values
+			v := int(c - '0')
+			for n := 1; n < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; n++ {
+				v = v<<3 | int(b[i]-'0')
+				i++
+			}
+			out = append(out, byte(v))
+		case 'x':
+			if i+2 > len(b) || unhex(b[i]) < 0 || unhex(b[i+1]) < 0 {
+				return nil, fmt.Errorf("invalid \\x escape at position %d", start)
+			}
+			out = append(out, byte(unhex(b[i])<<4|unhex(b[i+1])))
+			i += 2
+		default:
+			// unknown escapes are left as they are
+			out = append(out, '\\', c)
+		}
+	}
+	return out, nil
+}
+
+// DecodeRawUnicodeEscape decodes Python's raw-unicode-escape encoding, which protocol 0
+// writes the argument of the UNICODE opcode with. \uXXXX and \UXXXXXXXX are escapes when preceded by an odd
+// number of backslashes, and every other byte is a latin-1 character. UTF-16 surrogate
+// pairs are combined. It returns b itself if it is ASCII and has no backslashes.
+func DecodeRawUnicodeEscape(b []byte) ([]byte, error) {
+	i := 0
+	for i < len(b) && b[i] != '\\' && b[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(b) {
+		return b, nil
+	}
+	out := make([]byte, i, len(b)+len(b)/2)
+	copy(out, b)
+	for i < len(b) {
+		c := b[i]
+		if c != '\\' {
+			out = utf8.AppendRune(out, rune(c))
+			i++
+			continue
+		}
+		j := i
+		for j < len(b) && b[j] == '\\' {
+			j++
+		}
+		if (j-i)%2 == 0 || j == len(b) || (b[j] != 'u' && b[j] != 'U') {
+			out = append(out, b[i:j]...)
+			i = j
+			continue
+		}
+		out = append(out, b[i:j-1]...)
+		r, n, err := unicodeEscape(b, j-1)
+		if err != nil {
+			return nil, err
+		}
+		i = j - 1 + n
+		if r >= 0xd800 && r < 0xdc00 {
+			if lo, m, err := unicodeEscape(b, i); err == nil && lo >= 0xdc00 && lo < 0xe000 {
+				r = 0x10000 + (r-0xd800)<<10 + (lo - 0xdc00)
+				i += m
+			}
+		}
+		out = AppendRune(out, r)
+	}
+	return out, nil
+}
+
+// AppendRune appends the UTF-8 encoding of r, or if r is a lone UTF-16 surrogate, which has
+// none, the 3 bytes it would have
+func AppendRune(dst []byte, r rune) []byte {
+	if r < 0xd800 || r >= 0xe000 {
+		return utf8.AppendRune(dst, r)
+	}
+	return append(dst, 0xed, byte(0x80|r>>6&0x3f), byte(0x80|r&0x3f))
+}
+
+// unicodeEscape decodes the \uXXXX or \UXXXXXXXX escape at b[i:], returning the code
+// point and the length of the escape
+func unicodeEscape(b []byte, i int) (rune, int, error) {
+	if i+2 > len(b) || b[i] != '\\' || (b[i+1] != 'u' && b[i+1] != 'U') {
+		return 0, 0, fmt.Errorf("no unicode escape at position %d", i)
+	}
+	digits, reason := 4, "truncated \\uXXXX escape"
+	if b[i+1] == 'U' {
+		digits, reason = 8, "truncated \\UXXXXXXXX escape"
+	}
+	var r uint32
+	for k := i + 2; k < i+2+digits; k++ {
+		if k == len(b) || unhex(b[k]) < 0 {
+			return 0, 0, &DecodeError{Codec: "rawunicodeescape", Byte: b[i], Start: i, End: k, Reason: reason}
+		}
+		r = r<<4 | uint32(unhex(b[k]))
+	}
+	if r > utf8.MaxRune {
+		return 0, 0, &DecodeError{Codec: "rawunicodeescape", Byte: b[i], Start: i, End: i + 2 + digits, Reason: "\\Uxxxxxxxx out of range"}
+	}
+	return rune(r), 2 + digits, nil
+}
+
+// InvalidUTF8 returns the length of the invalid UTF-8 sequence at the start of s, which is
+// the longest prefix of a valid sequence, or 1, and why it is invalid. Python replaces it
+// with one U+FFFD.
+func InvalidUTF8(s []byte) (int, string) {
+	lo, hi := byte(0x80), byte(0xbf)
+	var need int
+	switch c := s[0]; {
+	case c >= 0xc2 && c <= 0xdf:
+		need = 1
+	case c == 0xe0:
+		need, lo = 2, 0xa0
+	case c == 0xed:
+		need, hi = 2, 0x9f
+	case c >= 0xe1 && c <= 0xef:
+		need = 2
+	case c == 0xf0:
+		need, lo = 3, 0x90
+	case c >= 0xf1 && c <= 0xf3:
+		need = 3
+	case c == 0xf4:
+		need, hi = 3, 0x8f
+	default:
+		return 1, "invalid start byte"
+	}
+	n := 1
+	for n <= need && n < len(s) && s[n] >= lo && s[n] <= hi {
+		n++
+		lo, hi = 0x80, 0xbf
+	}
+	if n <= need && n == len(s) {
+		return n, "unexpected end of data"
+	}
+	return n, "invalid continuation byte"
+}
+
+// ValidUTF8 returns a *DecodeError if s isn't UTF-8, allowing lone surrogates if surrogates
+// is true, as Python's surrogatepass error handler does
+func ValidUTF8(s []byte, surrogates bool) error {
+	for i := 0; i < len(s); {
+		if s[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if r, n := utf8.DecodeRune(s[i:]); r != utf8.RuneError || n != 1 {
+			i += n
+			continue
+		}
+		if surrogates && len(s)-i >= 3 && s[i] == 0xed && s[i+1]&0xe0 == 0xa0 && s[i+2]&0xc0 == 0x80 {
+			i += 3
+			continue
+		}
+		n, reason := InvalidUTF8(s[i:])
+		return &DecodeError{Codec: "utf-8", Byte: s[i], Start: i, End: i + n, Reason: reason}
+	}
+	return nil
+}
+
+// unhex returns the value of the hex digit c, or -1 if c isn't one
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
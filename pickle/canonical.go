@@ -0,0 +1,163 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// JSONOptions.Canonical writes objects which are equal in Python as the same JSON text. The
+// only freedom the JSON of an object otherwise has is the order of the keys of dicts and of
+// the elements of sets, which follows the pickle, and how numbers which are equal but of
+// different types or precisions, like 1 and 1.0, or Decimal("1.5") and Decimal("1.50"), are
+// written.
+
+// isIntegral returns whether x is finite and has no fraction, in which case Canonical writes
+// it as an int
+func isIntegral(x float64) bool {
+	return x == math.Trunc(x) && !math.IsInf(x, 0)
+}
+
+// appendIntegral appends an integral float with all its digits, as Python's int() would
+// give them, and negative zero as 0
+func appendIntegral(dst []byte, x float64) []byte {
+	if x == 0 {
+		x = 0
+	}
+	return strconv.AppendFloat(dst, x, 'f', 0, 64)
+}
+
+// normalDecimal returns a finite decimal without trailing zeros, as Python's
+// Decimal.normalize() leaves it, and zero as 0, whatever its sign and exponent
+func normalDecimal(d *types.Decimal) *types.Decimal {
+	digits := d.Coefficient.String()
+	trimmed := strings.TrimRight(digits, "0")
+	if trimmed == "" {
+		return &types.Decimal{Coefficient: new(big.Int)}
+	}
+	n := &types.Decimal{
+		Negative:    d.Negative,
+		Coefficient: new(big.Int),
+		Exponent:    d.Exponent + len(digits) - len(trimmed),
+	}
+	n.Coefficient.SetString(trimmed, 10)
+	return n
+}
+
+// appendKey appends a dict key as a JSON string, or returns false if it can't be one
+func (e *JSONEncoder) appendKey(dst []byte, key types.Object) ([]byte, bool) {
+	if f, ok := key.(types.Float); ok && e.opts.Canonical && isIntegral(float64(f)) {
+		dst = append(dst, '"')
+		dst = appendIntegral(dst, float64(f))
+		return append(dst, '"'), true
+	}
+	if !e.escapeKey(key) {
+		return types.AppendJSONKey(dst, key)
+	}
+	// put a '$' after the opening quote
+	n := len(dst)
+	dst, _ = types.AppendJSONKey(dst, key)
+	dst = append(dst, 0)
+	copy(dst[n+2:], dst[n+1:])
+	dst[n+1] = '$'
+	return dst, true
+}
+
+// sortDict returns d with its keys sorted by their string forms, in code point order. Keys
+// which can't be JSON object keys are left at the end, and an "$id" key first. Of two keys
+// with the same string form, like 1 and "1", the one which isn't a string comes first.
+func (e *JSONEncoder) sortDict(d types.Dict) types.Dict {
+	start := 0
+	if len(d) >= 2 && d[0] == types.Object(&idKey) {
+		start = 2
+	}
+	type pair struct {
+		key      string
+		isKey    bool
+		isString bool
+		i        int
+	}
+	pairs := make([]pair, 0, (len(d)-start)/2)
+	for i := start; i+1 < len(d); i += 2 {
+		_, isString := d[i].(types.String)
+		p := pair{isString: isString, i: i}
+		if f, ok := d[i].(types.Float); ok && isIntegral(float64(f)) {
+			p.key, p.isKey = string(appendIntegral(nil, float64(f))), true
+		} else {
+			p.key, p.isKey = keyString(d[i])
+			if e.escapeKey(d[i]) {
+				p.key = "$" + p.key
+			}
+		}
+		pairs = append(pairs, p)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].isKey != pairs[j].isKey {
+			return pairs[i].isKey
+		}
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return !pairs[i].isString && pairs[j].isString
+	})
+	sorted := make(types.Dict, 0, len(d))
+	sorted = append(sorted, d[:start]...)
+	for _, p := range pairs {
+		sorted = append(sorted, d[p.i], d[p.i+1])
+	}
+	return sorted
+}
+
+// sortSet returns the elements of a set sorted by their JSON text, if they are all strings,
+// bytes or scalars. Otherwise it returns them in their order in the pickle.
+func (e *JSONEncoder) sortSet(items []types.Object) []types.Object {
+	if len(items) < 2 {
+		return items
+	}
+	texts := make([][]byte, len(items))
+	for i, item := range items {
+		var err error
+		switch o := item.(type) {
+		case types.String:
+			texts[i], _ = types.AppendJSONKey(nil, o)
+		case types.Int:
+			texts[i] = strconv.AppendInt(nil, int64(o), 10)
+		case *types.Long:
+			texts[i] = (*big.Int)(o).Append(nil, 10)
+		case types.Bool:
+			texts[i] = strconv.AppendBool(nil, bool(o))
+		case types.None:
+			texts[i] = []byte("null")
+		case types.Float:
+			texts[i], err = e.appendFloat(nil, o)
+		case types.ByteArray:
+			texts[i] = e.appendBytes(nil, o)
+		default:
+			return items
+		}
+		if err != nil {
+			return items
+		}
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(texts[order[i]], texts[order[j]]) < 0
+	})
+	sorted := make([]types.Object, len(items))
+	for i, j := range order {
+		sorted[i] = items[j]
+	}
+	return sorted
+}
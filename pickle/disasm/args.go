@@ -0,0 +1,311 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/mistsys/gopickle2json/internal/codecs"
+)
+
+// The argument readers follow pickletools' ones, which are named after the encodings they
+// read, and return the same errors.
+
+// readArg reads an argument encoded as kind, which is the name pickletools gives it
+func (d *disassembler) readArg(kind string) (any, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "uint1":
+		b, err := d.read(1, kind)
+		if err != nil {
+			return nil, err
+		}
+		return int64(b[0]), nil
+	case "uint2":
+		b, err := d.read(2, kind)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.LittleEndian.Uint16(b)), nil
+	case "int4":
+		return d.readInt4()
+	case "uint4":
+		b, err := d.read(4, kind)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.LittleEndian.Uint32(b)), nil
+	case "uint8":
+		return d.readUint8()
+	case "float8":
+		b, err := d.read(8, kind)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case "long1", "long4":
+		var n int64
+		if kind == "long1" {
+			b, err := d.read(1, "uint1")
+			if err != nil {
+				return nil, err
+			}
+			n = int64(b[0])
+		} else {
+			i, err := d.readInt4()
+			if err != nil {
+				return nil, err
+			}
+			if n = i.(int64); n < 0 {
+				return nil, fmt.Errorf("long4 byte count < 0: %d", n)
+			}
+		}
+		data := d.take(n)
+		if int64(len(data)) != n {
+			return nil, fmt.Errorf("not enough data in stream to read %s", kind)
+		}
+		return decodeLong(data), nil
+	case "decimalnl_short", "decimalnl_long":
+		s, err := d.readLine("stringnl")
+		if err != nil {
+			return nil, err
+		}
+		if kind == "decimalnl_short" {
+			// a hack for True and False, which Python 2 pickles as INT 01 and 00
+			switch string(s) {
+			case "00":
+				return false, nil
+			case "01":
+				return true, nil
+			}
+		} else if len(s) != 0 && s[len(s)-1] == 'L' {
+			s = s[:len(s)-1]
+		}
+		return parseInt(s)
+	case "floatnl":
+		s, err := d.readLine("stringnl")
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(string(bytes.TrimSpace(s)), 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("could not convert string to float: %s", bytesRepr(s))
+		}
+		return f, nil
+	case "stringnl":
+		return d.readStringNL(true)
+	case "stringnl_noescape":
+		return d.readStringNL(false)
+	case "stringnl_noescape_pair":
+		module, err := d.readStringNL(false)
+		if err != nil {
+			return nil, err
+		}
+		name, err := d.readStringNL(false)
+		if err != nil {
+			return nil, err
+		}
+		return module.(string) + " " + name.(string), nil
+	case "string1", "string4":
+		data, err := d.readCounted(kind)
+		if err != nil {
+			return nil, err
+		}
+		// Python 2 strs are bytes, which pickletools decodes as latin-1
+		s := make([]byte, 0, len(data))
+		for _, c := range data {
+			s = utf8.AppendRune(s, rune(c))
+		}
+		return string(s), nil
+	case "bytes1", "bytes4", "bytes8", "bytearray8":
+		return d.readCounted(kind)
+	case "unicodestringnl":
+		s, err := d.readLine(kind)
+		if err != nil {
+			return nil, err
+		}
+		s, err = codecs.DecodeRawUnicodeEscape(s)
+		if err != nil {
+			return nil, err
+		}
+		return string(s), nil
+	case "unicodestring1", "unicodestring4", "unicodestring8":
+		data, err := d.readCounted(kind)
+		if err != nil {
+			return nil, err
+		}
+		if err := codecs.ValidUTF8(data, true); err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return nil, fmt.Errorf("unknown argument encoding %s", kind)
+}
+
+// take returns the next n bytes, or as many as there are
+func (d *disassembler) take(n int64) []byte {
+	if rest := int64(len(d.data) - d.pos); n > rest {
+		n = rest
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+// read returns the next n bytes of an integer encoded as kind
+func (d *disassembler) read(n int64, kind string) ([]byte, error) {
+	b := d.take(n)
+	if int64(len(b)) != n {
+		return nil, fmt.Errorf("not enough data in stream to read %s", kind)
+	}
+	return b, nil
+}
+
+func (d *disassembler) readInt4() (any, error) {
+	b, err := d.read(4, "int4")
+	if err != nil {
+		return nil, err
+	}
+	return int64(int32(binary.LittleEndian.Uint32(b))), nil
+}
+
+func (d *disassembler) readUint8() (any, error) {
+	b, err := d.read(8, "uint8")
+	if err != nil {
+		return nil, err
+	}
+	u := binary.LittleEndian.Uint64(b)
+	if u > math.MaxInt64 {
+		return new(big.Int).SetUint64(u), nil
+	}
+	return int64(u), nil
+}
+
+// readCounted reads the bytes of an argument encoded as kind, which are preceded by their
+// length
+func (d *disassembler) readCounted(kind string) ([]byte, error) {
+	var n int64
+	switch kind {
+	case "string1", "bytes1", "unicodestring1":
+		b, err := d.read(1, "uint1")
+		if err != nil {
+			return nil, err
+		}
+		n = int64(b[0])
+	case "string4":
+		i, err := d.readInt4()
+		if err != nil {
+			return nil, err
+		}
+		if n = i.(int64); n < 0 {
+			return nil, fmt.Errorf("string4 byte count < 0: %d", n)
+		}
+	case "bytes4", "unicodestring4":
+		b, err := d.read(4, "uint4")
+		if err != nil {
+			return nil, err
+		}
+		n = int64(binary.LittleEndian.Uint32(b))
+	default:
+		u, err := d.readUint8()
+		if err != nil {
+			return nil, err
+		}
+		if large, ok := u.(*big.Int); ok {
+			return nil, fmt.Errorf("%s byte count > sys.maxsize: %s", kind, large)
+		}
+		n = u.(int64)
+	}
+	data := d.take(n)
+	if int64(len(data)) != n {
+		return nil, fmt.Errorf("expected %d bytes in a %s, but only %d remain", n, kind, len(data))
+	}
+	return data, nil
+}
+
+// readLine reads a newline terminated argument encoded as kind, without the newline
+func (d *disassembler) readLine(kind string) ([]byte, error) {
+	i := bytes.IndexByte(d.data[d.pos:], '\n')
+	if i < 0 {
+		d.pos = len(d.data)
+		return nil, fmt.Errorf("no newline found when trying to read %s", kind)
+	}
+	line := d.data[d.pos : d.pos+i]
+	d.pos += i + 1
+	return line, nil
+}
+
+// readStringNL reads the argument of STRING, which is quoted, or of PERSID, GLOBAL and INST,
+// which isn't, decoding its escapes
+func (d *disassembler) readStringNL(quoted bool) (any, error) {
+	s, err := d.readLine("stringnl")
+	if err != nil {
+		return nil, err
+	}
+	if quoted {
+		switch {
+		case len(s) != 0 && (s[0] == '"' || s[0] == '\''):
+			if q := s[0]; s[len(s)-1] != q {
+				return nil, fmt.Errorf("strinq quote %s not found at both ends of %s", bytesRepr([]byte{q}), bytesRepr(s))
+			}
+			// a lone quote is at both ends, as far as pickletools is concerned
+			s = s[1:]
+			if len(s) != 0 {
+				s = s[:len(s)-1]
+			}
+		default:
+			return nil, fmt.Errorf("no string quotes around %s", bytesRepr(s))
+		}
+	}
+	s, err = codecs.EscapeDecode(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range s {
+		if c >= utf8.RuneSelf {
+			return nil, &codecs.DecodeError{Codec: "ascii", Byte: c, Start: i, End: i + 1, Reason: "ordinal not in range(128)"}
+		}
+	}
+	return string(s), nil
+}
+
+// parseInt parses a decimal integer as Python's int() does
+func parseInt(s []byte) (any, error) {
+	t := string(bytes.TrimSpace(s))
+	if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+		return i, nil
+	}
+	if i, ok := new(big.Int).SetString(t, 10); ok {
+		return i, nil
+	}
+	return nil, fmt.Errorf("invalid literal for int() with base 10: %s", bytesRepr(s))
+}
+
+// decodeLong decodes the little endian two's complement integer of LONG1 and LONG4
+func decodeLong(data []byte) any {
+	if len(data) == 0 {
+		return int64(0)
+	}
+	be := make([]byte, len(data))
+	for i, c := range data {
+		be[len(data)-1-i] = c
+	}
+	i := new(big.Int).SetBytes(be)
+	if data[len(data)-1]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(8*len(data))))
+	}
+	if i.IsInt64() {
+		return i.Int64()
+	}
+	return i
+}
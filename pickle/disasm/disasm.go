@@ -0,0 +1,340 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package disasm disassembles pickles as Python's pickletools.dis does, for finding out
+// what is in a pickle, or why it doesn't decode, without Python.
+//
+// Like pickletools, it emulates the stack and memo well enough to check that opcodes pop
+// only what is there, that MARKs match, and that the memo is used consistently, and it
+// returns the errors pickletools raises for pickles which fail these checks or are
+// truncated or malformed.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mistsys/gopickle2json/pickle"
+)
+
+// Instruction is an opcode of a pickle, with its argument, and the state of the stack and
+// memo when it runs.
+type Instruction struct {
+	Offset int64 // of the opcode from the start of the pickle
+	Opcode pickle.Opcode
+	// Arg is the opcode's argument, or nil if it has none. Integers are an int64 or, if
+	// they don't fit, a *big.Int, except that INT's 00 and 01 are a bool, as Python 2
+	// pickles True and False. Floats are a float64, text is a string, decoded to UTF-8,
+	// and bytes and bytearrays are a []byte. The argument of GLOBAL and INST is the module
+	// and name, separated by a space.
+	Arg        any
+	StackDepth int   // number of objects on the stack before the opcode runs, not counting MARKs
+	MarkLevel  int   // number of MARKs on the stack before the opcode runs
+	Memo       int   // the memo index the opcode stores into or reads, or -1
+	MarkOffset int64 // the offset of the MARK the opcode pops, or -1
+}
+
+// Error is a problem found in a pickle, with the message pickletools gives for it.
+type Error struct {
+	Offset int64 // of the opcode with the problem, or of the end of the pickle
+	Msg    string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// Disassemble decodes the instructions of the pickle at the start of data, through its STOP
+// opcode. If the pickle is malformed, it returns the instructions before the problem, and the
+// instruction with the problem if its argument could be decoded, with an *Error.
+func Disassemble(data []byte) ([]Instruction, error) {
+	d := disassembler{data: data, memo: make(map[string]string)}
+	var insts []Instruction
+	for {
+		inst, _, err := d.next()
+		if inst.Opcode.Name != "" {
+			insts = append(insts, inst)
+		}
+		if err != nil {
+			return insts, err
+		}
+		if inst.Opcode.Code == '.' {
+			return insts, d.finish()
+		}
+	}
+}
+
+// Options are the options of Dis.
+type Options struct {
+	// Indent is the number of blanks each level of MARKs is indented by. Zero means 4, as
+	// in pickletools, and a negative number none.
+	Indent int
+	// Annotate, if nonzero, adds the description of each opcode to its line, aligned at
+	// about this column, as pickletools' annotate argument does.
+	Annotate int
+}
+
+// Dis writes a listing of the pickle at the start of data to w, one line for each
+// instruction, like pickletools.dis. Each line has the offset, the opcode byte, the stack
+// depth, and the mnemonic indented by the MARK level, followed by the argument, the MARK an
+// opcode pops, or the memo index MEMOIZE stores into. If the pickle is malformed, the
+// listing stops at the problem, which is returned as an *Error.
+func Dis(w io.Writer, data []byte, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	switch {
+	case o.Indent == 0:
+		o.Indent = 4
+	case o.Indent < 0:
+		o.Indent = 0
+	}
+	indent := strings.Repeat(" ", o.Indent)
+	annocol := o.Annotate
+	d := disassembler{data: data, memo: make(map[string]string)}
+	var line []byte
+	for {
+		inst, note, err := d.next()
+		if inst.Opcode.Name == "" {
+			return err
+		}
+		line = appendInstruction(line[:0], &inst, indent, note)
+		if o.Annotate != 0 {
+			// make a mild effort to align annotations, as pickletools does
+			for len(line) < annocol {
+				line = append(line, ' ')
+			}
+			if annocol = len(line); annocol > 50 {
+				annocol = o.Annotate
+			}
+			line = append(line, ' ')
+			line = append(line, inst.Opcode.Doc...)
+		}
+		line = append(line, '\n')
+		if _, werr := w.Write(line); werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		if inst.Opcode.Code == '.' {
+			break
+		}
+	}
+	if _, err := fmt.Fprintf(w, "highest protocol among opcodes = %d\n", d.maxProto); err != nil {
+		return err
+	}
+	return d.finish()
+}
+
+// appendInstruction appends the line of the listing for inst
+func appendInstruction(dst []byte, inst *Instruction, indent string, note string) []byte {
+	dst = append(dst, fmt.Sprintf("%5d: %-4s %3d ", inst.Offset, opcodeRepr(inst.Opcode.Code), inst.StackDepth)...)
+	for i := 0; i < inst.MarkLevel; i++ {
+		dst = append(dst, indent...)
+	}
+	dst = append(dst, inst.Opcode.Name...)
+	if inst.Arg == nil && note == "" {
+		return dst
+	}
+	for i := len(inst.Opcode.Name); i < 10; i++ {
+		dst = append(dst, ' ')
+	}
+	if inst.Arg != nil {
+		dst = append(dst, ' ')
+		dst = appendRepr(dst, inst.Arg, inst.Opcode.Arg)
+	}
+	if note != "" {
+		dst = append(dst, ' ')
+		dst = append(dst, note...)
+	}
+	return dst
+}
+
+// opcodeRepr returns the opcode as pickletools shows it, which is the repr() of the
+// character with its code, without the quotes
+func opcodeRepr(code byte) string {
+	switch {
+	case code == '\\':
+		return `\\`
+	case code >= ' ' && code < 0x7f:
+		return string(rune(code))
+	}
+	return fmt.Sprintf(`\x%02x`, code)
+}
+
+// disassembler emulates the stack and memo of a pickle, as pickletools does
+type disassembler struct {
+	data     []byte
+	pos      int
+	stack    []string          // the names pickletools gives the objects on the stack, and "mark"
+	marks    []int64           // offsets of the MARKs on the stack
+	memo     map[string]string // the names of the objects in the memo, by key
+	maxProto int
+	stopAt   int64
+}
+
+const markObject = "mark"
+
+// next decodes the next instruction and checks it against the stack and memo. It returns a
+// note about a MARK or the memo for the listing. If err isn't nil, inst has no opcode unless
+// the problem is with what it does to the stack or memo.
+func (d *disassembler) next() (inst Instruction, note string, err error) {
+	pos := int64(d.pos)
+	if d.pos >= len(d.data) {
+		return inst, "", &Error{Offset: pos, Msg: "pickle exhausted before seeing STOP"}
+	}
+	code := d.data[d.pos]
+	d.pos++
+	op, ok := opcodes[code]
+	if !ok {
+		return inst, "", &Error{Offset: pos, Msg: fmt.Sprintf("at position %d, opcode %s unknown", pos, bytesRepr([]byte{code}))}
+	}
+	arg, err := d.readArg(op.Arg)
+	if err != nil {
+		return inst, "", &Error{Offset: pos, Msg: err.Error()}
+	}
+	inst = Instruction{
+		Offset:     pos,
+		Opcode:     op,
+		Arg:        arg,
+		StackDepth: len(d.stack) - len(d.marks),
+		MarkLevel:  len(d.marks),
+		Memo:       -1,
+		MarkOffset: -1,
+	}
+	if op.Proto > d.maxProto {
+		d.maxProto = op.Proto
+	}
+	before, after := op.Before, op.After
+	pop := len(before)
+	var errMsg string
+
+	// see whether a MARK should be popped
+	if markIndex(before) >= 0 || (op.Name == "POP" && len(d.stack) != 0 && d.stack[len(d.stack)-1] == markObject) {
+		if len(d.marks) != 0 {
+			inst.MarkOffset = d.marks[len(d.marks)-1]
+			d.marks = d.marks[:len(d.marks)-1]
+			note = fmt.Sprintf("(MARK at %d)", inst.MarkOffset)
+			// pop everything at and after the topmost MARK
+			for len(d.stack) != 0 && d.stack[len(d.stack)-1] != markObject {
+				d.stack = d.stack[:len(d.stack)-1]
+			}
+			if len(d.stack) == 0 {
+				// DUP, for one, can pop a MARK as an object, which pickletools fails on
+				return Instruction{}, "", &Error{Offset: pos, Msg: "list index out of range"}
+			}
+			d.stack = d.stack[:len(d.stack)-1]
+			pop = markIndex(before)
+			if pop < 0 {
+				pop = 0
+			}
+		} else {
+			errMsg = "no MARK exists on stack"
+			note = errMsg
+		}
+	}
+
+	// check for correct memo usage
+	switch op.Name {
+	case "PUT", "BINPUT", "LONG_BINPUT", "MEMOIZE":
+		var key, argRepr string
+		if op.Name == "MEMOIZE" {
+			key, argRepr = strconv.Itoa(len(d.memo)), "None"
+			note = "(as " + key + ")"
+		} else {
+			key, argRepr = memoKey(arg), string(appendRepr(nil, arg, op.Arg))
+		}
+		switch _, defined := d.memo[key]; {
+		case defined:
+			errMsg = "memo key " + argRepr + " already defined"
+		case len(d.stack) == 0:
+			errMsg = "stack is empty -- can't store into memo"
+		case d.stack[len(d.stack)-1] == markObject:
+			errMsg = "can't store markobject in the memo"
+		default:
+			d.memo[key] = d.stack[len(d.stack)-1]
+		}
+		inst.Memo = memoIndex(key)
+	case "GET", "BINGET", "LONG_BINGET":
+		key := memoKey(arg)
+		if obj, defined := d.memo[key]; defined {
+			after = []string{obj}
+			inst.Memo = memoIndex(key)
+		} else {
+			errMsg = "memo key " + string(appendRepr(nil, arg, op.Arg)) + " has never been stored into"
+		}
+	}
+	if errMsg != "" {
+		// as pickletools does, the instruction is listed before the problem is reported
+		return inst, note, &Error{Offset: pos, Msg: errMsg}
+	}
+
+	// emulate the stack effects
+	if len(d.stack) < pop {
+		return inst, note, &Error{Offset: pos, Msg: fmt.Sprintf("tries to pop %d items from stack with only %d items", pop, len(d.stack))}
+	}
+	d.stack = d.stack[:len(d.stack)-pop]
+	if markIndex(after) >= 0 {
+		d.marks = append(d.marks, pos)
+	}
+	d.stack = append(d.stack, after...)
+	if code == '.' {
+		d.stopAt = pos
+	}
+	return inst, note, nil
+}
+
+// finish checks the stack once STOP has run
+func (d *disassembler) finish() error {
+	if len(d.stack) == 0 {
+		return nil
+	}
+	return &Error{Offset: d.stopAt, Msg: "stack not empty after STOP: [" + strings.Join(d.stack, ", ") + "]"}
+}
+
+func markIndex(items []string) int {
+	for i, item := range items {
+		if item == markObject {
+			return i
+		}
+	}
+	return -1
+}
+
+// memoKey returns the memo key which is the argument of PUT or GET, as a string so that
+// keys of any size can be compared, and True is 1, as in Python
+func memoKey(arg any) string {
+	switch a := arg.(type) {
+	case bool:
+		if a {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(a, 10)
+	}
+	return fmt.Sprint(arg)
+}
+
+// memoIndex returns the memo index for key, or -1 if it is too large for an int
+func memoIndex(key string) int {
+	i, err := strconv.Atoi(key)
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+// opcodes describes every opcode, from the Unpickler's table
+var opcodes = make(map[byte]pickle.Opcode)
+
+func init() {
+	for code := 0; code < 256; code++ {
+		if op, ok := pickle.LookupOpcode(byte(code)); ok {
+			opcodes[byte(code)] = op
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+// disCase is a pickle in testdata/dis.txt, with the listing pickletools.dis writes for it
+type disCase struct {
+	name string
+	data []byte
+	want string
+}
+
+// readDisCases reads testdata/dis.txt, which testdata/gen.py writes
+func readDisCases(t *testing.T) []disCase {
+	f, err := os.Open("testdata/dis.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var cases []disCase
+	var want strings.Builder
+	flush := func() {
+		if len(cases) != 0 {
+			cases[len(cases)-1].want = want.String()
+		}
+		want.Reset()
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "--- ") {
+			flush()
+			name, h, _ := strings.Cut(line[len("--- "):], " ")
+			data, err := hex.DecodeString(h)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			cases = append(cases, disCase{name: name, data: data})
+			continue
+		}
+		want.WriteString(line)
+		want.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	if len(cases) == 0 {
+		t.Fatal("no cases in testdata/dis.txt")
+	}
+	return cases
+}
+
+// dropStackDepth removes the stack depth column, which pickletools doesn't have, from the
+// lines of a listing
+func dropStackDepth(listing string) string {
+	lines := strings.SplitAfter(listing, "\n")
+	for i, line := range lines {
+		if len(line) > 16 && line[5] == ':' {
+			// "%5d: %-4s %3d " is 16 columns
+			lines[i] = line[:12] + line[16:]
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// TestDisGolden checks the listings and errors of Dis and Disassemble against those of
+// pickletools.dis, for pickles of each protocol and truncated and corrupted ones.
+func TestDisGolden(t *testing.T) {
+	for _, c := range readDisCases(t) {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			err := Dis(&b, c.data, nil)
+			got := dropStackDepth(b.String())
+			if err != nil {
+				if _, ok := err.(*Error); !ok {
+					t.Errorf("Dis returned a %T, not an *Error", err)
+				}
+				got += "ERROR: " + err.Error() + "\n"
+			}
+			if got != c.want {
+				t.Errorf("Dis(%x):\n%s\nwant:\n%s", c.data, got, c.want)
+			}
+
+			insts, err2 := Disassemble(c.data)
+			if (err == nil) != (err2 == nil) || err != nil && err.Error() != err2.Error() {
+				t.Errorf("Disassemble returned error %v, Dis %v", err2, err)
+			}
+			if n := strings.Count(b.String(), "\n") - strings.Count(c.want, "highest protocol"); len(insts) != n {
+				t.Errorf("Disassemble returned %d instructions, Dis listed %d", len(insts), n)
+			}
+		})
+	}
+}
+
+// TestDisOptions checks the stack depth column, which pickletools doesn't have, and Options.
+// Without the stack depth column, the listings are those of pickletools.dis with indentlevel
+// 4, 0 and 1, and annotate 0, 0 and 1.
+func TestDisOptions(t *testing.T) {
+	data := []byte("(I1\n(I2\nll.")
+	tests := []struct {
+		opts *Options
+		want string
+	}{
+		{nil, "" +
+			"    0: (      0 MARK\n" +
+			"    1: I      0     INT        1\n" +
+			"    4: (      1     MARK\n" +
+			"    5: I      1         INT        2\n" +
+			"    8: l      2         LIST       (MARK at 4)\n" +
+			"    9: l      2     LIST       (MARK at 0)\n" +
+			"   10: .      1 STOP\n" +
+			"highest protocol among opcodes = 0\n"},
+		{&Options{Indent: -1}, "" +
+			"    0: (      0 MARK\n" +
+			"    1: I      0 INT        1\n" +
+			"    4: (      1 MARK\n" +
+			"    5: I      1 INT        2\n" +
+			"    8: l      2 LIST       (MARK at 4)\n" +
+			"    9: l      2 LIST       (MARK at 0)\n" +
+			"   10: .      1 STOP\n" +
+			"highest protocol among opcodes = 0\n"},
+		{&Options{Indent: 1, Annotate: 1}, "" +
+			"    0: (      0 MARK Push markobject onto the stack.\n" +
+			"    1: I      0  INT        1 Push an integer or bool.\n" +
+			"    4: (      1  MARK         Push markobject onto the stack.\n" +
+			"    5: I      1   INT        2 Push an integer or bool.\n" +
+			"    8: l      2   LIST       (MARK at 4) Build a list out of the topmost stack slice, after markobject.\n" +
+			"    9: l      2  LIST       (MARK at 0)  Build a list out of the topmost stack slice, after markobject.\n" +
+			"   10: .      1 STOP                     Stop the unpickling machine.\n" +
+			"highest protocol among opcodes = 0\n"},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		if err := Dis(&b, data, test.opts); err != nil {
+			t.Errorf("Dis(%+v): %v", test.opts, err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("Dis(%+v):\n%s\nwant:\n%s", test.opts, got, test.want)
+		}
+	}
+}
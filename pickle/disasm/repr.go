@@ -0,0 +1,120 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package disasm
+
+import (
+	"bytes"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// appendRepr appends arg, an argument encoded as kind, as Python's repr() writes it
+func appendRepr(dst []byte, arg any, kind string) []byte {
+	switch a := arg.(type) {
+	case bool:
+		if a {
+			return append(dst, "True"...)
+		}
+		return append(dst, "False"...)
+	case int64:
+		return strconv.AppendInt(dst, a, 10)
+	case *big.Int:
+		return a.Append(dst, 10)
+	case float64:
+		return types.Float(a).AppendRepr(dst)
+	case string:
+		return appendStrRepr(dst, a)
+	case []byte:
+		if kind == "bytearray8" {
+			dst = append(dst, "bytearray("...)
+			dst = appendBytesRepr(dst, a)
+			return append(dst, ')')
+		}
+		return appendBytesRepr(dst, a)
+	}
+	return dst
+}
+
+// appendStrRepr appends s as Python's repr() writes a str. Lone surrogates, kept as the 3
+// bytes they would be encoded as, are escaped.
+func appendStrRepr(dst []byte, s string) []byte {
+	quote := byte('\'')
+	if strings.IndexByte(s, '\'') >= 0 && strings.IndexByte(s, '"') < 0 {
+		quote = '"'
+	}
+	dst = append(dst, quote)
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if n == 1 && i+3 <= len(s) && s[i] == 0xed && s[i+1]&0xe0 == 0xa0 && s[i+2]&0xc0 == 0x80 {
+			r, n = rune(s[i]&0xf)<<12|rune(s[i+1]&0x3f)<<6|rune(s[i+2]&0x3f), 3
+		}
+		i += n
+		switch {
+		case r == rune(quote) || r == '\\':
+			dst = append(dst, '\\', byte(r))
+		case r == '\t':
+			dst = append(dst, `\t`...)
+		case r == '\n':
+			dst = append(dst, `\n`...)
+		case r == '\r':
+			dst = append(dst, `\r`...)
+		case r < ' ' || r == 0x7f:
+			dst = appendHexEscape(dst, 'x', uint32(r), 2)
+		case r < utf8.RuneSelf || (unicode.IsPrint(r) && (r < 0xd800 || r >= 0xe000)):
+			dst = utf8.AppendRune(dst, r)
+		case r < 0x100:
+			dst = appendHexEscape(dst, 'x', uint32(r), 2)
+		case r < 0x10000:
+			dst = appendHexEscape(dst, 'u', uint32(r), 4)
+		default:
+			dst = appendHexEscape(dst, 'U', uint32(r), 8)
+		}
+	}
+	return append(dst, quote)
+}
+
+// appendBytesRepr appends b as Python's repr() writes bytes
+func appendBytesRepr(dst []byte, b []byte) []byte {
+	quote := byte('\'')
+	if bytes.IndexByte(b, '\'') >= 0 && bytes.IndexByte(b, '"') < 0 {
+		quote = '"'
+	}
+	dst = append(dst, 'b', quote)
+	for _, c := range b {
+		switch {
+		case c == quote || c == '\\':
+			dst = append(dst, '\\', c)
+		case c == '\t':
+			dst = append(dst, `\t`...)
+		case c == '\n':
+			dst = append(dst, `\n`...)
+		case c == '\r':
+			dst = append(dst, `\r`...)
+		case c < ' ' || c >= 0x7f:
+			dst = appendHexEscape(dst, 'x', uint32(c), 2)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, quote)
+}
+
+func bytesRepr(b []byte) string {
+	return string(appendBytesRepr(nil, b))
+}
+
+func appendHexEscape(dst []byte, kind byte, v uint32, digits int) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '\\', kind)
+	for shift := 4 * (digits - 1); shift >= 0; shift -= 4 {
+		dst = append(dst, hex[v>>uint(shift)&0xf])
+	}
+	return dst
+}
@@ -0,0 +1,81 @@
+# Writes dis.txt: pickles of each protocol, and truncated and corrupted ones, with the
+# listing pickletools.dis writes for each, and the error it raises, if any. Each case is a
+# "--- name hex" line followed by the listing. Run with Python 3.11:
+#
+#	python3 gen.py > dis.txt
+
+import binascii
+import collections
+import datetime
+import io
+import pickle
+import pickletools
+
+
+class C:
+    pass
+
+
+def inst(**kw):
+    c = C()
+    c.__dict__.update(kw)
+    return c
+
+
+shared = [1, 2]
+cyclic = []
+cyclic.append(cyclic)
+
+objs = {
+    'scalars': [None, True, False, 0, 255, 65536, -1, 2**31, 2**70, -2**70, 1.5, float('inf')],
+    'text': ['', 'hello', 'é€😀', 'a\\b\nc\r\x00', b'', b'\x00\xff', bytearray(b'ab')],
+    'containers': {'a': (), 'b': (1,), 'c': (1, 2), 'd': (1, 2, 3), 'e': (1, 2, 3, 4),
+                   'f': {1, 2}, 'g': frozenset([3]), 'h': [[], {}]},
+    'memo': [shared, shared, cyclic],
+    'classes': [datetime.datetime(2022, 1, 2, 3, 4, 5, 6), collections.OrderedDict(x=1),
+                inst(x=1, y='z')],
+}
+
+cases = []
+for name, obj in objs.items():
+    for proto in range(6):
+        cases.append(('%s-%d' % (name, proto), pickle.dumps(obj, proto)))
+
+# Truncated and corrupted copies of some of them.
+for name, data in list(cases):
+    if not name.endswith(('-0', '-2', '-4')):
+        continue
+    cases.append((name + '-truncated', data[:len(data) * 2 // 3]))
+    b = bytearray(data)
+    b[len(b) // 2] = 0xfe
+    cases.append((name + '-corrupted', bytes(b)))
+
+cases += [
+    ('empty', b''),
+    ('no-stop', b'N'),
+    ('unknown-opcode', b'\xca.'),
+    ('pop-empty', b'0.'),
+    ('stack-not-empty', b'NN.'),
+    ('no-mark', b'e.'),
+    ('mark-left', b'(.'),
+    ('memo-redefined', b'Np0\np0\n.'),
+    ('memo-missing', b'g5\n.'),
+    ('put-mark', b'(p0\n.'),
+    ('memoize-twice', b'\x80\x04N\x94\x94.'),
+    ('bad-int', b'I12L\n.'),
+    ('bad-float', b'Fabc\n.'),
+    ('bad-string', b"Sabc'\n."),
+    ('bad-escape', b"S'\\x4'\n."),
+    ('bad-utf8', b'X\x02\x00\x00\x00\xed\xa0.'),
+    ('negative-length', b'T\xff\xff\xff\xff.'),
+    ('short-frame', b'\x80\x04\x95\x05\x00\x00\x00\x00\x00\x00\x00K\x01\x94.'),
+]
+
+for name, data in cases:
+    print('---', name, binascii.hexlify(data).decode())
+    out = io.StringIO()
+    try:
+        pickletools.dis(data, out)
+    except Exception as e:
+        out.write('ERROR: %s\n' % e)
+    print(out.getvalue(), end='')
@@ -0,0 +1,166 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// UnknownClassError is returned when the pickle refers to a class which
+// neither the builtin classes nor Unpickler.FindClass can provide.
+type UnknownClassError struct {
+	Module string
+	Name   string
+}
+
+func (e *UnknownClassError) Error() string {
+	return fmt.Sprintf("can't unpickle type %s.%s", e.Module, e.Name)
+}
+
+// ForbiddenClassError is returned when the pickle refers to a class which
+// Unpickler.ClassPolicy does not allow.
+type ForbiddenClassError struct {
+	Module string
+	Name   string
+}
+
+func (e *ForbiddenClassError) Error() string {
+	return fmt.Sprintf("class %s.%s is forbidden by the ClassPolicy", e.Module, e.Name)
+}
+
+// UnsupportedStateError is returned by BUILD when the object on the stack
+// has no way to accept the state it is given.
+type UnsupportedStateError struct {
+	Instance types.Object
+	State    types.Object
+	Reason   string
+}
+
+func (e *UnsupportedStateError) Error() string {
+	return fmt.Sprintf("BUILD can't set state of %T: %s", e.Instance, e.Reason)
+}
+
+// DecodeError is returned by Unpickler.Load when the pickle cannot be decoded.
+// It records where in the input the failing opcode was found.
+type DecodeError struct {
+	Offset     int64  // offset of the failing opcode from the start of the input
+	Op         byte   // the failing opcode (0 if none could be read)
+	Opcode     string // mnemonic of the failing opcode, like "SETITEMS". Empty if the opcode is unknown, or the input ended before an opcode could be read
+	InFrame    bool   // true if the opcode was inside a protocol 4 FRAME
+	StackDepth int    // number of items on the stack (above the topmost MARK) when the opcode failed
+	MarkDepth  int    // number of MARKs on the stack when the opcode failed
+	Err        error  // the underlying error
+}
+
+func (e *DecodeError) Error() string {
+	where := fmt.Sprintf("offset %d", e.Offset)
+	if e.Opcode != "" {
+		where = e.Opcode + " at " + where
+	}
+	if e.InFrame {
+		where += " in frame"
+	}
+	return fmt.Sprintf("pickle: %s (stack depth %d): %v", where, e.StackDepth, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// InvalidOpcodeError is returned for bytes which are not opcodes and, when
+// Unpickler.Strict is set, for opcodes newer than the pickle's protocol.
+type InvalidOpcodeError struct {
+	Op    byte
+	Proto byte // the protocol declared by the pickle (1 if it did not declare one)
+}
+
+func (e *InvalidOpcodeError) Error() string {
+	op := opcodes[e.Op]
+	if op.name == "" {
+		return fmt.Sprintf("unknown opcode 0x%02x", e.Op)
+	}
+	return fmt.Sprintf("opcode %s requires protocol %d, but the pickle is protocol %d", op.name, op.proto, e.Proto)
+}
+
+// UnsupportedKeyError is returned when converting a dict to JSON if one of its
+// keys has no JSON equivalent, like a tuple. See JSONOptions.CompositeKeys.
+type UnsupportedKeyError struct {
+	Key types.Object
+}
+
+func (e *UnsupportedKeyError) Error() string {
+	return fmt.Sprintf("dict key of type %T can't be a JSON object key", e.Key)
+}
+
+// UncomparableKeyError is returned by ToGo when a dict key or set element
+// converts to a Go value which can't be a map key, like the []any of a tuple.
+// See GoOptions.CompositeKeys and GoOptions.Tuples.
+type UncomparableKeyError struct {
+	Key   types.Object
+	Value any // the Go value of Key
+}
+
+func (e *UncomparableKeyError) Error() string {
+	return fmt.Sprintf("key of type %T converts to %T, which can't be a Go map key", e.Key, e.Value)
+}
+
+// UnmarshalTypeError is returned by UnmarshalObject, and Unmarshal, when an
+// object can't be stored in the Go value given for it.
+type UnmarshalTypeError struct {
+	Object types.Object
+	Type   reflect.Type // the type of the Go value
+	Field  string       // the path of the struct field, like "Outer.Inner", if the value is one
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("can't unmarshal %T into Go struct field %s of type %s", e.Object, e.Field, e.Type)
+	}
+	return fmt.Sprintf("can't unmarshal %T into Go value of type %s", e.Object, e.Type)
+}
+
+// UnsupportedFloatError is returned when converting NaN or an infinity to JSON
+// with JSONOptions.NonFiniteFloats set to NonFiniteError.
+type UnsupportedFloatError struct {
+	Value float64
+}
+
+func (e *UnsupportedFloatError) Error() string {
+	return fmt.Sprintf("float %v can't be represented in JSON", e.Value)
+}
+
+// LimitError is returned when a pickle, or the JSON written for it, exceeds
+// one of the Limits set on the Unpickler or JSONEncoder.
+type LimitError struct {
+	Limit string // the name of the Limits field which was exceeded, like "MaxDepth"
+	Max   int64  // the value of that field
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("exceeded Limits.%s of %d", e.Limit, e.Max)
+}
+
+// CycleError is returned when converting to JSON an object which contains
+// itself, with JSONOptions.Cycles set to CyclesError. The paths are JSON
+// Pointers in URI fragment form, like "#/a/0".
+type CycleError struct {
+	Path string // where the object is referred to from inside itself
+	Ref  string // where the object is
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("object at %s contains itself at %s", e.Ref, e.Path)
+}
+
+// UnsupportedTypeError is returned by Marshal, and Encoder.Encode, when a value
+// has no pickle representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "can't pickle value of type " + e.Type.String()
+}
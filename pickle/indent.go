@@ -0,0 +1,79 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+// jsonIndenter indents JSON text as json.Indent does, for JSONOptions.Prefix and Indent. The
+// JSONEncoder writes compact JSON, which is indented as it is flushed, a buffer at a time, so
+// the indenter keeps its place between buffers. Unlike json.Indent it doesn't check the JSON,
+// which may hold NaN and Infinity.
+type jsonIndenter struct {
+	depth    int
+	inString bool
+	escaped  bool // the previous byte of the string was a backslash
+	opened   bool // the previous byte opened an object or array
+}
+
+// append appends src, indented, to dst
+func (d *jsonIndenter) append(dst, src []byte, prefix, indent string) []byte {
+	for _, c := range src {
+		if d.inString {
+			switch {
+			case d.escaped:
+				d.escaped = false
+			case c == '\\':
+				d.escaped = true
+			case c == '"':
+				d.inString = false
+			}
+			dst = append(dst, c)
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			// objects provided by FindClass may write their own whitespace
+			continue
+		}
+		if d.opened {
+			d.opened = false
+			if c == '}' || c == ']' {
+				// empty objects and arrays stay on one line
+				d.depth--
+				dst = append(dst, c)
+				continue
+			}
+			dst = d.newline(dst, prefix, indent)
+		}
+		switch c {
+		case '"':
+			d.inString = true
+			dst = append(dst, c)
+		case '{', '[':
+			dst = append(dst, c)
+			d.depth++
+			d.opened = true
+		case '}', ']':
+			d.depth--
+			dst = d.newline(dst, prefix, indent)
+			dst = append(dst, c)
+		case ',':
+			dst = append(dst, c)
+			dst = d.newline(dst, prefix, indent)
+		case ':':
+			dst = append(dst, ':', ' ')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+func (d *jsonIndenter) newline(dst []byte, prefix, indent string) []byte {
+	dst = append(dst, '\n')
+	dst = append(dst, prefix...)
+	for i := 0; i < d.depth; i++ {
+		dst = append(dst, indent...)
+	}
+	return dst
+}
@@ -0,0 +1,796 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// JSON returns the JSON text of obj, which is typically the result of
+// Unpickler.Load.
+//
+// Unlike obj.JSON, which panics when it meets an object with no JSON
+// representation (a class, for example), JSON returns an error. Objects it
+// cannot serialize produce a *types.UnserializableObjectError.
+func JSON(obj types.Object) (string, error) {
+	return JSONWithOptions(obj, JSONOptions{})
+}
+
+// JSONWithOptions is like JSON, but lets the caller choose how to represent
+// values which have no direct JSON equivalent.
+func JSONWithOptions(obj types.Object, opts JSONOptions) (string, error) {
+	var b strings.Builder
+	if err := NewJSONEncoder(&b, opts).Encode(obj); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// JSONOptions controls the conversion of objects to JSON. The zero value
+// follows Python's json.dumps.
+type JSONOptions struct {
+	// CompositeKeys decides what happens to dict keys which are neither strings
+	// nor scalars, like tuples. Scalar keys are always converted to strings, as
+	// json.dumps does: 1 becomes "1", True becomes "true" and None becomes "null".
+	CompositeKeys CompositeKeyPolicy
+
+	// BytesEncoding chooses how bytes and bytearray values are written.
+	BytesEncoding BytesEncoding
+
+	// FloatFormat chooses how finite floats are written.
+	FloatFormat FloatFormat
+
+	// NonFiniteFloats chooses how NaN and the infinities are written.
+	NonFiniteFloats NonFiniteFloatPolicy
+
+	// TimeFormat chooses how datetimes and timedeltas are written.
+	TimeFormat TimeFormat
+
+	// DecimalFormat chooses how decimal.Decimal values are written.
+	DecimalFormat DecimalFormat
+
+	// ComplexFormat chooses how complex numbers are written.
+	ComplexFormat ComplexFormat
+
+	// FractionFormat chooses how fractions.Fraction values are written.
+	FractionFormat FractionFormat
+
+	// Limits bounds the nesting and size of the JSON written. Only its
+	// MaxDepth and MaxOutput apply.
+	Limits Limits
+
+	// Cycles chooses what happens when a list, dict, set or instance contains
+	// itself, which Python's memo allows, as in a = []; a.append(a).
+	Cycles CyclePolicy
+
+	// PreserveSharing writes a list, dict, set or instance which is referred to
+	// more than once in full only the first time, with an "$id", and writes
+	// the later references as {"$ref": id}, as Json.NET does. A dict or
+	// instance gets an "$id" key, like {"$id": "1", "a": 1}, and a list or set
+	// is wrapped, like {"$id": "2", "$values": [1, 2]}. Cycles are written as
+	// references too, so the Cycles policy doesn't apply.
+	//
+	// With PreserveSharing, or Cycles set to CyclesRef, a dict key which begins
+	// with "$" is written with another "$" in front of it, so that "$id" is
+	// written as "$$id" and "$$x" as "$$$x". This keeps real keys from being
+	// taken for "$id", "$ref" or "$values", and a reader can undo it by
+	// removing the first "$" of every key which begins with "$$". JSON Pointers
+	// in "$ref"s refer to the keys as they are written.
+	PreserveSharing bool
+
+	// Objects chooses how instances of classes with no implementation of their
+	// own (*types.GenericObject) and the classes themselves are written.
+	Objects ObjectPolicy
+
+	// ClassKey adds a "__class__" key, holding the module and name of the
+	// class like "mymodule.MyClass", to the JSON objects ObjectAttributes
+	// writes for instances.
+	ClassKey bool
+
+	// Prefix and Indent, if either is set, write each element of a JSON
+	// object or array on a new line, beginning with Prefix followed by one
+	// copy of Indent for each level of nesting, as json.MarshalIndent does.
+	// Otherwise the JSON is compact.
+	Prefix string
+	Indent string
+
+	// Canonical writes objects which are equal in Python as the same JSON
+	// text, so it can be hashed or compared. Dict keys are sorted by their
+	// string form, and the elements of sets by their JSON text if they are all
+	// strings, bytes or scalars. Floats with no fraction are written as ints
+	// are, so 1.0 and 1 are both 1, and -0.0 is 0. Decimals are written
+	// without trailing zeros, as Decimal.normalize() leaves them. Dicts which
+	// CompositeKeysPairs writes as pairs keep their order.
+	Canonical bool
+}
+
+// CompositeKeyPolicy says how to encode a dict (or OrderedDict) which has keys
+// that can't be JSON object keys.
+type CompositeKeyPolicy int
+
+const (
+	// CompositeKeysError, the default, fails with an *UnsupportedKeyError, like
+	// json.dumps.
+	CompositeKeysError CompositeKeyPolicy = iota
+	// CompositeKeysSkip leaves out such keys and their values, like
+	// json.dumps(skipkeys=True).
+	CompositeKeysSkip
+	// CompositeKeysPairs writes the whole dict as a list of [key, value] pairs.
+	CompositeKeysPairs
+)
+
+// BytesEncoding says how to write bytes (and bytearray) values, which JSON has
+// no type for.
+type BytesEncoding int
+
+const (
+	// BytesBase64 writes a string holding the standard base64 encoding.
+	BytesBase64 BytesEncoding = iota
+	// BytesBase64URL writes a string holding the URL safe base64 encoding.
+	BytesBase64URL
+	// BytesHex writes a string holding the lowercase hex encoding.
+	BytesHex
+	// BytesUTF8 writes the bytes as a string if they are valid UTF-8, and falls
+	// back to BytesBase64 if they are not.
+	BytesUTF8
+	// BytesTagged writes an object {"__bytes__": "..."} holding the standard
+	// base64 encoding, so bytes can be told apart from strings.
+	BytesTagged
+)
+
+// FloatFormat says how to write finite floats.
+type FloatFormat int
+
+const (
+	// FloatRepr writes floats as Python's repr() and json.dumps do, so 1.0 is
+	// "1.0" and 1e21 is "1e+21".
+	FloatRepr FloatFormat = iota
+	// FloatShortest writes the shortest text which parses back to the same
+	// float, so 1.0 is "1" and 1e21 is "1e+21".
+	FloatShortest
+)
+
+// NonFiniteFloatPolicy says how to write NaN, +Inf and -Inf, which JSON can't
+// represent.
+type NonFiniteFloatPolicy int
+
+const (
+	// NonFinitePython writes NaN, Infinity and -Infinity, as json.dumps does.
+	// Most JSON parsers accept these, but they aren't strictly JSON.
+	NonFinitePython NonFiniteFloatPolicy = iota
+	// NonFiniteNull writes null.
+	NonFiniteNull
+	// NonFiniteString writes the strings "NaN", "Infinity" and "-Infinity".
+	NonFiniteString
+	// NonFiniteError fails with an *UnsupportedFloatError, like
+	// json.dumps(allow_nan=False).
+	NonFiniteError
+)
+
+// TimeFormat says how to write datetime.datetime and datetime.timedelta
+// values, which JSON has no type for.
+type TimeFormat int
+
+const (
+	// TimeISO8601 writes datetimes as strings in the format of Python's
+	// isoformat(), like "2022-10-05T01:02:03+00:00", and timedeltas as ISO 8601
+	// durations, like "P1DT2H3M4.5S".
+	TimeISO8601 TimeFormat = iota
+	// TimeEpochSeconds writes datetimes as the seconds since the Unix epoch, as
+	// Python's datetime.timestamp() returns them, except that naive datetimes
+	// are taken to be UTC. Timedeltas are written as their length in seconds.
+	// Dates and times of day are still written as ISO 8601 strings.
+	TimeEpochSeconds
+)
+
+// DecimalFormat says how to write decimal.Decimal values.
+type DecimalFormat int
+
+const (
+	// DecimalNumber writes a JSON number with all the decimal's digits, like
+	// 1.20 or -1E+5, which a parser may or may not keep. NaN and the infinities
+	// are written according to JSONOptions.NonFiniteFloats.
+	DecimalNumber DecimalFormat = iota
+	// DecimalString writes a string holding Python's str() of the decimal, like
+	// "1.20", "-1E+5" or "NaN".
+	DecimalString
+)
+
+// ComplexFormat says how to write complex numbers, which JSON has no type for.
+type ComplexFormat int
+
+const (
+	// ComplexObject writes an object {"real": re, "imag": im}.
+	ComplexObject ComplexFormat = iota
+	// ComplexPair writes a list [re, im].
+	ComplexPair
+)
+
+// FractionFormat says how to write fractions.Fraction values.
+type FractionFormat int
+
+const (
+	// FractionString writes a string holding Python's str() of the fraction,
+	// like "-3/4", or "3" if the denominator is 1.
+	FractionString FractionFormat = iota
+	// FractionObject writes an object {"numerator": n, "denominator": d}.
+	FractionObject
+)
+
+// CyclePolicy says what to do when an object contains itself, which JSON
+// can't represent.
+type CyclePolicy int
+
+const (
+	// CyclesError fails with a *CycleError.
+	CyclesError CyclePolicy = iota
+	// CyclesNull writes null in place of the reference which closes the cycle.
+	CyclesNull
+	// CyclesRef writes the reference which closes the cycle as an object
+	// {"$ref": "#/path"} holding the JSON Pointer, in URI fragment form, of the
+	// object it refers to. "#" is the whole document. Dict keys which begin
+	// with "$" are escaped as JSONOptions.PreserveSharing describes.
+	CyclesRef
+)
+
+// ObjectPolicy says how to write instances of classes which have no
+// implementation of their own, which the Unpickler loads as
+// *types.GenericObject, and such classes themselves (*types.GenericClass).
+type ObjectPolicy int
+
+const (
+	// ObjectAttributes writes an instance as json.dumps would: an instance of a
+	// subclass of list or dict as its items, and any other instance as an
+	// object of its attributes. Its constructor arguments, and any state other
+	// than attributes, are left out. A class is written as a string like
+	// "mymodule.MyClass".
+	ObjectAttributes ObjectPolicy = iota
+	// ObjectReduce writes an instance as an object holding what was needed to
+	// recreate it, like {"__class__": "mymodule.MyClass", "args": [...],
+	// "state": {...}}, with the "state" being its attributes, or any other BUILD
+	// state, or null if it has none. Instances of subclasses of list and dict
+	// also have "items", a list or object. A class is written as
+	// {"__class__": "mymodule.MyClass"}.
+	ObjectReduce
+	// ObjectError fails with a *types.UnserializableObjectError.
+	ObjectError
+	// ObjectNull writes instances and classes as null, leaving out whatever
+	// they hold.
+	ObjectNull
+)
+
+// jsonFlushSize is how much output a JSONEncoder buffers before writing it out
+const jsonFlushSize = 32 * 1024
+
+// JSONEncoder writes objects as JSON to an io.Writer. Output is buffered
+// internally, and written out as it accumulates, so large objects need not be
+// held in memory as JSON text.
+type JSONEncoder struct {
+	w       io.Writer
+	opts    JSONOptions
+	buf     []byte
+	scratch strings.Builder // for objects which can only write themselves to a strings.Builder
+	tc      *transcoder     // state kept between calls to Unpickler.Transcode
+	ind     jsonIndenter    // for Prefix and Indent, where the indentation is up to
+	ibuf    []byte          // the indented text of buf
+
+	// limits of the current call to Encode or Unpickler.Transcode, and what has been used
+	maxDepth  int
+	maxOutput int64
+	written   int64
+
+	levels []jsonLevel                // the containers being written, outermost first
+	deep   map[unsafe.Pointer]int     // the levels beyond shallowLevels, by identity
+	refs   map[unsafe.Pointer]jsonRef // for PreserveSharing, the containers in the object
+	nextID int                        // the last "$id" written
+}
+
+// NewJSONEncoder returns a JSONEncoder which writes to w.
+func NewJSONEncoder(w io.Writer, opts JSONOptions) *JSONEncoder {
+	return &JSONEncoder{
+		w:    w,
+		opts: opts,
+	}
+}
+
+// Encode writes the JSON text of obj to the encoder's writer, without a
+// trailing newline. Encode returns the first error from the writer, or an
+// error if obj can't be represented in JSON. In either case some of the JSON
+// may already have been written.
+func (e *JSONEncoder) Encode(obj types.Object) error {
+	return e.encodeWithin(obj, e.opts.Limits)
+}
+
+// encodeWithin is Encode, using the MaxDepth and MaxOutput of limits
+func (e *JSONEncoder) encodeWithin(obj types.Object, limits Limits) error {
+	e.start(limits)
+	if e.opts.PreserveSharing {
+		e.countRefs(obj, 0)
+	}
+	err := e.encode(obj)
+	if err == nil {
+		err = e.flush()
+	}
+	e.buf = e.buf[:0]
+	return err
+}
+
+// start begins writing a new object within limits
+func (e *JSONEncoder) start(limits Limits) {
+	e.maxDepth, e.maxOutput = limits.MaxDepth, limits.MaxOutput
+	e.written = 0
+	e.ind = jsonIndenter{}
+	e.levels = e.levels[:0]
+	// the maps can have entries left over from an error, or from the previous object
+	for ptr := range e.deep {
+		delete(e.deep, ptr)
+	}
+	for ptr := range e.refs {
+		delete(e.refs, ptr)
+	}
+	e.nextID = 0
+}
+
+func (e *JSONEncoder) flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	out := e.buf
+	if e.opts.Prefix != "" || e.opts.Indent != "" {
+		e.ibuf = e.ind.append(e.ibuf[:0], e.buf, e.opts.Prefix, e.opts.Indent)
+		out = e.ibuf
+	}
+	if e.maxOutput > 0 && e.written+int64(len(out)) > e.maxOutput {
+		return &LimitError{Limit: "MaxOutput", Max: e.maxOutput}
+	}
+	_, err := e.w.Write(out)
+	e.written += int64(len(out))
+	e.buf = e.buf[:0]
+	return err
+}
+
+func (e *JSONEncoder) encode(obj types.Object) error {
+	if len(e.buf) >= jsonFlushSize {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	switch o := obj.(type) {
+	case nil:
+		return &types.UnserializableObjectError{Type: "nil"}
+	case *types.SimpleString:
+		e.buf = append(e.buf, '"')
+		e.buf = append(e.buf, *o...)
+		e.buf = append(e.buf, '"')
+	case *types.EscapedString:
+		e.buf = types.AppendJSONString(e.buf, *o)
+	case types.Int:
+		e.buf = strconv.AppendInt(e.buf, int64(o), 10)
+	case *types.Long:
+		e.buf = (*big.Int)(o).Append(e.buf, 10)
+	case types.Bool:
+		if o {
+			e.buf = append(e.buf, "true"...)
+		} else {
+			e.buf = append(e.buf, "false"...)
+		}
+	case types.None:
+		e.buf = append(e.buf, "null"...)
+	case types.Float:
+		var err error
+		e.buf, err = e.appendFloat(e.buf, o)
+		return err
+	case types.ByteArray:
+		e.buf = e.appendBytes(e.buf, o)
+	case *types.Dict:
+		if done, err := e.reference(o, unsafe.Pointer(o)); done {
+			return err
+		}
+		return e.encodeDict(*o, unsafe.Pointer(o))
+	case *types.OrderedDict:
+		if done, err := e.reference(o, unsafe.Pointer(o)); done {
+			return err
+		}
+		return e.encodeDict(types.Dict(*o), unsafe.Pointer(o))
+	case *types.List:
+		if done, err := e.reference(o, unsafe.Pointer(o)); done {
+			return err
+		}
+		return e.encodeList(*o, unsafe.Pointer(o))
+	case types.Tuple:
+		return e.encodeList(o, nil)
+	case *types.Set:
+		if done, err := e.reference(o, unsafe.Pointer(o)); done {
+			return err
+		}
+		if e.opts.Canonical {
+			return e.encodeList(e.sortSet(*o), unsafe.Pointer(o))
+		}
+		return e.encodeList(*o, unsafe.Pointer(o))
+	case types.FrozenSet:
+		if e.opts.Canonical {
+			return e.encodeList(e.sortSet(o), nil)
+		}
+		return e.encodeList(o, nil)
+	case *types.DateTime:
+		if e.opts.TimeFormat == TimeEpochSeconds {
+			var err error
+			e.buf, err = e.appendFloat(e.buf, types.Float(o.Timestamp()))
+			return err
+		}
+		e.buf = append(e.buf, '"')
+		e.buf = o.AppendISO(e.buf)
+		e.buf = append(e.buf, '"')
+	case *types.Date:
+		e.buf = append(e.buf, '"')
+		e.buf = o.AppendISO(e.buf)
+		e.buf = append(e.buf, '"')
+	case *types.Time:
+		e.buf = append(e.buf, '"')
+		e.buf = o.AppendISO(e.buf)
+		e.buf = append(e.buf, '"')
+	case *types.TimeDelta:
+		if e.opts.TimeFormat == TimeEpochSeconds {
+			var err error
+			e.buf, err = e.appendFloat(e.buf, types.Float(o.TotalSeconds()))
+			return err
+		}
+		e.buf = append(e.buf, '"')
+		e.buf = o.AppendISO(e.buf)
+		e.buf = append(e.buf, '"')
+	case *types.Decimal:
+		return e.encodeDecimal(o)
+	case types.Complex:
+		return e.encodeComplex(o)
+	case *types.Fraction:
+		e.encodeFraction(o)
+	case *types.GenericClass:
+		return e.encodeClass(o)
+	case *types.GenericObject:
+		if e.opts.Objects == ObjectNull {
+			e.buf = append(e.buf, "null"...)
+			return nil
+		}
+		if done, err := e.reference(o, unsafe.Pointer(o)); done {
+			return err
+		}
+		return e.encodeInstance(o, nil)
+	case *types.ObjectClass:
+		return &types.UnserializableObjectError{Object: o, Type: "ObjectClass"}
+	case *types.OrderedDictClass:
+		return &types.UnserializableObjectError{Object: o, Type: "OrderedDictClass"}
+	default:
+		return e.encodeOther(obj)
+	}
+	return nil
+}
+
+// encodeOther encodes objects of types it doesn't know, like those provided by
+// FindClass, which know how to write themselves. Such objects report that they
+// have no JSON representation by panicking with a *types.UnserializableObjectError.
+func (e *JSONEncoder) encodeOther(obj types.Object) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ue, ok := r.(*types.UnserializableObjectError)
+			if !ok {
+				panic(r)
+			}
+			err = ue
+		}
+	}()
+	e.scratch.Reset()
+	obj.JSON(&e.scratch)
+	e.buf = append(e.buf, e.scratch.String()...)
+	return nil
+}
+
+// keys of the objects written for instances and classes
+var (
+	classKey = types.SimpleString("__class__")
+	argsKey  = types.SimpleString("args")
+	stateKey = types.SimpleString("state")
+	itemsKey = types.SimpleString("items")
+)
+
+// encodeInstance writes an instance of a GenericClass according to
+// JSONOptions.Objects. id is the instance's "$id", or nil.
+func (e *JSONEncoder) encodeInstance(o *types.GenericObject, id types.Object) error {
+	switch e.opts.Objects {
+	case ObjectError:
+		return &types.UnserializableObjectError{Object: o, Type: "GenericObject(" + o.Class.String() + ")"}
+	case ObjectReduce:
+		return e.encodeReduce(o, id)
+	}
+	switch {
+	case len(o.ListItems) != 0:
+		if id != nil {
+			return e.encodeDict(types.Dict{&idKey, id, &valuesKey, types.Tuple(o.ListItems)}, unsafe.Pointer(o))
+		}
+		return e.encodeList(o.ListItems, unsafe.Pointer(o))
+	case len(o.DictItems) != 0:
+		return e.encodeDict(withID(o.DictItems, id), unsafe.Pointer(o))
+	}
+	return e.encodeDict(withID(instanceAttributes(o, e.opts.ClassKey), id), unsafe.Pointer(o))
+}
+
+// instanceAttributes returns the attributes of an instance, preceded by its class if withClass is set
+func instanceAttributes(o *types.GenericObject, withClass bool) types.Dict {
+	attrs := o.Attributes()
+	if withClass {
+		attrs = append(types.Dict{&classKey, className(o.Class)}, attrs...)
+	}
+	return attrs
+}
+
+// encodeReduce writes an instance as an object of its class, args, state and items
+func (e *JSONEncoder) encodeReduce(o *types.GenericObject, id types.Object) error {
+	return e.encodeDict(withID(reduceFields(o), id), unsafe.Pointer(o))
+}
+
+// reduceFields returns the class, args, state and items of an instance, as ObjectReduce writes them
+func reduceFields(o *types.GenericObject) types.Dict {
+	var state types.Object = types.None{}
+	if o.State != nil {
+		state = o.State
+	} else if attrs := o.Attributes(); len(attrs) != 0 {
+		state = &attrs
+	}
+	fields := types.Dict{
+		&classKey, className(o.Class),
+		&argsKey, types.Tuple(o.ConstructorArgs),
+		&stateKey, state,
+	}
+	switch {
+	case len(o.ListItems) != 0:
+		fields = append(fields, &itemsKey, &o.ListItems)
+	case len(o.DictItems) != 0:
+		fields = append(fields, &itemsKey, &o.DictItems)
+	}
+	return fields
+}
+
+// encodeClass writes a GenericClass according to JSONOptions.Objects
+func (e *JSONEncoder) encodeClass(o *types.GenericClass) error {
+	switch e.opts.Objects {
+	case ObjectError:
+		return &types.UnserializableObjectError{Object: o, Type: "GenericClass(" + o.String() + ")"}
+	case ObjectReduce:
+		return e.encodeDict(types.Dict{&classKey, className(o)}, nil)
+	case ObjectNull:
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+	e.buf = types.AppendJSONString(e.buf, []byte(o.String()))
+	return nil
+}
+
+// className returns the module and name of a class, like "mymodule.MyClass", as a string object
+func className(c *types.GenericClass) types.Object {
+	return types.NewString([]byte(c.String()), new([]byte))
+}
+
+// encodeList writes l as a JSON list. ptr identifies the object l belongs to, if it is one
+// which can be referred to more than once.
+func (e *JSONEncoder) encodeList(l []types.Object, ptr unsafe.Pointer) error {
+	if err := e.enter(ptr, l, false); err != nil {
+		return err
+	}
+	level := len(e.levels) - 1
+	e.buf = append(e.buf, '[')
+	for i, o := range l {
+		if i != 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.levels[level].child = i
+		if err := e.encode(o); err != nil {
+			return err
+		}
+	}
+	e.buf = append(e.buf, ']')
+	e.leave()
+	return nil
+}
+
+// encodeDict writes d as a JSON object, or as a list of pairs. ptr is as for encodeList.
+func (e *JSONEncoder) encodeDict(d types.Dict, ptr unsafe.Pointer) error {
+	if len(d)&1 != 0 {
+		return fmt.Errorf("dict has an odd number of keys and values: %d", len(d))
+	}
+	if e.opts.CompositeKeys == CompositeKeysPairs {
+		for i := 0; i < len(d); i += 2 {
+			if !types.IsJSONKey(d[i]) {
+				return e.encodePairs(d, ptr)
+			}
+		}
+	}
+	if e.opts.Canonical {
+		d = e.sortDict(d)
+	}
+	if err := e.enter(ptr, d, true); err != nil {
+		return err
+	}
+	level := len(e.levels) - 1
+	e.buf = append(e.buf, '{')
+	first := true
+	for i := 0; i < len(d); i += 2 {
+		if !types.IsJSONKey(d[i]) {
+			if e.opts.CompositeKeys == CompositeKeysSkip {
+				continue
+			}
+			return &UnsupportedKeyError{Key: d[i]}
+		}
+		if !first {
+			e.buf = append(e.buf, ',')
+		}
+		first = false
+		e.buf, _ = e.appendKey(e.buf, d[i])
+		e.buf = append(e.buf, ':')
+		e.levels[level].child = i
+		if err := e.encode(d[i+1]); err != nil {
+			return err
+		}
+	}
+	e.buf = append(e.buf, '}')
+	e.leave()
+	return nil
+}
+
+// encodePairs writes d as a list of [key, value] lists
+func (e *JSONEncoder) encodePairs(d types.Dict, ptr unsafe.Pointer) error {
+	if err := e.enter(ptr, d, false); err != nil {
+		return err
+	}
+	level := len(e.levels) - 1
+	e.buf = append(e.buf, '[')
+	for i := 0; i < len(d); i += 2 {
+		if i != 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.levels[level].child = i / 2
+		if err := e.encodeList(d[i:i+2], nil); err != nil {
+			return err
+		}
+	}
+	e.buf = append(e.buf, ']')
+	e.leave()
+	return nil
+}
+
+func (e *JSONEncoder) appendBytes(dst []byte, a types.ByteArray) []byte {
+	switch e.opts.BytesEncoding {
+	case BytesBase64URL:
+		return appendBase64(dst, base64.URLEncoding, a)
+	case BytesHex:
+		dst = append(dst, '"')
+		n := len(dst)
+		dst = grow(dst, hex.EncodedLen(len(a)))
+		hex.Encode(dst[n:], a)
+		return append(dst, '"')
+	case BytesUTF8:
+		if utf8.Valid(a) {
+			return types.AppendJSONString(dst, a)
+		}
+		return appendBase64(dst, base64.StdEncoding, a)
+	case BytesTagged:
+		dst = append(dst, `{"__bytes__":`...)
+		dst = appendBase64(dst, base64.StdEncoding, a)
+		return append(dst, '}')
+	}
+	return appendBase64(dst, base64.StdEncoding, a)
+}
+
+// appendBase64 appends a quoted base64 encoding of a
+func appendBase64(dst []byte, enc *base64.Encoding, a []byte) []byte {
+	dst = append(dst, '"')
+	n := len(dst)
+	dst = grow(dst, enc.EncodedLen(len(a)))
+	enc.Encode(dst[n:], a)
+	return append(dst, '"')
+}
+
+// grow extends b by n bytes, leaving them for the caller to fill in
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) < n {
+		nb := make([]byte, len(b), 2*cap(b)+n)
+		copy(nb, b)
+		b = nb
+	}
+	return b[:len(b)+n]
+}
+
+func (e *JSONEncoder) appendFloat(dst []byte, f types.Float) ([]byte, error) {
+	x := float64(f)
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		switch e.opts.NonFiniteFloats {
+		case NonFiniteNull:
+			return append(dst, "null"...), nil
+		case NonFiniteString:
+			dst = append(dst, '"')
+			dst = f.AppendJSON(dst)
+			return append(dst, '"'), nil
+		case NonFiniteError:
+			return dst, &UnsupportedFloatError{Value: x}
+		}
+		return f.AppendJSON(dst), nil
+	}
+	if e.opts.Canonical && isIntegral(x) {
+		return appendIntegral(dst, x), nil
+	}
+	if e.opts.FloatFormat == FloatShortest {
+		return strconv.AppendFloat(dst, x, 'g', -1, 64), nil
+	}
+	return f.AppendRepr(dst), nil
+}
+
+func (e *JSONEncoder) encodeDecimal(d *types.Decimal) error {
+	if e.opts.Canonical && d.Form == types.DecimalFinite {
+		d = normalDecimal(d)
+	}
+	if e.opts.DecimalFormat == DecimalString {
+		e.buf = append(e.buf, '"')
+		e.buf = d.AppendString(e.buf)
+		e.buf = append(e.buf, '"')
+		return nil
+	}
+	if d.Form != types.DecimalFinite {
+		var err error
+		e.buf, err = e.appendFloat(e.buf, types.Float(d.Float64()))
+		return err
+	}
+	e.buf = d.AppendString(e.buf)
+	return nil
+}
+
+func (e *JSONEncoder) encodeComplex(c types.Complex) error {
+	var err error
+	if e.opts.ComplexFormat == ComplexPair {
+		e.buf = append(e.buf, '[')
+	} else {
+		e.buf = append(e.buf, `{"real":`...)
+	}
+	if e.buf, err = e.appendFloat(e.buf, types.Float(real(c))); err != nil {
+		return err
+	}
+	if e.opts.ComplexFormat == ComplexPair {
+		e.buf = append(e.buf, ',')
+	} else {
+		e.buf = append(e.buf, `,"imag":`...)
+	}
+	if e.buf, err = e.appendFloat(e.buf, types.Float(imag(c))); err != nil {
+		return err
+	}
+	if e.opts.ComplexFormat == ComplexPair {
+		e.buf = append(e.buf, ']')
+	} else {
+		e.buf = append(e.buf, '}')
+	}
+	return nil
+}
+
+func (e *JSONEncoder) encodeFraction(f *types.Fraction) {
+	r := (*big.Rat)(f)
+	if e.opts.FractionFormat == FractionObject {
+		e.buf = append(e.buf, `{"numerator":`...)
+		e.buf = r.Num().Append(e.buf, 10)
+		e.buf = append(e.buf, `,"denominator":`...)
+		e.buf = r.Denom().Append(e.buf, 10)
+		e.buf = append(e.buf, '}')
+		return
+	}
+	e.buf = append(e.buf, '"')
+	e.buf = append(e.buf, f.String()...)
+	e.buf = append(e.buf, '"')
+}
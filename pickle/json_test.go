@@ -0,0 +1,433 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// dictHolder is an object provided by FindClass, which writes itself as JSON with Dict.JSON
+type dictHolder struct{ d types.Dict }
+
+func (h *dictHolder) JSON(b *strings.Builder) { h.d.JSON(b) }
+
+func TestCompositeKeys(t *testing.T) {
+	d := &types.Dict{types.Tuple{types.Int(1), types.Int(2)}, types.Int(3)}
+	tests := []struct {
+		policy CompositeKeyPolicy
+		want   string
+	}{
+		{CompositeKeysSkip, "{}"},
+		{CompositeKeysPairs, "[[[1,2],3]]"},
+	}
+	for _, tt := range tests {
+		got, err := JSONWithOptions(d, JSONOptions{CompositeKeys: tt.policy})
+		if err != nil || got != tt.want {
+			t.Errorf("policy %d gave %s, %v, want %s", tt.policy, got, err, tt.want)
+		}
+	}
+
+	// by default, composite keys are an error for the encoder, while Dict.JSON, which
+	// can't return one, writes pairs
+	var ke *UnsupportedKeyError
+	if _, err := JSON(d); !errors.As(err, &ke) {
+		t.Errorf("JSON gave %v, want an UnsupportedKeyError", err)
+	}
+	if got, err := JSON(&dictHolder{*d}); err != nil || got != "[[[1,2],3]]" {
+		t.Errorf("JSON of an object using Dict.JSON gave %s, %v, want [[[1,2],3]]", got, err)
+	}
+}
+
+// TestCyclesGolden checks the JSON Pointers written for cycles, and the ids written for shared
+// objects, and that dict keys beginning with "$" are escaped when they could be taken for the
+// keys written for them.
+func TestCyclesGolden(t *testing.T) {
+	// l = [1]; l.append(l); e = {}; e['in'] = e
+	// d = {'$id': 'x', '$ref': 'y', 'a~b/c': {}, 'l': l, 'l2': l, 1: 'one', '$e': e}
+	// d['a~b/c']['self'] = d
+	p := "\x80\x02}q\x00(X\x03\x00\x00\x00$idq\x01X\x01\x00\x00\x00xq\x02X\x04\x00\x00\x00$refq\x03X\x01\x00\x00\x00yq\x04" +
+		"X\x05\x00\x00\x00a~b/cq\x05}q\x06X\x04\x00\x00\x00selfq\x07h\x00sX\x01\x00\x00\x00lq\x08]q\x09(K\x01h\x09e" +
+		"X\x02\x00\x00\x00l2q\nh\x09K\x01X\x03\x00\x00\x00oneq\x0bX\x02\x00\x00\x00$eq\x0c}q\x0dX\x02\x00\x00\x00inq\x0eh\x0dsu."
+	tests := []struct {
+		opts JSONOptions
+		want string
+		err  string
+	}{
+		{JSONOptions{}, "", "object at # contains itself at #/a~0b~1c/self"},
+		{JSONOptions{Cycles: CyclesNull},
+			`{"$id":"x","$ref":"y","a~b/c":{"self":null},"l":[1,null],"l2":[1,null],"1":"one","$e":{"in":null}}`, ""},
+		{JSONOptions{Cycles: CyclesRef},
+			`{"$$id":"x","$$ref":"y","a~b/c":{"self":{"$ref":"#"}},"l":[1,{"$ref":"#/l"}],"l2":[1,{"$ref":"#/l2"}],"1":"one","$$e":{"in":{"$ref":"#/$$e"}}}`, ""},
+		{JSONOptions{PreserveSharing: true},
+			`{"$id":"1","$$id":"x","$$ref":"y","a~b/c":{"self":{"$ref":"1"}},"l":{"$id":"2","$values":[1,{"$ref":"2"}]},"l2":{"$ref":"2"},"1":"one","$$e":{"$id":"3","in":{"$ref":"3"}}}`, ""},
+		{JSONOptions{PreserveSharing: true, Canonical: true},
+			`{"$id":"1","$$e":{"$id":"2","in":{"$ref":"2"}},"$$id":"x","$$ref":"y","1":"one","a~b/c":{"self":{"$ref":"1"}},"l":{"$id":"3","$values":[1,{"$ref":"3"}]},"l2":{"$ref":"3"}}`, ""},
+	}
+	for _, test := range tests {
+		for _, via := range []string{"Encode", "Transcode"} {
+			var b strings.Builder
+			enc := NewJSONEncoder(&b, test.opts)
+			u := NewUnpickler([]byte(p))
+			var err error
+			if via == "Encode" {
+				var obj types.Object
+				if obj, err = u.Load(); err != nil {
+					t.Fatal(err)
+				}
+				err = enc.Encode(obj)
+			} else {
+				err = u.Transcode(enc)
+			}
+			var ce *CycleError
+			switch {
+			case test.err != "":
+				if !errors.As(err, &ce) || ce.Error() != test.err {
+					t.Errorf("%s with %+v: error %v, want %q", via, test.opts, err, test.err)
+				}
+			case err != nil:
+				t.Errorf("%s with %+v: %v", via, test.opts, err)
+			case strings.TrimSuffix(b.String(), "\n") != test.want:
+				t.Errorf("%s with %+v:\n got %s\nwant %s", via, test.opts, b.String(), test.want)
+			}
+		}
+	}
+}
+
+// TestFormatGolden checks the JSON written with Canonical and with Prefix and Indent, for a dict
+// with keys of every scalar type, nested empty containers and sets, through both Encode and
+// Transcode.
+func TestFormatGolden(t *testing.T) {
+	// {'b': [], 'a': {}, 2: {'z': set(), 'y': ()}, 10: 'int', '10': 'str', 1.5: 'float',
+	//  3.0: 'whole', None: 'none', False: 'false', 'B': [{}, [[]], [set()]], '\uffff': 1,
+	//  '\U0001F600': 2, '\xe9': frozenset(['b', 'a']), 'n': {10, 2, 'x'},
+	//  'd': decimal.Decimal('1.500'), 'f': [-0.0, 2.0]}
+	p := "\x80\x02}q\x00(X\x01\x00\x00\x00bq\x01]q\x02X\x01\x00\x00\x00aq\x03}q\x04K\x02}q\x05(X\x01\x00\x00\x00zq\x06" +
+		"c__builtin__\nset\nq\x07]q\x08\x85q\x09Rq\nX\x01\x00\x00\x00yq\x0b)uK\nX\x03\x00\x00\x00intq\x0cX\x02\x00\x00" +
+		"\x0010q\x0dX\x03\x00\x00\x00strq\x0eG?\xf8\x00\x00\x00\x00\x00\x00X\x05\x00\x00\x00floatq\x0fG@\x08\x00\x00" +
+		"\x00\x00\x00\x00X\x05\x00\x00\x00wholeq\x10NX\x04\x00\x00\x00noneq\x11\x89X\x05\x00\x00\x00falseq\x12X\x01\x00" +
+		"\x00\x00Bq\x13]q\x14(}q\x15]q\x16]q\x17a]q\x18h\x07]q\x19\x85q\x1aRq\x1baeX\x03\x00\x00\x00\xef\xbf\xbfq\x1cK" +
+		"\x01X\x04\x00\x00\x00\xf0\x9f\x98\x80q\x1dK\x02X\x02\x00\x00\x00\xc3\xa9q\x1ec__builtin__\nfrozenset\nq\x1f]q " +
+		"(h\x03h\x01e\x85q!Rq\"X\x01\x00\x00\x00nq#h\x07]q$(K\nK\x02X\x01\x00\x00\x00xq%e\x85q&Rq'X\x01\x00\x00\x00dq(c" +
+		"decimal\nDecimal\nq)X\x05\x00\x00\x001.500q*\x85q+Rq,X\x01\x00\x00\x00fq-]q.(G\x80\x00\x00\x00\x00\x00\x00\x00" +
+		"G@\x00\x00\x00\x00\x00\x00\x00eu."
+	tests := []struct {
+		opts JSONOptions
+		want string
+	}{
+		{JSONOptions{}, `{"b":[],"a":{},"2":{"z":[],"y":[]},"10":"int","10":"str","1.5":"float","3.0":"whole",` +
+			`"null":"none","false":"false","B":[{},[[]],[[]]],"\uffff":1,"😀":2,"é":["a","b"],"n":[10,2,"x"],` +
+			`"d":1.500,"f":[-0.0,2.0]}`},
+		// keys are sorted in code point order, a key which isn't a string before the same
+		// string, and the elements of sets by their JSON
+		{JSONOptions{Canonical: true}, `{"1.5":"float","10":"int","10":"str","2":{"y":[],"z":[]},"3":"whole",` +
+			`"B":[{},[[]],[[]]],"a":{},"b":[],"d":1.5,"f":[0,2],"false":"false","n":["x",10,2],"null":"none",` +
+			`"é":["a","b"],"\uffff":1,"😀":2}`},
+		{JSONOptions{Indent: "  "}, `{
+  "b": [],
+  "a": {},
+  "2": {
+    "z": [],
+    "y": []
+  },
+  "10": "int",
+  "10": "str",
+  "1.5": "float",
+  "3.0": "whole",
+  "null": "none",
+  "false": "false",
+  "B": [
+    {},
+    [
+      []
+    ],
+    [
+      []
+    ]
+  ],
+  "\uffff": 1,
+  "😀": 2,
+  "é": [
+    "a",
+    "b"
+  ],
+  "n": [
+    10,
+    2,
+    "x"
+  ],
+  "d": 1.500,
+  "f": [
+    -0.0,
+    2.0
+  ]
+}`},
+		{JSONOptions{Prefix: ">", Indent: "\t", Canonical: true}, `{
+>	"1.5": "float",
+>	"10": "int",
+>	"10": "str",
+>	"2": {
+>		"y": [],
+>		"z": []
+>	},
+>	"3": "whole",
+>	"B": [
+>		{},
+>		[
+>			[]
+>		],
+>		[
+>			[]
+>		]
+>	],
+>	"a": {},
+>	"b": [],
+>	"d": 1.5,
+>	"f": [
+>		0,
+>		2
+>	],
+>	"false": "false",
+>	"n": [
+>		"x",
+>		10,
+>		2
+>	],
+>	"null": "none",
+>	"é": [
+>		"a",
+>		"b"
+>	],
+>	"\uffff": 1,
+>	"😀": 2
+>}`},
+		{JSONOptions{Prefix: "// "}, `{
+// "b": [],
+// "a": {},
+// "2": {
+// "z": [],
+// "y": []
+// },
+// "10": "int",
+// "10": "str",
+// "1.5": "float",
+// "3.0": "whole",
+// "null": "none",
+// "false": "false",
+// "B": [
+// {},
+// [
+// []
+// ],
+// [
+// []
+// ]
+// ],
+// "\uffff": 1,
+// "😀": 2,
+// "é": [
+// "a",
+// "b"
+// ],
+// "n": [
+// 10,
+// 2,
+// "x"
+// ],
+// "d": 1.500,
+// "f": [
+// -0.0,
+// 2.0
+// ]
+// }`},
+	}
+	for _, test := range tests {
+		// U+FFFF is written as it is, but is escaped above to be visible
+		want := strings.ReplaceAll(test.want, `\uffff`, "\uffff")
+		for _, via := range []string{"Encode", "Transcode"} {
+			got, err := encodeVia(via, p, test.opts)
+			if err != nil {
+				t.Errorf("%s with %+v: %v", via, test.opts, err)
+			} else if got != want {
+				t.Errorf("%s with %+v:\n got %s\nwant %s", via, test.opts, got, want)
+			}
+		}
+	}
+}
+
+// encodeVia writes the JSON of the pickle p with opts, through Load and Encode, or Transcode
+func encodeVia(via string, p string, opts JSONOptions) (string, error) {
+	var b strings.Builder
+	enc := NewJSONEncoder(&b, opts)
+	u := NewUnpickler([]byte(p))
+	var err error
+	if via == "Encode" {
+		var obj types.Object
+		if obj, err = u.Load(); err != nil {
+			return "", err
+		}
+		err = enc.Encode(obj)
+	} else {
+		err = u.Transcode(enc)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), err
+}
+
+// TestBytesEncoding writes bytes and bytearrays, which aren't UTF-8 and which are, with each
+// BytesEncoding
+func TestBytesEncoding(t *testing.T) {
+	// [b'\xfb\xff', bytearray(b'\xfb\xff'), b'a\xc3\xa9'] with protocols 2 and 5, which pickle
+	// bytes as a str to be encoded with latin1, and as bytes
+	pickles := []string{
+		"\x80\x02]q\x00(c_codecs\nencode\nq\x01X\x04\x00\x00\x00\xc3\xbb\xc3\xbfq\x02X\x06\x00\x00\x00latin1q\x03" +
+			"\x86q\x04Rq\x05c__builtin__\nbytearray\nq\x06h\x01X\x04\x00\x00\x00\xc3\xbb\xc3\xbfq\x07h\x03\x86q\x08" +
+			"Rq\t\x85q\nRq\x0bh\x01X\x05\x00\x00\x00a\xc3\x83\xc2\xa9q\x0ch\x03\x86q\rRq\x0ee.",
+		"\x80\x05\x95\x1c\x00\x00\x00\x00\x00\x00\x00]\x94(C\x02\xfb\xff\x94\x96\x02\x00\x00\x00\x00\x00\x00\x00" +
+			"\xfb\xff\x94C\x03a\xc3\xa9\x94e.",
+	}
+	tests := []struct {
+		enc  BytesEncoding
+		want string
+	}{
+		{BytesBase64, `["+/8=","+/8=","YcOp"]`},
+		{BytesBase64URL, `["-_8=","-_8=","YcOp"]`},
+		{BytesHex, `["fbff","fbff","61c3a9"]`},
+		{BytesUTF8, `["+/8=","+/8=","aé"]`},
+		{BytesTagged, `[{"__bytes__":"+/8="},{"__bytes__":"+/8="},{"__bytes__":"YcOp"}]`},
+	}
+	for _, tt := range tests {
+		for i, p := range pickles {
+			for _, via := range []string{"Encode", "Transcode"} {
+				got, err := encodeVia(via, p, JSONOptions{BytesEncoding: tt.enc})
+				if err != nil || got != tt.want {
+					t.Errorf("%s of pickle %d with BytesEncoding %d gave %s, %v, want %s", via, i, tt.enc, got, err, tt.want)
+				}
+			}
+		}
+	}
+}
+
+// TestNonFiniteFloats writes NaN and the infinities, as floats and as decimals, with each
+// NonFiniteFloatPolicy
+func TestNonFiniteFloats(t *testing.T) {
+	// [1.5, nan, inf, -inf, decimal.Decimal('-Infinity')] with protocols 0 and 2
+	pickles := []string{
+		"(lp0\nF1.5\naFnan\naFinf\naF-inf\nacdecimal\nDecimal\np1\n(V-Infinity\np2\ntp3\nRp4\na.",
+		"\x80\x02]q\x00(G?\xf8\x00\x00\x00\x00\x00\x00G\x7f\xf8\x00\x00\x00\x00\x00\x00G\x7f\xf0\x00\x00\x00\x00\x00\x00" +
+			"G\xff\xf0\x00\x00\x00\x00\x00\x00cdecimal\nDecimal\nq\x01X\t\x00\x00\x00-Infinityq\x02\x85q\x03Rq\x04e.",
+	}
+	tests := []struct {
+		policy NonFiniteFloatPolicy
+		want   string
+	}{
+		{NonFinitePython, `[1.5,NaN,Infinity,-Infinity,-Infinity]`},
+		{NonFiniteNull, `[1.5,null,null,null,null]`},
+		{NonFiniteString, `[1.5,"NaN","Infinity","-Infinity","-Infinity"]`},
+	}
+	for _, tt := range tests {
+		for i, p := range pickles {
+			for _, via := range []string{"Encode", "Transcode"} {
+				got, err := encodeVia(via, p, JSONOptions{NonFiniteFloats: tt.policy})
+				if err != nil || got != tt.want {
+					t.Errorf("%s of pickle %d with NonFiniteFloats %d gave %s, %v, want %s", via, i, tt.policy, got, err, tt.want)
+				}
+			}
+		}
+	}
+
+	// NonFiniteError fails at the first of them
+	errorTests := []struct {
+		pickle string
+		value  float64
+	}{
+		{pickles[0], math.NaN()},
+		{pickles[1], math.NaN()},
+		{"Finf\n.", math.Inf(1)},
+		{"\x80\x02G\xff\xf0\x00\x00\x00\x00\x00\x00.", math.Inf(-1)},
+		{"\x80\x02cdecimal\nDecimal\nq\x00X\t\x00\x00\x00-Infinityq\x01\x85q\x02Rq\x03.", math.Inf(-1)},
+	}
+	for _, tt := range errorTests {
+		for _, via := range []string{"Encode", "Transcode"} {
+			_, err := encodeVia(via, tt.pickle, JSONOptions{NonFiniteFloats: NonFiniteError})
+			var fe *UnsupportedFloatError
+			if !errors.As(err, &fe) || fe.Value != tt.value && !(math.IsNaN(fe.Value) && math.IsNaN(tt.value)) {
+				t.Errorf("%s of %q with NonFiniteError gave %v, want an UnsupportedFloatError of %v", via, tt.pickle, err, tt.value)
+			}
+		}
+	}
+}
+
+// failWriter accepts n bytes, and then fails every Write with err
+type failWriter struct {
+	n      int
+	err    error
+	writes int // the calls to Write after the first failure
+	failed bool
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		w.writes++
+		return 0, w.err
+	}
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return len(p), nil
+	}
+	n := w.n
+	w.n = 0
+	w.failed = true
+	return n, w.err
+}
+
+// TestJSONEncoderWriteError checks that Encode and Transcode return the first error from the
+// writer, whether it fails on the only write, or on one of the writes of a long document, and
+// then stop writing
+func TestJSONEncoderWriteError(t *testing.T) {
+	long := "(l" + strings.Repeat("I12345\na", 20000) + "." // 120 KB of JSON
+	tests := []struct {
+		name   string
+		pickle string
+		n      int
+		opts   JSONOptions
+		fail   bool
+	}{
+		{"short", "(lp0\nI1\na.", 0, JSONOptions{}, true},
+		{"short with room", "(lp0\nI1\na.", 3, JSONOptions{}, false},
+		{"short indented", "(lp0\nI1\na.", 4, JSONOptions{Indent: " "}, true},
+		{"long", long, 0, JSONOptions{}, true},
+		{"long after a write", long, jsonFlushSize + 10, JSONOptions{}, true},
+		{"long with room", long, 120001, JSONOptions{}, false},
+		{"long indented after a write", long, jsonFlushSize + 10, JSONOptions{Indent: "\t"}, true},
+	}
+	errWrite := errors.New("write failed")
+	for _, tt := range tests {
+		for _, via := range []string{"Encode", "Transcode"} {
+			w := &failWriter{n: tt.n, err: errWrite}
+			enc := NewJSONEncoder(w, tt.opts)
+			u := NewUnpickler([]byte(tt.pickle))
+			var err error
+			if via == "Encode" {
+				var obj types.Object
+				if obj, err = u.Load(); err != nil {
+					t.Fatal(err)
+				}
+				err = enc.Encode(obj)
+			} else {
+				err = u.Transcode(enc)
+			}
+			if tt.fail && err != errWrite || !tt.fail && err != nil {
+				t.Errorf("%s: %s gave %v, want failure %v", tt.name, via, err, tt.fail)
+			}
+			if w.writes != 0 {
+				t.Errorf("%s: %s wrote %d more times after the writer failed", tt.name, via, w.writes)
+			}
+		}
+	}
+}
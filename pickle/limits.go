@@ -0,0 +1,77 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+// Limits bounds the resources a pickle, and the JSON written for it, may use,
+// so that pickles from untrusted sources can be decoded safely. A limit of
+// zero means no limit. Exceeding a limit fails with a *LimitError naming it.
+//
+// MaxObjects, MaxMemo, MaxMarks and MaxLength apply while the Unpickler
+// decodes a pickle. MaxDepth and MaxOutput apply to writing JSON, either by a
+// JSONEncoder whose JSONOptions.Limits sets them, or by Unpickler.Transcode,
+// which uses the tighter of the Unpickler's and the encoder's limits.
+type Limits struct {
+	// MaxDepth is the deepest nesting of containers (lists, tuples, sets,
+	// dicts and instances) written as JSON. Without it, deeply nested objects
+	// can exhaust the goroutine's stack.
+	MaxDepth int
+	// MaxObjects is the number of values the pickle may push on the stack,
+	// counting every scalar, every container and every value fetched from the
+	// memo.
+	MaxObjects int
+	// MaxMemo is the number of entries the pickle may store in the memo.
+	MaxMemo int
+	// MaxMarks is the number of MARKs which may be on the stack at once.
+	MaxMarks int
+	// MaxLength is the length in bytes of the longest string, bytes or long
+	// integer the pickle may contain, and of the longest line of a text
+	// argument, such as those of INT, GET and GLOBAL in protocol 0. A line
+	// which is too long fails as soon as MaxLength bytes of it have been
+	// read, without buffering the rest.
+	MaxLength int
+	// MaxOutput is the number of bytes of JSON which may be written for one
+	// object. Since a memoized container can be referred to many times, this
+	// can be far more than the size of the pickle. The encoder stops before
+	// writing anything beyond the limit to its io.Writer.
+	MaxOutput int64
+}
+
+// checkLimits checks the limits which are enforced after each opcode
+func (u *Unpickler) checkLimits() error {
+	l := &u.Limits
+	switch {
+	case l.MaxObjects > 0 && u.objects > l.MaxObjects:
+		return &LimitError{Limit: "MaxObjects", Max: int64(l.MaxObjects)}
+	case l.MaxMemo > 0 && len(u.memo) > l.MaxMemo:
+		return &LimitError{Limit: "MaxMemo", Max: int64(l.MaxMemo)}
+	case l.MaxMarks > 0 && len(u.metaStack) > l.MaxMarks:
+		return &LimitError{Limit: "MaxMarks", Max: int64(l.MaxMarks)}
+	}
+	return nil
+}
+
+// checkLength checks the length of a string, bytes or long integer argument, or of a line
+func (u *Unpickler) checkLength(n int) error {
+	if u.Limits.MaxLength > 0 && n > u.Limits.MaxLength {
+		return &LimitError{Limit: "MaxLength", Max: int64(u.Limits.MaxLength)}
+	}
+	return nil
+}
+
+// readData reads a string, bytes or long integer argument of n bytes
+func (u *Unpickler) readData(n int) ([]byte, error) {
+	if err := u.checkLength(n); err != nil {
+		return nil, err
+	}
+	return u.read(n)
+}
+
+// tighter returns the tighter of two limits, either of which may be zero for no limit
+func tighter(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
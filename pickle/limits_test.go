@@ -0,0 +1,96 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// endlessReader returns an endless line of a byte, counting how much it has returned
+type endlessReader struct {
+	b byte
+	n int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+	r.n += len(p)
+	return len(p), nil
+}
+
+func TestMaxLengthLines(t *testing.T) {
+	const maxLength = 100
+	long := strings.Repeat("1", maxLength+1)
+	tests := []struct {
+		name   string
+		prefix string // the pickle up to the opcode whose line is too long
+		suffix string // and after it
+	}{
+		{"STRING", "S'", "'\n."},
+		{"UNICODE", "V", "\n."},
+		{"INT", "I", "\n."},
+		{"LONG", "L", "L\n."},
+		{"FLOAT", "F", "\n."},
+		{"PUT", "I1\np", "\n."},
+		{"GET", "I1\np1\n0g", "\n."},
+		{"GLOBAL module", "c", "\nname\n."},
+		{"GLOBAL name", "cmodule\n", "\n."},
+		{"INST", "(i", "\nname\n."},
+		{"PERSID", "P", "\n."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.prefix + long + tt.suffix
+			u := NewUnpickler([]byte(p))
+			u.Limits.MaxLength = maxLength
+			u.PersistentLoad = func(id types.Object) (types.Object, error) { return id, nil }
+			_, err := u.Load()
+			var le *LimitError
+			if !errors.As(err, &le) || le.Limit != "MaxLength" {
+				t.Errorf("Load gave %v, want a MaxLength LimitError", err)
+			}
+
+			// reading the pickle from a reader which never ends the line fails as
+			// soon as the line is too long
+			r := &endlessReader{b: '1'}
+			u = NewReaderUnpickler(io.MultiReader(strings.NewReader(tt.prefix), r))
+			u.Limits.MaxLength = maxLength
+			u.PersistentLoad = func(id types.Object) (types.Object, error) { return id, nil }
+			_, err = u.Load()
+			if !errors.As(err, &le) || le.Limit != "MaxLength" {
+				t.Errorf("Load from a reader gave %v, want a MaxLength LimitError", err)
+			}
+			if r.n > 2*readChunk {
+				t.Errorf("read %d bytes of a line before failing", r.n)
+			}
+		})
+	}
+
+	// lines of MaxLength bytes are fine, including in a frame
+	for _, p := range []string{
+		"V" + long[1:] + "\n.",
+		"\x80\x04\x95" + "\x67\x00\x00\x00\x00\x00\x00\x00" + "V" + long[1:] + "\n.",
+	} {
+		u := NewReaderUnpickler(bytes.NewReader([]byte(p)))
+		u.Limits.MaxLength = maxLength
+		if _, err := u.Load(); err != nil {
+			t.Errorf("Load(%.10q...): %v", p, err)
+		}
+		u = NewUnpickler([]byte(p))
+		u.Limits.MaxLength = maxLength - 1
+		var le *LimitError
+		if _, err := u.Load(); !errors.As(err, &le) {
+			t.Errorf("Load(%.10q...) with a MaxLength of %d gave %v, want a LimitError", p, maxLength-1, err)
+		}
+	}
+}
@@ -0,0 +1,1532 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// DefaultProtocol is the protocol Python 3.8 and later pickle with by default.
+const DefaultProtocol = 4
+
+// Marshaler is implemented by types which pickle themselves. MarshalPickle returns
+// the object to pickle in place of the value, which is pickled as Marshal pickles
+// types.Objects.
+type Marshaler interface {
+	MarshalPickle() (types.Object, error)
+}
+
+// Marshal returns the pickle of v, written with the given protocol, from 0 to
+// HighestProtocol, or a negative protocol for HighestProtocol. Python can load it
+// with pickle.loads. The opcodes are those CPython's pickle.dumps chooses for the
+// same objects, so an object which Load returns is pickled as Python pickled it,
+// when it was pickled with the same protocol and its classes use Python's default
+// __reduce_ex__. Instances of GenericClass are pickled as instances of ordinary
+// Python classes, and bytearrays as bytes, since they load as the same types.
+//
+// Go values are pickled much as encoding/json marshals them:
+//
+// A value which implements Marshaler, or whose pointer does, and an addressable
+// one, pickles the object MarshalPickle returns, and a types.Object pickles
+// itself. Nil pointers, interfaces, maps and slices are pickled as None.
+//
+// Bools, integers, floats, complex numbers and strings are pickled as Python's
+// bool, int, float, complex and str, []byte and [N]byte as bytes, slices as
+// lists and arrays as tuples. Maps are pickled as dicts, in the order of their
+// keys, except that maps whose values are struct{} are pickled as sets. Structs
+// are pickled as dicts of their fields, named and omitted according to their
+// `pickle:"name,omitempty"` tags, as for Unmarshal.
+//
+// A time.Time is pickled as a datetime with a timezone of its offset from UTC, a
+// time.Duration as a timedelta, a big.Int as an int and a big.Rat as a Fraction.
+//
+// Lists, dicts, sets, tuples, strings and bytes which are referred to more than
+// once, through the same pointer, or through slices or maps with the same
+// contents, are pickled once and referred to through the memo, so that Python
+// loads them as shared objects. Values which contain themselves can only be
+// pickled if the cycle passes through a pointer to something pickled as a list,
+// dict, set or instance.
+func Marshal(v any, protocol int) ([]byte, error) {
+	e := Encoder{protocol: protocol}
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Encoder writes pickles to an io.Writer. With protocol 4 and later, each frame is
+// written as soon as it is complete, and large strings and bytes are written
+// directly, so the pickle isn't built in memory.
+type Encoder struct {
+	w        io.Writer
+	protocol int
+	err      error // the first error from w
+	buf      []byte
+	frame    int // the offset in buf of the header of the current frame, or -1 if none is open
+
+	memo    map[memoKey]int // the memo index of each object with an identity which has been written
+	memoLen int
+	active  map[memoKey]int         // how many times each tuple with an identity is being written
+	zones   map[int]*types.TimeZone // timezones written for time.Times, by offset
+	latin1  *types.SimpleString     // the encoding argument of _codecs.encode, which is memoized like Python's
+}
+
+// NewEncoder returns a new Encoder which writes pickles with the given protocol
+// to w. As for Marshal, a negative protocol means HighestProtocol.
+func NewEncoder(w io.Writer, protocol int) *Encoder {
+	return &Encoder{w: w, protocol: protocol}
+}
+
+// Encode writes the pickle of v, as Marshal returns it. Each pickle has its own
+// memo, so the pickles written by successive calls can be loaded one after
+// another, as by Unpickler.Next.
+func (e *Encoder) Encode(v any) error {
+	err := e.encode(v)
+	if err == nil {
+		e.flush()
+		err = e.err
+	}
+	e.buf = e.buf[:0]
+	e.err = nil
+	return err
+}
+
+// memoKey identifies an object which is memoized when it is written, so that it can
+// be referred to again with GET
+type memoKey struct {
+	kind memoKind
+	ptr  unsafe.Pointer
+	n    int          // the length of a slice or string, which may share ptr with a shorter one
+	typ  reflect.Type // the type of the Go value, or of what ptr points to
+	name string       // the name of a global or module
+}
+
+type memoKind uint8
+
+const (
+	memoValue  memoKind = iota // a value with an identity, identified by ptr, n and typ
+	memoGlobal                 // a class or function, named "module\nname"
+	memoModule                 // the name of a module, written for STACK_GLOBAL
+)
+
+// opcodes written by the Encoder, named as in Python's pickle module
+const (
+	opMark            = '('
+	opStop            = '.'
+	opPop             = '0'
+	opPopMark         = '1'
+	opFloat           = 'F'
+	opInt             = 'I'
+	opBinInt          = 'J'
+	opBinInt1         = 'K'
+	opLong            = 'L'
+	opBinInt2         = 'M'
+	opNone            = 'N'
+	opReduce          = 'R'
+	opUnicode         = 'V'
+	opBinUnicode      = 'X'
+	opAppend          = 'a'
+	opBuild           = 'b'
+	opGlobal          = 'c'
+	opDict            = 'd'
+	opEmptyDict       = '}'
+	opAppends         = 'e'
+	opGet             = 'g'
+	opBinGet          = 'h'
+	opLongBinGet      = 'j'
+	opList            = 'l'
+	opEmptyList       = ']'
+	opPut             = 'p'
+	opBinPut          = 'q'
+	opLongBinPut      = 'r'
+	opSetItem         = 's'
+	opTuple           = 't'
+	opEmptyTuple      = ')'
+	opSetItems        = 'u'
+	opBinFloat        = 'G'
+	opProto           = '\x80'
+	opNewObj          = '\x81'
+	opTuple1          = '\x85'
+	opNewTrue         = '\x88'
+	opNewFalse        = '\x89'
+	opLong1           = '\x8a'
+	opLong4           = '\x8b'
+	opBinBytes        = 'B'
+	opShortBinBytes   = 'C'
+	opShortBinUnicode = '\x8c'
+	opBinUnicode8     = '\x8d'
+	opBinBytes8       = '\x8e'
+	opEmptySet        = '\x8f'
+	opAddItems        = '\x90'
+	opFrozenSet       = '\x91'
+	opStackGlobal     = '\x93'
+	opMemoize         = '\x94'
+	opFrame           = '\x95'
+)
+
+const (
+	frameHeaderSize = 9         // the FRAME opcode and its 8 byte length
+	frameSizeMin    = 4         // smaller frames are written without a FRAME, as Python does
+	frameSizeTarget = 64 * 1024 // the size at which Python ends a frame, and above which data is written outside frames
+	batchSize       = 1000      // the number of items Python adds with each APPENDS, SETITEMS or ADDITEMS
+)
+
+func (e *Encoder) encode(v any) error {
+	switch {
+	case e.protocol < 0:
+		e.protocol = int(HighestProtocol)
+	case e.protocol > int(HighestProtocol):
+		return fmt.Errorf("unsupported pickle protocol %d", e.protocol)
+	}
+	e.buf = e.buf[:0]
+	e.frame = -1
+	e.memo, e.memoLen, e.active, e.zones = nil, 0, nil, nil
+	if e.protocol >= 2 {
+		e.buf = append(e.buf, opProto, byte(e.protocol))
+	}
+	if e.protocol >= 4 {
+		e.startFrame()
+	}
+	if err := e.saveValue(reflect.ValueOf(v), memoKey{}); err != nil {
+		return err
+	}
+	e.buf = append(e.buf, opStop)
+	e.endFrame()
+	return e.err
+}
+
+// bin returns whether the protocol has binary opcodes
+func (e *Encoder) bin() bool {
+	return e.protocol >= 1
+}
+
+// startFrame opens a new frame, whose header is written when it ends
+func (e *Encoder) startFrame() {
+	e.frame = len(e.buf)
+	e.buf = append(e.buf, make([]byte, frameHeaderSize)...)
+}
+
+// endFrame ends the current frame, if there is one
+func (e *Encoder) endFrame() {
+	if e.frame < 0 {
+		return
+	}
+	n := len(e.buf) - e.frame - frameHeaderSize
+	if n >= frameSizeMin {
+		e.buf[e.frame] = opFrame
+		binary.LittleEndian.PutUint64(e.buf[e.frame+1:], uint64(n))
+	} else {
+		copy(e.buf[e.frame:], e.buf[e.frame+frameHeaderSize:])
+		e.buf = e.buf[:len(e.buf)-frameHeaderSize]
+	}
+	e.frame = -1
+}
+
+// boundary is called before writing each object. Like Python, it ends the current
+// frame once it has reached its target size, and when writing to an io.Writer,
+// writes out what is complete.
+func (e *Encoder) boundary() {
+	if len(e.buf) < frameSizeTarget {
+		return
+	}
+	if e.frame >= 0 {
+		if len(e.buf)-e.frame-frameHeaderSize < frameSizeTarget {
+			return
+		}
+		e.endFrame()
+		e.flush()
+		e.startFrame()
+	} else {
+		e.flush()
+	}
+}
+
+// flush writes out buf, if there is an io.Writer
+func (e *Encoder) flush() {
+	if e.w == nil || len(e.buf) == 0 {
+		return
+	}
+	if e.err == nil {
+		_, e.err = e.w.Write(e.buf)
+	}
+	e.buf = e.buf[:0]
+}
+
+// writeData writes an opcode's header and the string or bytes which follow it. Like
+// Python, it writes large data outside of any frame.
+func (e *Encoder) writeData(header []byte, data string) {
+	if len(data) < frameSizeTarget {
+		e.buf = append(e.buf, header...)
+		e.buf = append(e.buf, data...)
+		return
+	}
+	framing := e.frame >= 0
+	e.endFrame()
+	e.buf = append(e.buf, header...)
+	if e.w != nil {
+		e.flush()
+		if e.err == nil {
+			_, e.err = io.WriteString(e.w, data)
+		}
+	} else {
+		e.buf = append(e.buf, data...)
+	}
+	if framing {
+		e.startFrame()
+	}
+}
+
+// get writes a GET of the object key identifies, and returns true, if it has been memoized
+func (e *Encoder) get(key memoKey) bool {
+	i, ok := e.memoized(key)
+	if ok {
+		e.writeGet(i)
+	}
+	return ok
+}
+
+// memoized returns the memo index of the object key identifies, if it has been memoized
+func (e *Encoder) memoized(key memoKey) (int, bool) {
+	if key == (memoKey{}) {
+		return 0, false
+	}
+	i, ok := e.memo[key]
+	return i, ok
+}
+
+func (e *Encoder) writeGet(i int) {
+	switch {
+	case !e.bin():
+		e.buf = append(e.buf, opGet)
+		e.buf = strconv.AppendInt(e.buf, int64(i), 10)
+		e.buf = append(e.buf, '\n')
+	case i < 256:
+		e.buf = append(e.buf, opBinGet, byte(i))
+	default:
+		e.buf = append(e.buf, opLongBinGet)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(i))
+	}
+}
+
+// put memoizes the object just written, which key identifies, unless it is the zero
+// memoKey. Like Python, every list, dict, set, string, bytes, non-empty tuple and
+// instance is memoized, whether or not it is referred to again.
+func (e *Encoder) put(key memoKey) {
+	i := e.memoLen
+	e.memoLen++
+	if key != (memoKey{}) {
+		if e.memo == nil {
+			e.memo = make(map[memoKey]int)
+		}
+		e.memo[key] = i
+	}
+	switch {
+	case e.protocol >= 4:
+		e.buf = append(e.buf, opMemoize)
+	case !e.bin():
+		e.buf = append(e.buf, opPut)
+		e.buf = strconv.AppendInt(e.buf, int64(i), 10)
+		e.buf = append(e.buf, '\n')
+	case i < 256:
+		e.buf = append(e.buf, opBinPut, byte(i))
+	default:
+		e.buf = append(e.buf, opLongBinPut)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(i))
+	}
+}
+
+// objectKey returns the memo key of a types.Object with an identity
+func objectKey(ptr unsafe.Pointer, n int, obj types.Object) memoKey {
+	if ptr == nil {
+		return memoKey{}
+	}
+	return memoKey{ptr: ptr, n: n, typ: reflect.TypeOf(obj)}
+}
+
+// save writes obj
+func (e *Encoder) save(obj types.Object) error {
+	switch o := obj.(type) {
+	case nil, types.None:
+		e.saveNone()
+	case types.Bool:
+		e.saveBool(bool(o))
+	case types.Int:
+		e.saveInt(int64(o))
+	case *types.Long:
+		e.saveBigInt((*big.Int)(o))
+	case types.Float:
+		e.saveFloat(float64(o))
+	case types.Complex:
+		return e.saveComplex(complex128(o))
+	case *types.SimpleString:
+		return e.saveStr(bytesString(*o), objectKey(unsafe.Pointer(o), 0, o))
+	case *types.EscapedString:
+		return e.saveStr(bytesString(*o), objectKey(unsafe.Pointer(o), 0, o))
+	case types.ByteArray:
+		return e.saveBytes(bytesString(o), dataKey(unsafe.Pointer(&o), len(o), o))
+	case types.Tuple:
+		return e.saveTuple(dataKey(unsafe.Pointer(&o), len(o), o), len(o), func(i int) error {
+			return e.save(o[i])
+		})
+	case *types.List:
+		return e.saveList(objectKey(unsafe.Pointer(o), 0, o), len(*o), func(i int) error {
+			return e.save((*o)[i])
+		})
+	case *types.Dict:
+		return e.saveDict(objectKey(unsafe.Pointer(o), 0, o), len(*o)/2, e.savePairs(*o))
+	case *types.OrderedDict:
+		key := objectKey(unsafe.Pointer(o), 0, o)
+		if e.get(key) {
+			return nil
+		}
+		if err := e.saveReduce("collections", "OrderedDict", key, 0, nil); err != nil {
+			return err
+		}
+		return e.setItems(len(*o)/2, e.savePairs(types.Dict(*o)), false)
+	case *types.Set:
+		return e.saveSet(objectKey(unsafe.Pointer(o), 0, o), len(*o), func(i int) error {
+			return e.save((*o)[i])
+		})
+	case types.FrozenSet:
+		return e.saveFrozenSet(dataKey(unsafe.Pointer(&o), len(o), o), len(o), func(i int) error {
+			return e.save(o[i])
+		})
+	case *types.DateTime:
+		return e.saveDateTime(o, objectKey(unsafe.Pointer(o), 0, o))
+	case *types.Date:
+		key := objectKey(unsafe.Pointer(o), 0, o)
+		return e.saveReduce("datetime", "date", key, 1, func(int) error {
+			return e.saveBytes(string(dateState(o)), memoKey{})
+		})
+	case *types.Time:
+		return e.saveTimeOfDay(o, objectKey(unsafe.Pointer(o), 0, o))
+	case *types.TimeDelta:
+		return e.saveTimeDelta(o, objectKey(unsafe.Pointer(o), 0, o))
+	case *types.TimeZone:
+		key := objectKey(unsafe.Pointer(o), 0, o)
+		n := 1
+		if o.Name != "" {
+			n = 2
+		}
+		return e.saveReduce("datetime", "timezone", key, n, func(i int) error {
+			if i == 0 {
+				return e.saveTimeDelta(&o.Offset, objectKey(unsafe.Pointer(&o.Offset), 0, &o.Offset))
+			}
+			return e.saveStr(o.Name, memoKey{})
+		})
+	case *types.Decimal:
+		key := objectKey(unsafe.Pointer(o), 0, o)
+		return e.saveReduce("decimal", "Decimal", key, 1, func(int) error {
+			return e.saveStr(o.String(), memoKey{})
+		})
+	case *types.Fraction:
+		return e.saveFraction((*big.Rat)(o), objectKey(unsafe.Pointer(o), 0, o))
+	case *types.GenericObject:
+		return e.saveInstance(o, objectKey(unsafe.Pointer(o), 0, o))
+	default:
+		if m, ok := obj.(Marshaler); ok {
+			obj, err := m.MarshalPickle()
+			if err != nil {
+				return err
+			}
+			return e.save(obj)
+		}
+		if module, name, ok := classGlobal(obj); ok {
+			return e.saveGlobal(module, name)
+		}
+		return &UnsupportedTypeError{Type: reflect.TypeOf(obj)}
+	}
+	return nil
+}
+
+// savePairs returns a function which saves the key and value of the i'th item of d
+func (e *Encoder) savePairs(d types.Dict) func(int) error {
+	return func(i int) error {
+		if err := e.save(d[2*i]); err != nil {
+			return err
+		}
+		return e.save(d[2*i+1])
+	}
+}
+
+// dataKey returns the memo key of a non-empty slice or string, identified by its
+// contents, given a pointer to its header and its length
+func dataKey(header unsafe.Pointer, n int, v any) memoKey {
+	if n == 0 {
+		return memoKey{}
+	}
+	// the pointer to the contents is the first word of both slices and strings
+	return memoKey{ptr: *(*unsafe.Pointer)(header), n: n, typ: reflect.TypeOf(v)}
+}
+
+// bytesString returns the contents of b as a string, without copying them
+func bytesString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// classGlobal returns the module and name of a class or function
+func classGlobal(obj types.Object) (module, name string, ok bool) {
+	switch c := obj.(type) {
+	case *types.GenericClass:
+		return c.Module, c.Name, true
+	case *types.ObjectClass:
+		return "builtins", "object", true
+	case *types.ComplexClass:
+		return "builtins", "complex", true
+	case *types.SetClass:
+		return "builtins", "set", true
+	case *types.FrozenSetClass:
+		return "builtins", "frozenset", true
+	case *types.BytesClass:
+		return "builtins", "bytes", true
+	case *types.ByteArrayClass:
+		return "builtins", "bytearray", true
+	case *types.OrderedDictClass:
+		return "collections", "OrderedDict", true
+	case *types.DateTimeClass:
+		return "datetime", "datetime", true
+	case *types.DateClass:
+		return "datetime", "date", true
+	case *types.TimeClass:
+		return "datetime", "time", true
+	case *types.TimeDeltaClass:
+		return "datetime", "timedelta", true
+	case *types.TimeZoneClass:
+		return "datetime", "timezone", true
+	case *types.DecimalClass:
+		return "decimal", "Decimal", true
+	case *types.FractionClass:
+		return "fractions", "Fraction", true
+	case *types.CodecsEncodeFunc:
+		return "_codecs", "encode", true
+	case *types.ReconstructorFunc:
+		return "copyreg", "_reconstructor", true
+	case *types.NewObjFunc:
+		return "copyreg", "__newobj__", true
+	case *types.NewObjExFunc:
+		return "copyreg", "__newobj_ex__", true
+	}
+	return "", "", false
+}
+
+func (e *Encoder) saveNone() {
+	e.boundary()
+	e.buf = append(e.buf, opNone)
+}
+
+func (e *Encoder) saveBool(b bool) {
+	e.boundary()
+	switch {
+	case e.protocol >= 2 && b:
+		e.buf = append(e.buf, opNewTrue)
+	case e.protocol >= 2:
+		e.buf = append(e.buf, opNewFalse)
+	case b:
+		e.buf = append(e.buf, "I01\n"...)
+	default:
+		e.buf = append(e.buf, "I00\n"...)
+	}
+}
+
+func (e *Encoder) saveInt(i int64) {
+	e.boundary()
+	fits32 := i >= math.MinInt32 && i <= math.MaxInt32
+	switch {
+	case e.bin() && i >= 0 && i <= 0xff:
+		e.buf = append(e.buf, opBinInt1, byte(i))
+	case e.bin() && i >= 0 && i <= 0xffff:
+		e.buf = append(e.buf, opBinInt2, byte(i), byte(i>>8))
+	case e.bin() && fits32:
+		e.buf = append(e.buf, opBinInt)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(i))
+	case e.protocol >= 2:
+		e.writeLong(big.NewInt(i))
+	case fits32:
+		e.buf = append(e.buf, opInt)
+		e.buf = strconv.AppendInt(e.buf, i, 10)
+		e.buf = append(e.buf, '\n')
+	default:
+		e.buf = append(e.buf, opLong)
+		e.buf = strconv.AppendInt(e.buf, i, 10)
+		e.buf = append(e.buf, "L\n"...)
+	}
+}
+
+func (e *Encoder) saveBigInt(i *big.Int) {
+	if i.IsInt64() {
+		e.saveInt(i.Int64())
+		return
+	}
+	e.boundary()
+	if e.protocol >= 2 {
+		e.writeLong(i)
+		return
+	}
+	e.buf = append(e.buf, opLong)
+	e.buf = i.Append(e.buf, 10)
+	e.buf = append(e.buf, "L\n"...)
+}
+
+// writeLong writes LONG1 or LONG4, whose argument is the integer in little-endian two's
+// complement, in as few bytes as Python's encode_long uses
+func (e *Encoder) writeLong(i *big.Int) {
+	var data []byte
+	if i.Sign() != 0 {
+		n := i.BitLen()/8 + 1
+		x := i
+		if i.Sign() < 0 {
+			// 2**(8n) + i
+			x = new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+			x.Add(x, i)
+		}
+		data = x.FillBytes(make([]byte, n))
+		for l, r := 0, n-1; l < r; l, r = l+1, r-1 {
+			data[l], data[r] = data[r], data[l]
+		}
+		if i.Sign() < 0 && n > 1 && data[n-1] == 0xff && data[n-2]&0x80 != 0 {
+			data = data[:n-1]
+		}
+	}
+	if len(data) < 256 {
+		e.buf = append(e.buf, opLong1, byte(len(data)))
+	} else {
+		e.buf = append(e.buf, opLong4)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(len(data)))
+	}
+	e.buf = append(e.buf, data...)
+}
+
+func (e *Encoder) saveFloat(f float64) {
+	e.boundary()
+	if e.bin() {
+		e.buf = append(e.buf, opBinFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+		return
+	}
+	e.buf = append(e.buf, opFloat)
+	e.buf = types.Float(f).AppendRepr(e.buf)
+	e.buf = append(e.buf, '\n')
+}
+
+func (e *Encoder) saveComplex(c complex128) error {
+	return e.saveReduce("builtins", "complex", memoKey{}, 2, func(i int) error {
+		if i == 0 {
+			e.saveFloat(real(c))
+		} else {
+			e.saveFloat(imag(c))
+		}
+		return nil
+	})
+}
+
+// saveStr writes a str, which is memoized under key
+func (e *Encoder) saveStr(s string, key memoKey) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	var header [9]byte
+	n := len(s)
+	switch {
+	case !e.bin():
+		e.buf = append(e.buf, opUnicode)
+		e.buf = appendRawUnicodeEscape(e.buf, s)
+		e.buf = append(e.buf, '\n')
+	case n < 256 && e.protocol >= 4:
+		header[0], header[1] = opShortBinUnicode, byte(n)
+		e.writeData(header[:2], s)
+	case uint64(n) <= math.MaxUint32:
+		header[0] = opBinUnicode
+		binary.LittleEndian.PutUint32(header[1:], uint32(n))
+		e.writeData(header[:5], s)
+	case e.protocol >= 4:
+		header[0] = opBinUnicode8
+		binary.LittleEndian.PutUint64(header[1:], uint64(n))
+		e.writeData(header[:9], s)
+	default:
+		return fmt.Errorf("pickling a str longer than 4GiB requires protocol 4 or higher")
+	}
+	e.put(key)
+	return nil
+}
+
+// appendRawUnicodeEscape appends s as the UNICODE opcode's argument, which is encoded
+// as Python's raw-unicode-escape codec does, but with the characters which would end
+// the line, or which Python 2 treats specially, also escaped
+func appendRawUnicodeEscape(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if n == 1 && i+3 <= len(s) && s[i] == 0xed && s[i+1]&0xe0 == 0xa0 && s[i+2]&0xc0 == 0x80 {
+			// a lone surrogate, which is kept as the 3 bytes Python pickles it as
+			r, n = rune(s[i]&0xf)<<12|rune(s[i+1]&0x3f)<<6|rune(s[i+2]&0x3f), 3
+		}
+		i += n
+		switch {
+		case r >= 0x10000:
+			dst = append(dst, '\\', 'U')
+			for shift := 28; shift >= 0; shift -= 4 {
+				dst = append(dst, hex[r>>shift&0xf])
+			}
+		case r >= 0x100 || r == '\\' || r == 0 || r == '\n' || r == '\r' || r == 0x1a:
+			dst = append(dst, '\\', 'u', hex[r>>12&0xf], hex[r>>8&0xf], hex[r>>4&0xf], hex[r&0xf])
+		default:
+			dst = append(dst, byte(r))
+		}
+	}
+	return dst
+}
+
+// saveBytes writes a bytes object, which is memoized under key
+func (e *Encoder) saveBytes(b string, key memoKey) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if e.protocol < 3 {
+		// Python 3 pickles bytes as _codecs.encode(str, "latin1") when there is no opcode for them
+		if len(b) == 0 {
+			return e.saveReduce("builtins", "bytes", key, 0, nil)
+		}
+		return e.saveReduce("_codecs", "encode", key, 2, func(i int) error {
+			if i == 0 {
+				return e.saveStr(latin1String(b), memoKey{})
+			}
+			if e.latin1 == nil {
+				e.latin1 = &types.SimpleString{'l', 'a', 't', 'i', 'n', '1'}
+			}
+			return e.save(e.latin1)
+		})
+	}
+	var header [9]byte
+	n := len(b)
+	switch {
+	case n < 256:
+		header[0], header[1] = opShortBinBytes, byte(n)
+		e.writeData(header[:2], b)
+	case uint64(n) <= math.MaxUint32:
+		header[0] = opBinBytes
+		binary.LittleEndian.PutUint32(header[1:], uint32(n))
+		e.writeData(header[:5], b)
+	case e.protocol >= 4:
+		header[0] = opBinBytes8
+		binary.LittleEndian.PutUint64(header[1:], uint64(n))
+		e.writeData(header[:9], b)
+	default:
+		return fmt.Errorf("pickling bytes longer than 4GiB requires protocol 4 or higher")
+	}
+	e.put(key)
+	return nil
+}
+
+// latin1String returns the str whose characters are the bytes of b
+func latin1String(b string) string {
+	var s strings.Builder
+	s.Grow(len(b) * 2)
+	for i := 0; i < len(b); i++ {
+		if b[i] < utf8.RuneSelf {
+			s.WriteByte(b[i])
+		} else {
+			s.WriteRune(rune(b[i]))
+		}
+	}
+	return s.String()
+}
+
+// saveTuple writes a tuple of n items, using item to save each one, which is
+// memoized under key
+func (e *Encoder) saveTuple(key memoKey, n int, item func(int) error) error {
+	e.boundary()
+	if n == 0 {
+		if e.bin() {
+			e.buf = append(e.buf, opEmptyTuple)
+		} else {
+			e.buf = append(e.buf, opMark, opTuple)
+		}
+		return nil
+	}
+	if e.get(key) {
+		return nil
+	}
+	if key != (memoKey{}) {
+		// a tuple can only contain itself through a list, dict, set or instance, which
+		// is memoized by the time the tuple is reached again, and so stops the recursion
+		if e.active[key] > 1 {
+			return fmt.Errorf("%s contains itself, and can only be pickled through a pointer to a list, dict, set or instance", key.typ)
+		}
+		if e.active == nil {
+			e.active = make(map[memoKey]int)
+		}
+		e.active[key]++
+		defer func() { e.active[key]-- }()
+	}
+	if e.protocol >= 2 && n <= 3 {
+		for i := 0; i < n; i++ {
+			if err := item(i); err != nil {
+				return err
+			}
+		}
+		if i, ok := e.memoized(key); ok {
+			// the tuple was written while writing its items
+			for j := 0; j < n; j++ {
+				e.buf = append(e.buf, opPop)
+			}
+			e.writeGet(i)
+			return nil
+		}
+		e.buf = append(e.buf, opTuple1+byte(n-1))
+		e.put(key)
+		return nil
+	}
+	e.buf = append(e.buf, opMark)
+	for i := 0; i < n; i++ {
+		if err := item(i); err != nil {
+			return err
+		}
+	}
+	if i, ok := e.memoized(key); ok {
+		if e.bin() {
+			e.buf = append(e.buf, opPopMark)
+		} else {
+			for j := 0; j <= n; j++ {
+				e.buf = append(e.buf, opPop)
+			}
+		}
+		e.writeGet(i)
+		return nil
+	}
+	e.buf = append(e.buf, opTuple)
+	e.put(key)
+	return nil
+}
+
+// saveList writes a list of n items, using item to save each one, which is
+// memoized under key
+func (e *Encoder) saveList(key memoKey, n int, item func(int) error) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if e.bin() {
+		e.buf = append(e.buf, opEmptyList)
+	} else {
+		e.buf = append(e.buf, opMark, opList)
+	}
+	e.put(key)
+	return e.appends(n, item, true)
+}
+
+// appends appends n items to the list, or instance, on top of the stack. Python adds
+// the items of a list in batches, the last of which may hold a single item, but adds
+// a single item at the end of an instance's items with APPEND.
+func (e *Encoder) appends(n int, item func(int) error, list bool) error {
+	if !e.bin() || n == 1 {
+		for i := 0; i < n; i++ {
+			if err := item(i); err != nil {
+				return err
+			}
+			e.buf = append(e.buf, opAppend)
+		}
+		return nil
+	}
+	for i := 0; i < n; {
+		end := i + batchSize
+		if end > n {
+			end = n
+		}
+		if !list && end-i == 1 {
+			if err := item(i); err != nil {
+				return err
+			}
+			e.buf = append(e.buf, opAppend)
+			return nil
+		}
+		e.buf = append(e.buf, opMark)
+		for ; i < end; i++ {
+			if err := item(i); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, opAppends)
+	}
+	return nil
+}
+
+// saveDict writes a dict of n items, using item to save the key and value of each
+// one, which is memoized under key
+func (e *Encoder) saveDict(key memoKey, n int, item func(int) error) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if e.bin() {
+		e.buf = append(e.buf, opEmptyDict)
+	} else {
+		e.buf = append(e.buf, opMark, opDict)
+	}
+	e.put(key)
+	return e.setItems(n, item, true)
+}
+
+// setItems sets n items of the dict, or instance, on top of the stack, in batches as
+// appends adds them. Like Python, when a dict has a multiple of the batch size of
+// items, an empty batch follows them.
+func (e *Encoder) setItems(n int, item func(int) error, dict bool) error {
+	if !e.bin() || n == 1 {
+		for i := 0; i < n; i++ {
+			if err := item(i); err != nil {
+				return err
+			}
+			e.buf = append(e.buf, opSetItem)
+		}
+		return nil
+	}
+	if !dict {
+		for i := 0; i < n; {
+			end := i + batchSize
+			if end > n {
+				end = n
+			}
+			if end-i == 1 {
+				if err := item(i); err != nil {
+					return err
+				}
+				e.buf = append(e.buf, opSetItem)
+				return nil
+			}
+			e.buf = append(e.buf, opMark)
+			for ; i < end; i++ {
+				if err := item(i); err != nil {
+					return err
+				}
+			}
+			e.buf = append(e.buf, opSetItems)
+		}
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	return e.batches(n, item, opSetItems)
+}
+
+// batches writes the n items in full batches, and a final batch which may be empty,
+// each followed by op
+func (e *Encoder) batches(n int, item func(int) error, op byte) error {
+	for i := 0; ; {
+		end := i + batchSize
+		if end > n {
+			end = n
+		}
+		e.buf = append(e.buf, opMark)
+		for j := i; j < end; j++ {
+			if err := item(j); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, op)
+		if end-i < batchSize {
+			return nil
+		}
+		i = end
+	}
+}
+
+// saveSet writes a set of n items, using item to save each one, which is memoized
+// under key. Before protocol 4, which has opcodes for sets, it is written as
+// set(list).
+func (e *Encoder) saveSet(key memoKey, n int, item func(int) error) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if e.protocol < 4 {
+		return e.saveReduce("builtins", "set", key, 1, func(int) error {
+			return e.saveList(memoKey{}, n, item)
+		})
+	}
+	e.buf = append(e.buf, opEmptySet)
+	e.put(key)
+	if n == 0 {
+		return nil
+	}
+	return e.batches(n, item, opAddItems)
+}
+
+// saveFrozenSet writes a frozenset of n items, using item to save each one, which is
+// memoized under key
+func (e *Encoder) saveFrozenSet(key memoKey, n int, item func(int) error) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if e.protocol < 4 {
+		return e.saveReduce("builtins", "frozenset", key, 1, func(int) error {
+			return e.saveList(memoKey{}, n, item)
+		})
+	}
+	e.buf = append(e.buf, opMark)
+	for i := 0; i < n; i++ {
+		if err := item(i); err != nil {
+			return err
+		}
+	}
+	if i, ok := e.memoized(key); ok {
+		e.buf = append(e.buf, opPopMark)
+		e.writeGet(i)
+		return nil
+	}
+	e.buf = append(e.buf, opFrozenSet)
+	e.put(key)
+	return nil
+}
+
+// py2Modules are the modules whose names changed in Python 3, which Python uses the
+// old names of when pickling with protocols Python 2 can load
+var py2Modules = map[string]string{
+	"builtins": "__builtin__",
+	"copyreg":  "copy_reg",
+}
+
+// saveGlobal writes a reference to a class or function
+func (e *Encoder) saveGlobal(module, name string) error {
+	e.boundary()
+	for py3, py2 := range py2Modules {
+		if e.protocol < 3 && module == py3 {
+			module = py2
+		} else if e.protocol >= 3 && module == py2 {
+			module = py3
+		}
+	}
+	key := memoKey{kind: memoGlobal, name: module + "\n" + name}
+	if e.get(key) {
+		return nil
+	}
+	if e.protocol >= 4 {
+		if err := e.saveStr(module, memoKey{kind: memoModule, name: module}); err != nil {
+			return err
+		}
+		if err := e.saveStr(name, memoKey{}); err != nil {
+			return err
+		}
+		e.buf = append(e.buf, opStackGlobal)
+	} else {
+		e.buf = append(e.buf, opGlobal)
+		e.buf = append(e.buf, module...)
+		e.buf = append(e.buf, '\n')
+		e.buf = append(e.buf, name...)
+		e.buf = append(e.buf, '\n')
+	}
+	e.put(key)
+	return nil
+}
+
+// saveReduce writes a call of a class or function to n arguments, which arg saves,
+// whose result is memoized under key
+func (e *Encoder) saveReduce(module, name string, key memoKey, n int, arg func(int) error) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	if err := e.saveGlobal(module, name); err != nil {
+		return err
+	}
+	if err := e.saveTuple(memoKey{}, n, arg); err != nil {
+		return err
+	}
+	e.buf = append(e.buf, opReduce)
+	e.putResult(key)
+	return nil
+}
+
+// putResult memoizes the object just made by REDUCE or NEWOBJ, unless it was already
+// written while writing its arguments, in which case that object replaces it
+func (e *Encoder) putResult(key memoKey) {
+	if i, ok := e.memoized(key); ok {
+		e.buf = append(e.buf, opPop)
+		e.writeGet(i)
+		return
+	}
+	e.put(key)
+}
+
+// saveInstance writes an instance of a class, as Python's default __reduce_ex__ has
+// it pickled: with NEWOBJ from protocol 2, and before that, through
+// copyreg._reconstructor, or by calling the class if it has constructor arguments
+func (e *Encoder) saveInstance(o *types.GenericObject, key memoKey) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	args := o.ConstructorArgs
+	list, dict := true, true // whether the list and dict items remain to be written
+	if e.protocol >= 2 || len(args) != 0 {
+		if err := e.saveGlobal(o.Class.Module, o.Class.Name); err != nil {
+			return err
+		}
+		if err := e.saveTuple(memoKey{}, len(args), func(i int) error {
+			return e.save(args[i])
+		}); err != nil {
+			return err
+		}
+		if e.protocol >= 2 {
+			e.buf = append(e.buf, opNewObj)
+		} else {
+			e.buf = append(e.buf, opReduce)
+		}
+		e.putResult(key)
+	} else {
+		// copyreg._reconstructor(class, base, state), where the base is list or dict
+		// for instances of their subclasses, with their items as the state
+		base, state := "object", types.Object(types.None{})
+		switch {
+		case len(o.ListItems) != 0:
+			base, state, list = "list", &o.ListItems, false
+		case len(o.DictItems) != 0:
+			base, state, dict = "dict", &o.DictItems, false
+		}
+		err := e.saveReduce("copyreg", "_reconstructor", key, 3, func(i int) error {
+			switch i {
+			case 0:
+				return e.saveGlobal(o.Class.Module, o.Class.Name)
+			case 1:
+				return e.saveGlobal("builtins", base)
+			}
+			return e.save(state)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if list {
+		if err := e.appends(len(o.ListItems), func(i int) error {
+			return e.save(o.ListItems[i])
+		}, false); err != nil {
+			return err
+		}
+	}
+	if dict {
+		if err := e.setItems(len(o.DictItems)/2, e.savePairs(o.DictItems), false); err != nil {
+			return err
+		}
+	}
+	var state types.Object
+	switch {
+	case o.State != nil:
+		state = o.State
+	case len(o.Slots) != 0:
+		var dict types.Object = types.None{}
+		if len(o.Dict) != 0 {
+			dict = &o.Dict
+		}
+		state = types.Tuple{dict, &o.Slots}
+	case len(o.Dict) != 0:
+		state = &o.Dict
+	}
+	if state != nil {
+		if err := e.save(state); err != nil {
+			return err
+		}
+		e.buf = append(e.buf, opBuild)
+	}
+	return nil
+}
+
+// saveDateTime writes a datetime as datetime.__reduce_ex__ has it pickled: as the
+// datetime class called with its packed state, and its tzinfo if it has one
+func (e *Encoder) saveDateTime(dt *types.DateTime, key memoKey) error {
+	state := make([]byte, 0, 10)
+	month := byte(dt.Month)
+	if dt.Fold && e.protocol > 3 {
+		month |= 0x80
+	}
+	state = append(state, byte(dt.Year>>8), byte(dt.Year), month, byte(dt.Day))
+	state = appendTimeState(state, &dt.Time, false)
+	return e.saveReduce("datetime", "datetime", key, tzArgs(&dt.Time), func(i int) error {
+		if i == 0 {
+			return e.saveBytes(bytesString(state), memoKey{})
+		}
+		return e.save(dt.TZInfo)
+	})
+}
+
+// saveTimeOfDay writes a time as saveDateTime writes a datetime
+func (e *Encoder) saveTimeOfDay(t *types.Time, key memoKey) error {
+	state := appendTimeState(make([]byte, 0, 6), t, e.protocol > 3)
+	return e.saveReduce("datetime", "time", key, tzArgs(t), func(i int) error {
+		if i == 0 {
+			return e.saveBytes(bytesString(state), memoKey{})
+		}
+		return e.save(t.TZInfo)
+	})
+}
+
+// dateState returns the packed state date.__reduce__ returns
+func dateState(d *types.Date) []byte {
+	return []byte{byte(d.Year >> 8), byte(d.Year), byte(d.Month), byte(d.Day)}
+}
+
+// appendTimeState appends the packed state of the time of day, with the fold in the
+// hour if fold is true
+func appendTimeState(dst []byte, t *types.Time, fold bool) []byte {
+	hour := byte(t.Hour)
+	if fold && t.Fold {
+		hour |= 0x80
+	}
+	us := t.Microsecond
+	return append(dst, hour, byte(t.Minute), byte(t.Second), byte(us>>16), byte(us>>8), byte(us))
+}
+
+// tzArgs returns the number of arguments a datetime or time is pickled with
+func tzArgs(t *types.Time) int {
+	if t.TZInfo == nil {
+		return 1
+	}
+	return 2
+}
+
+func (e *Encoder) saveTimeDelta(td *types.TimeDelta, key memoKey) error {
+	state := [3]int{td.Days, td.Seconds, td.Microseconds}
+	return e.saveReduce("datetime", "timedelta", key, 3, func(i int) error {
+		e.saveInt(int64(state[i]))
+		return nil
+	})
+}
+
+func (e *Encoder) saveFraction(r *big.Rat, key memoKey) error {
+	return e.saveReduce("fractions", "Fraction", key, 2, func(i int) error {
+		if i == 0 {
+			e.saveBigInt(r.Num())
+		} else {
+			e.saveBigInt(r.Denom())
+		}
+		return nil
+	})
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// saveValue writes a Go value, which is memoized under key if it is a pointer's
+// target. Other keys are worked out here.
+func (e *Encoder) saveValue(rv reflect.Value, key memoKey) error {
+	if !rv.IsValid() {
+		e.saveNone()
+		return nil
+	}
+	t := rv.Type()
+	switch {
+	case t.Implements(marshalerType):
+		if isNil(rv) {
+			e.saveNone()
+			return nil
+		}
+		obj, err := rv.Interface().(Marshaler).MarshalPickle()
+		if err != nil {
+			return err
+		}
+		return e.save(obj)
+	case t.Kind() != reflect.Pointer && rv.CanAddr() && reflect.PointerTo(t).Implements(marshalerType):
+		obj, err := rv.Addr().Interface().(Marshaler).MarshalPickle()
+		if err != nil {
+			return err
+		}
+		return e.save(obj)
+	case t.Implements(objectType):
+		if isNil(rv) {
+			e.saveNone()
+			return nil
+		}
+		return e.save(rv.Interface().(types.Object))
+	}
+
+	switch t {
+	case timeType:
+		return e.saveTime(rv.Interface().(time.Time), key)
+	case durationType:
+		d := time.Duration(rv.Int())
+		td := types.NewTimeDelta(0, int(d/time.Second), int(d%time.Second/time.Microsecond))
+		return e.saveTimeDelta(td, key)
+	case bigIntType:
+		i := rv.Interface().(big.Int)
+		e.saveBigInt(&i)
+		return nil
+	case bigRatType:
+		r := rv.Interface().(big.Rat)
+		return e.saveFraction(&r, key)
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			e.saveNone()
+			return nil
+		}
+		return e.saveValue(rv.Elem(), memoKey{})
+	case reflect.Pointer:
+		if rv.IsNil() {
+			e.saveNone()
+			return nil
+		}
+		key := memoKey{}
+		if t.Elem().Size() != 0 {
+			// all pointers to zero-sized values may be the same
+			key = memoKey{ptr: rv.UnsafePointer(), typ: t}
+		}
+		return e.saveValue(rv.Elem(), key)
+	case reflect.Bool:
+		e.saveBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.saveInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			e.saveBigInt(new(big.Int).SetUint64(u))
+		} else {
+			e.saveInt(int64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		e.saveFloat(rv.Float())
+	case reflect.Complex64, reflect.Complex128:
+		return e.saveComplex(rv.Complex())
+	case reflect.String:
+		s := rv.String()
+		if key == (memoKey{}) {
+			// strings with the same contents can share them, as Python's interned strings do
+			key = dataKey(unsafe.Pointer(&s), len(s), s)
+		}
+		return e.saveStr(s, key)
+	case reflect.Slice:
+		if rv.IsNil() {
+			e.saveNone()
+			return nil
+		}
+		if key == (memoKey{}) && rv.Len() != 0 {
+			key = memoKey{ptr: rv.UnsafePointer(), n: rv.Len(), typ: t}
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return e.saveBytes(bytesString(rv.Bytes()), key)
+		}
+		return e.saveList(key, rv.Len(), func(i int) error {
+			return e.saveValue(rv.Index(i), memoKey{})
+		})
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return e.saveBytes(bytesString(b), key)
+		}
+		return e.saveTuple(key, rv.Len(), func(i int) error {
+			return e.saveValue(rv.Index(i), memoKey{})
+		})
+	case reflect.Map:
+		if rv.IsNil() {
+			e.saveNone()
+			return nil
+		}
+		if key == (memoKey{}) {
+			key = memoKey{ptr: rv.UnsafePointer(), typ: t}
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return compareKeys(keys[i], keys[j]) < 0
+		})
+		if t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0 {
+			return e.saveSet(key, len(keys), func(i int) error {
+				return e.saveValue(keys[i], memoKey{})
+			})
+		}
+		return e.saveDict(key, len(keys), func(i int) error {
+			if err := e.saveValue(keys[i], memoKey{}); err != nil {
+				return err
+			}
+			return e.saveValue(rv.MapIndex(keys[i]), memoKey{})
+		})
+	case reflect.Struct:
+		return e.saveStruct(rv, key)
+	default:
+		return &UnsupportedTypeError{Type: t}
+	}
+	return nil
+}
+
+// isNil returns whether rv is a nil pointer or interface. Like encoding/json, a nil
+// map or slice with a method of its own is left to the method.
+func isNil(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// saveStruct writes a struct as a dict of its fields
+func (e *Encoder) saveStruct(rv reflect.Value, key memoKey) error {
+	e.boundary()
+	if e.get(key) {
+		return nil
+	}
+	fields := structFields(rv.Type())
+	names := make([]string, 0, len(fields))
+	values := make([]reflect.Value, 0, len(fields))
+	for i := range fields {
+		f := &fields[i]
+		fv, ok := fieldValue(rv, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		names = append(names, f.name)
+		values = append(values, fv)
+	}
+	return e.saveDict(key, len(names), func(i int) error {
+		// the names are shared by every struct of the type, and so are memoized
+		name := names[i]
+		if err := e.saveStr(name, dataKey(unsafe.Pointer(&name), len(name), name)); err != nil {
+			return err
+		}
+		return e.saveValue(values[i], memoKey{})
+	})
+}
+
+// fieldValue returns the field of rv at index, or false if it is in an embedded struct
+// whose pointer is nil
+func fieldValue(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return rv, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// isEmptyValue returns whether a field tagged omitempty is left out, as encoding/json
+// decides
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// compareKeys orders the keys of a map: numbers by value, strings lexically, false
+// before true, and arrays and structs by their elements. Keys of different kinds, in
+// interfaces, are ordered by kind.
+func compareKeys(a, b reflect.Value) int {
+	if a.Kind() == reflect.Interface {
+		a, b = a.Elem(), b.Elem()
+		if !a.IsValid() || !b.IsValid() {
+			return order(!a.IsValid() && b.IsValid(), a.IsValid() && !b.IsValid())
+		}
+	}
+	if a.Kind() != b.Kind() {
+		return int(a.Kind()) - int(b.Kind())
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		x, y := a.Bool(), b.Bool()
+		return order(!x && y, x && !y)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, y := a.Int(), b.Int()
+		return order(x < y, x > y)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, y := a.Uint(), b.Uint()
+		return order(x < y, x > y)
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		return order(x < y, x > y)
+	case reflect.Complex64, reflect.Complex128:
+		x, y := a.Complex(), b.Complex()
+		if c := order(real(x) < real(y), real(x) > real(y)); c != 0 {
+			return c
+		}
+		return order(imag(x) < imag(y), imag(x) > imag(y))
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		x, y := a.Pointer(), b.Pointer()
+		return order(x < y, x > y)
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareKeys(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareKeys(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+	case reflect.Interface:
+		return compareKeys(a, b)
+	}
+	return 0
+}
+
+// order returns -1 if less, 1 if greater, and otherwise 0
+func order(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// saveTime writes a time.Time as a datetime, whose tzinfo is a timezone of its offset
+// from UTC
+func (e *Encoder) saveTime(t time.Time, key memoKey) error {
+	if t.Year() < 1 || t.Year() > 9999 {
+		return fmt.Errorf("time %v is out of the range of a Python datetime", t)
+	}
+	_, offset := t.Zone()
+	tz := e.zones[offset]
+	if tz == nil {
+		// shared by the datetimes with the same offset, as timezone.utc is in Python
+		tz = &types.TimeZone{Offset: *types.NewTimeDelta(0, offset, 0)}
+		if e.zones == nil {
+			e.zones = make(map[int]*types.TimeZone)
+		}
+		e.zones[offset] = tz
+	}
+	dt := &types.DateTime{
+		Date: types.Date{Year: t.Year(), Month: int(t.Month()), Day: t.Day()},
+		Time: types.Time{
+			Hour:        t.Hour(),
+			Minute:      t.Minute(),
+			Second:      t.Second(),
+			Microsecond: t.Nanosecond() / 1000,
+			TZInfo:      tz,
+		},
+	}
+	return e.saveDateTime(dt, key)
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reconstructed reports whether CPython pickled p's object with copy_reg._reconstructor from
+// a base class other than object, which protocols 0 and 1 do for instances of subclasses of
+// list and dict. When such an instance is empty, nothing in what it loads as tells its base
+// class, so Marshal pickles it as an instance of an ordinary class.
+func reconstructed(p []byte) bool {
+	return bytes.Contains(p, []byte("copy_reg\n_reconstructor")) && !bytes.Contains(p, []byte("__builtin__\nobject"))
+}
+
+// TestMarshalCorpus loads the pickles CPython wrote in testdata/corpus.txt, of every protocol,
+// with shared and self-referencing objects and big ints among them, and checks that Marshal
+// pickles what they load as CPython did, byte for byte.
+func TestMarshalCorpus(t *testing.T) {
+	for _, c := range readCorpus(t) {
+		u := NewUnpickler(c.data)
+		u.AllowUnknownClasses = true
+		obj, err := u.Load()
+		if err != nil {
+			t.Errorf("%s: Load: %v", c.name, err)
+			continue
+		}
+		got, err := Marshal(obj, c.protocol)
+		if err != nil {
+			t.Errorf("%s: Marshal: %v", c.name, err)
+			continue
+		}
+		if bytes.Equal(got, c.data) {
+			continue
+		}
+		if !reconstructed(c.data) {
+			t.Errorf("%s: Marshal:\n got %q\nwant %q", c.name, got, c.data)
+			continue
+		}
+		u = NewUnpickler(got)
+		u.AllowUnknownClasses = true
+		if _, err := u.Load(); err != nil {
+			t.Errorf("%s: Load(Marshal(%x)): %v", c.name, c.data, err)
+		}
+	}
+}
+
+// TestEncoderCorpus checks that an Encoder writes the pickles of the objects in
+// testdata/corpus.txt one after another, each as Marshal does, and that Next loads them back.
+func TestEncoderCorpus(t *testing.T) {
+	corpus := readCorpus(t)
+	for protocol := 0; protocol <= int(HighestProtocol); protocol++ {
+		var buf, want bytes.Buffer
+		enc := NewEncoder(&buf, protocol)
+		var n int
+		for _, c := range corpus {
+			if c.protocol != protocol {
+				continue
+			}
+			u := NewUnpickler(c.data)
+			u.AllowUnknownClasses = true
+			obj, err := u.Load()
+			if err != nil {
+				t.Fatalf("%s: Load: %v", c.name, err)
+			}
+			if err := enc.Encode(obj); err != nil {
+				t.Fatalf("%s: Encode: %v", c.name, err)
+			}
+			p, err := Marshal(obj, protocol)
+			if err != nil {
+				t.Fatalf("%s: Marshal: %v", c.name, err)
+			}
+			want.Write(p)
+			n++
+		}
+		if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+			t.Errorf("protocol %d: Encoder and Marshal wrote different pickles", protocol)
+		}
+		u := NewUnpickler(buf.Bytes())
+		u.AllowUnknownClasses = true
+		for i := 0; i < n; i++ {
+			if _, err := u.Next(); err != nil {
+				t.Fatalf("protocol %d: pickle %d: Next: %v", protocol, i, err)
+			}
+		}
+	}
+}
+
+// TestMarshalByteArray checks that bytearrays are pickled as bytes, as CPython pickles
+// b'ab', since they load as the same type.
+func TestMarshalByteArray(t *testing.T) {
+	tests := []struct {
+		protocol        int
+		bytearray, want string // CPython's pickles of bytearray(b'ab') and b'ab'
+	}{
+		{0, "c__builtin__\nbytearray\np0\n(c_codecs\nencode\np1\n(Vab\np2\nVlatin1\np3\ntp4\nRp5\ntp6\nRp7\n.",
+			"c_codecs\nencode\np0\n(Vab\np1\nVlatin1\np2\ntp3\nRp4\n."},
+		{1, "c__builtin__\nbytearray\nq\x00(c_codecs\nencode\nq\x01(X\x02\x00\x00\x00abq\x02X\x06\x00\x00\x00latin1q\x03tq\x04Rq\x05tq\x06Rq\x07.",
+			"c_codecs\nencode\nq\x00(X\x02\x00\x00\x00abq\x01X\x06\x00\x00\x00latin1q\x02tq\x03Rq\x04."},
+		{2, "\x80\x02c__builtin__\nbytearray\nq\x00c_codecs\nencode\nq\x01X\x02\x00\x00\x00abq\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07.",
+			"\x80\x02c_codecs\nencode\nq\x00X\x02\x00\x00\x00abq\x01X\x06\x00\x00\x00latin1q\x02\x86q\x03Rq\x04."},
+		{3, "\x80\x03cbuiltins\nbytearray\nq\x00C\x02abq\x01\x85q\x02Rq\x03.",
+			"\x80\x03C\x02abq\x00."},
+		{4, "\x80\x04\x95#\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\tbytearray\x94\x93\x94C\x02ab\x94\x85\x94R\x94.",
+			"\x80\x04\x95\x06\x00\x00\x00\x00\x00\x00\x00C\x02ab\x94."},
+		{5, "\x80\x05\x95\r\x00\x00\x00\x00\x00\x00\x00\x96\x02\x00\x00\x00\x00\x00\x00\x00ab\x94.",
+			"\x80\x05\x95\x06\x00\x00\x00\x00\x00\x00\x00C\x02ab\x94."},
+	}
+	for _, test := range tests {
+		u := NewUnpickler([]byte(test.bytearray))
+		obj, err := u.Load()
+		if err != nil {
+			t.Errorf("protocol %d: Load: %v", test.protocol, err)
+			continue
+		}
+		got, err := Marshal(obj, test.protocol)
+		if err != nil {
+			t.Errorf("protocol %d: Marshal: %v", test.protocol, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("protocol %d: Marshal:\n got %q\nwant %q", test.protocol, got, test.want)
+		}
+	}
+}
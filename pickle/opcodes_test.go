@@ -0,0 +1,217 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// opcodeTests has every opcode, as pickletools.opcodes lists them, with a small pickle
+// which uses it and the JSON of what the pickle loads
+var opcodeTests = []struct {
+	code   byte
+	name   string
+	proto  int
+	pickle string
+	json   string
+}{
+	{'(', "MARK", 0, "(I1\nt.", "[1]"},
+	{'.', "STOP", 0, "N.", "null"},
+	{'0', "POP", 0, "I1\nI2\n0.", "1"},
+	{'1', "POP_MARK", 1, "I1\n(I2\nI3\n1.", "1"},
+	{'2', "DUP", 0, "(I1\n2t.", "[1,1]"},
+	{'F', "FLOAT", 0, "F1.5\n.", "1.5"},
+	{'I', "INT", 0, "I42\n.", "42"},
+	{'J', "BININT", 1, "J\xff\xff\xff\xff.", "-1"},
+	{'K', "BININT1", 1, "K\x05.", "5"},
+	{'L', "LONG", 0, "L7L\n.", "7"},
+	{'M', "BININT2", 1, "M\x00\x01.", "256"},
+	{'N', "NONE", 0, "N.", "null"},
+	{'P', "PERSID", 0, "Pabc\n.", `"abc"`},
+	{'Q', "BINPERSID", 1, "Vabc\nQ.", `"abc"`},
+	{'R', "REDUCE", 0, "c__builtin__\nset\n(]K\x01atR.", "[1]"},
+	{'S', "STRING", 0, "S'abc'\n.", `"abc"`},
+	{'T', "BINSTRING", 1, "T\x03\x00\x00\x00abc.", `"abc"`},
+	{'U', "SHORT_BINSTRING", 1, "U\x03abc.", `"abc"`},
+	{'V', "UNICODE", 0, "Vabc\n.", `"abc"`},
+	{'X', "BINUNICODE", 1, "X\x03\x00\x00\x00abc.", `"abc"`},
+	{'a', "APPEND", 0, "]I1\na.", "[1]"},
+	{'b', "BUILD", 0, "(i__main__\nA\n}U\x01aK\x01sb.", `{"a":1}`},
+	{'c', "GLOBAL", 0, "c__builtin__\nset\n(]K\x01atR.", "[1]"},
+	{'d', "DICT", 0, "(U\x01aK\x01d.", `{"a":1}`},
+	{'}', "EMPTY_DICT", 1, "}.", "{}"},
+	{'e', "APPENDS", 1, "](K\x01K\x02e.", "[1,2]"},
+	{'g', "GET", 0, "K\x01p0\n0g0\n.", "1"},
+	{'h', "BINGET", 1, "K\x01q\x000h\x00.", "1"},
+	{'i', "INST", 0, "(i__main__\nA\n.", "{}"},
+	{'j', "LONG_BINGET", 1, "K\x01r\x00\x00\x00\x000j\x00\x00\x00\x00.", "1"},
+	{'l', "LIST", 0, "(K\x01l.", "[1]"},
+	{']', "EMPTY_LIST", 1, "].", "[]"},
+	{'o', "OBJ", 1, "(c__main__\nA\no.", "{}"},
+	{'p', "PUT", 0, "K\x01p0\n0g0\n.", "1"},
+	{'q', "BINPUT", 1, "K\x01q\x000h\x00.", "1"},
+	{'r', "LONG_BINPUT", 1, "K\x01r\x00\x00\x00\x000j\x00\x00\x00\x00.", "1"},
+	{'s', "SETITEM", 0, "}U\x01aK\x01s.", `{"a":1}`},
+	{'t', "TUPLE", 0, "(K\x01K\x02t.", "[1,2]"},
+	{')', "EMPTY_TUPLE", 1, ").", "[]"},
+	{'u', "SETITEMS", 1, "}(U\x01aK\x01U\x01bK\x02u.", `{"a":1,"b":2}`},
+	{'G', "BINFLOAT", 1, "G?\xf8\x00\x00\x00\x00\x00\x00.", "1.5"},
+	{'\x80', "PROTO", 2, "\x80\x02N.", "null"},
+	{'\x81', "NEWOBJ", 2, "\x80\x02c__main__\nA\n)\x81.", "{}"},
+	{'\x82', "EXT1", 2, "\x80\x02\x82\x01.", "1"},
+	{'\x83', "EXT2", 2, "\x80\x02\x83\x00\x01.", "256"},
+	{'\x84', "EXT4", 2, "\x80\x02\x84\x00\x00\x01\x00.", "65536"},
+	{'\x85', "TUPLE1", 2, "\x80\x02K\x01\x85.", "[1]"},
+	{'\x86', "TUPLE2", 2, "\x80\x02K\x01K\x02\x86.", "[1,2]"},
+	{'\x87', "TUPLE3", 2, "\x80\x02K\x01K\x02K\x03\x87.", "[1,2,3]"},
+	{'\x88', "NEWTRUE", 2, "\x80\x02\x88.", "true"},
+	{'\x89', "NEWFALSE", 2, "\x80\x02\x89.", "false"},
+	{'\x8a', "LONG1", 2, "\x80\x02\x8a\x02\x00\x80.", "-32768"},
+	{'\x8b', "LONG4", 2, "\x80\x02\x8b\x01\x00\x00\x00\x05.", "5"},
+	{'B', "BINBYTES", 3, "\x80\x03B\x03\x00\x00\x00abc.", `"YWJj"`},
+	{'C', "SHORT_BINBYTES", 3, "\x80\x03C\x03abc.", `"YWJj"`},
+	{'\x8c', "SHORT_BINUNICODE", 4, "\x80\x04\x8c\x03abc.", `"abc"`},
+	{'\x8d', "BINUNICODE8", 4, "\x80\x04\x8d\x03\x00\x00\x00\x00\x00\x00\x00abc.", `"abc"`},
+	{'\x8e', "BINBYTES8", 4, "\x80\x04\x8e\x03\x00\x00\x00\x00\x00\x00\x00abc.", `"YWJj"`},
+	{'\x8f', "EMPTY_SET", 4, "\x80\x04\x8f.", "[]"},
+	{'\x90', "ADDITEMS", 4, "\x80\x04\x8f(K\x01\x90.", "[1]"},
+	{'\x91', "FROZENSET", 4, "\x80\x04(K\x01\x91.", "[1]"},
+	{'\x92', "NEWOBJ_EX", 4, "\x80\x04c__main__\nA\n)}\x92.", "{}"},
+	{'\x93', "STACK_GLOBAL", 4, "\x80\x04\x8c\x08builtins\x8c\x03set\x93]K\x01a\x85R.", "[1]"},
+	{'\x94', "MEMOIZE", 4, "\x80\x04K\x01\x940h\x00.", "1"},
+	{'\x95', "FRAME", 4, "\x80\x04\x95\x03\x00\x00\x00\x00\x00\x00\x00K\x01.", "1"},
+	{'\x96', "BYTEARRAY8", 5, "\x80\x05\x96\x03\x00\x00\x00\x00\x00\x00\x00abc.", `"YWJj"`},
+	{'\x97', "NEXT_BUFFER", 5, "\x80\x05\x97.", `"buffer"`},
+	{'\x98', "READONLY_BUFFER", 5, "\x80\x05\x97\x98.", `"read only buffer"`},
+}
+
+func TestOpcodes(t *testing.T) {
+	byCode := make(map[byte]int)
+	for i, tt := range opcodeTests {
+		byCode[tt.code] = i
+	}
+
+	for c := 0; c < 256; c++ {
+		code := byte(c)
+		i, known := byCode[code]
+		t.Run(fmt.Sprintf("0x%02x", code), func(t *testing.T) {
+			op, ok := LookupOpcode(code)
+			if ok != known {
+				t.Fatalf("LookupOpcode(0x%02x) gave ok %v", code, ok)
+			}
+			if (dispatch[code] != nil) != known {
+				t.Fatalf("dispatch[0x%02x] is %p", code, dispatch[code])
+			}
+
+			if !known {
+				u := NewUnpickler([]byte{code})
+				_, err := u.Load()
+				var de *DecodeError
+				var ie *InvalidOpcodeError
+				if !errors.As(err, &de) || !errors.As(err, &ie) || de.Offset != 0 || ie.Op != code {
+					t.Fatalf("Load gave %v, want an InvalidOpcodeError at offset 0", err)
+				}
+				if want := fmt.Sprintf("pickle: offset 0 (stack depth 0): unknown opcode 0x%02x", code); err.Error() != want {
+					t.Errorf("got error %q, want %q", err, want)
+				}
+				return
+			}
+
+			tt := opcodeTests[i]
+			if op.Code != code || op.Name != tt.name || op.Proto != tt.proto || op.Doc == "" {
+				t.Errorf("LookupOpcode(0x%02x) = %+v, want %s of protocol %d", code, op, tt.name, tt.proto)
+			}
+			u := NewUnpickler([]byte(tt.pickle))
+			u.AllowUnknownClasses = true
+			u.PersistentLoad = func(id types.Object) (types.Object, error) { return id, nil }
+			u.GetExtension = func(code int) (types.Object, error) { return types.Int(code), nil }
+			u.NextBuffer = func() (types.Object, error) { return types.NewString([]byte("buffer"), new([]byte)), nil }
+			u.MakeReadOnly = func(types.Object) (types.Object, error) {
+				return types.NewString([]byte("read only buffer"), new([]byte)), nil
+			}
+			obj, err := u.Load()
+			if err != nil {
+				t.Fatalf("Load(%q): %v", tt.pickle, err)
+			}
+			if got, err := JSON(obj); err != nil || got != tt.json {
+				t.Errorf("Load(%q) gave %s, %v, want %s", tt.pickle, got, err, tt.json)
+			}
+
+			// an opcode alone fails in the opcode itself, if at all, rather than being unknown
+			u = NewUnpickler([]byte{code})
+			_, err = u.Load()
+			var de *DecodeError
+			if errors.As(err, &de) && de.Offset == 0 && de.Opcode != tt.name {
+				t.Errorf("Load(%q) gave %v, want an error from %s", []byte{code}, err, tt.name)
+			}
+		})
+	}
+}
+
+// TestStrictOpcodes checks that Strict rejects opcodes newer than the pickle's protocol
+func TestStrictOpcodes(t *testing.T) {
+	for _, tt := range opcodeTests {
+		if tt.code == '\x80' || tt.code == '.' {
+			continue
+		}
+		for proto := 2; proto <= 5; proto++ {
+			u := NewUnpickler([]byte{'\x80', byte(proto), tt.code})
+			u.Strict = true
+			_, err := u.Load()
+			var ie *InvalidOpcodeError
+			if isInvalid := errors.As(err, &ie); isInvalid != (tt.proto > proto) {
+				t.Errorf("%s in a protocol %d pickle gave %v", tt.name, proto, err)
+			}
+		}
+	}
+}
+
+// TestDecodeError checks where DecodeError says decoding failed
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		pickle string
+		want   DecodeError // without Err
+		err    string
+	}{
+		{"empty", "", DecodeError{}, "pickle: offset 0 (stack depth 0): unexpected EOF"},
+		{"no STOP", "I1\n", DecodeError{Offset: 3, StackDepth: 1},
+			"pickle: offset 3 (stack depth 1): unexpected EOF"},
+		{"unknown opcode", "(I1\n\xff", DecodeError{Offset: 4, Op: 0xff, StackDepth: 1, MarkDepth: 1},
+			"pickle: offset 4 (stack depth 1): unknown opcode 0xff"},
+		{"failing opcode", "]K\x01K\x02s.", DecodeError{Offset: 5, Op: 's', Opcode: "SETITEM", StackDepth: 1},
+			"pickle: SETITEM at offset 5 (stack depth 1): SETITEM requires DictSetter"},
+		{"above marks", "(K\x01(K\x02K\x03K\x04s.", DecodeError{Offset: 10, Op: 's', Opcode: "SETITEM", StackDepth: 1, MarkDepth: 2},
+			"pickle: SETITEM at offset 10 (stack depth 1): SETITEM requires DictSetter"},
+		{"in frame", "\x80\x04\x95\x06\x00\x00\x00\x00\x00\x00\x00]K\x01K\x02s.",
+			DecodeError{Offset: 16, Op: 's', Opcode: "SETITEM", InFrame: true, StackDepth: 1},
+			"pickle: SETITEM at offset 16 in frame (stack depth 1): SETITEM requires DictSetter"},
+		{"after frame", "\x80\x04\x95\x02\x00\x00\x00\x00\x00\x00\x00K\x01\xff.", DecodeError{Offset: 13, Op: 0xff, StackDepth: 1},
+			"pickle: offset 13 (stack depth 1): unknown opcode 0xff"},
+		{"forbidden class", "cos\nsystem\n.", DecodeError{Op: 'c', Opcode: "GLOBAL"},
+			"pickle: GLOBAL at offset 0 (stack depth 0): can't unpickle type os.system"},
+		{"POP of nothing", "0.", DecodeError{Op: '0', Opcode: "POP"},
+			"pickle: POP at offset 0 (stack depth 0): the meta stack is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := NewUnpickler([]byte(tt.pickle))
+			_, err := u.Load()
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("Load gave %v, want a DecodeError", err)
+			}
+			got := *de
+			got.Err = nil
+			if got != tt.want || err.Error() != tt.err {
+				t.Errorf("Load gave %+v: %v, want %+v: %s", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package pickle
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -12,17 +13,20 @@ import (
 	"math"
 	"math/big"
 	"strconv"
+	"strings"
 	"unsafe"
 
+	"github.com/mistsys/gopickle2json/internal/codecs"
 	"github.com/mistsys/gopickle2json/types"
-	"github.com/nsd20463/bytesconv"
 )
 
 const HighestProtocol byte = 5
 
 type Unpickler struct {
-	in             []byte // unread input
-	currentFrame   []byte // nil, or unread portion of current frame
+	in             []byte    // unread input
+	size           int       // length of the input read so far, so offsets can be computed from len(in)
+	r              io.Reader // nil, or where to read more input from when in runs out
+	currentFrame   []byte    // nil, or unread portion of current frame
 	stack          []types.Object
 	metaStack      [][]types.Object
 	memo           map[uint32]types.Object
@@ -34,18 +38,47 @@ type Unpickler struct {
 	GetExtension   func(code int) (types.Object, error)
 	NextBuffer     func() (types.Object, error)
 	MakeReadOnly   func(types.Object) (types.Object, error)
-	proto          byte
+	// Strict rejects opcodes which are newer than the protocol declared by the pickle's PROTO
+	// opcode (FRAME in a protocol 2 pickle, for example), as CPython's pickletools does.
+	Strict bool
+	// AllowUnknownClasses makes classes which are neither builtin nor provided by FindClass load
+	// as a *types.GenericClass, whose instances record their arguments, state and items,
+	// instead of failing with an *UnknownClassError. FindClass can return an *UnknownClassError
+	// to leave a class to this fallback.
+	AllowUnknownClasses bool
+	// ClassPolicy restricts the classes the pickle may refer to. A class it forbids fails with
+	// a *ForbiddenClassError, before FindClass is called.
+	ClassPolicy ClassPolicy
+	// AuditClass, if set, is called with every class the pickle refers to, and whether
+	// ClassPolicy allows it, before the class is looked up. A class checked against a
+	// malformed pattern is audited as not allowed.
+	AuditClass func(module, name string, allowed bool)
+	// Limits bounds the resources the pickle may use.
+	Limits Limits
+	// Py2StringEncoding and Py2StringErrors say how to decode Python 2 str objects, which are
+	// bytes, like the encoding and errors arguments of Python 3's pickle.loads. By default the
+	// bytes are kept as they are.
+	Py2StringEncoding Py2StringEncoding
+	Py2StringErrors   Py2StringErrors
+
+	objects int // number of values pushed on the stack, for Limits.MaxObjects
+	proto   byte
+
+	// where the last STOP opcode was, for errors in the JSON of the pickle
+	stopOffset  int64
+	stopInFrame bool
 }
 
 func NewUnpickler(in []byte) Unpickler {
 	return Unpickler{
-		in: in,
+		in:   in,
+		size: len(in),
 	}
 }
 
+// Load decodes a pickle. Any input following the pickle's STOP opcode is discarded; use Next
+// to decode several pickles written one after another.
 func (u *Unpickler) Load() (types.Object, error) {
-	u.metaStack = make([][]types.Object, 0, 16)
-	u.memo = make(map[uint32]types.Object, 256+128)
 	defer func(u *Unpickler) {
 		u.in = nil
 		u.currentFrame = nil
@@ -57,28 +90,151 @@ func (u *Unpickler) Load() (types.Object, error) {
 		u.dram = nil
 		u.proto = 0
 	}(u)
+	return u.load()
+}
+
+// Next decodes the next of a sequence of pickles written one after another, as successive
+// calls to Python's pickle.dump on the same file produce. Each pickle gets a fresh stack and
+// memo. Next returns io.EOF when the input ends where another pickle would begin; Offset
+// returns where that is.
+func (u *Unpickler) Next() (types.Object, error) {
+	if len(u.in) == 0 && len(u.currentFrame) == 0 {
+		if err := u.fill(1); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, io.EOF
+			}
+			return nil, u.decodeError(u.pos(), false, -1, err)
+		}
+	}
+	obj, err := u.load()
+	u.stack = nil
+	u.metaStack = nil
+	u.memo = nil
+	u.proto = 0
+	return obj, err
+}
+
+// Offset returns the offset in the input of the next byte to be decoded. Before a call to
+// Next, it is the offset of the pickle Next will return.
+func (u *Unpickler) Offset() int64 {
+	return u.pos()
+}
 
+// LoadAll decodes each of the pickles in in, which were written one after another, and calls
+// fn with each one and its offset in in. It stops at the first error from decoding or from fn,
+// and returns it.
+func LoadAll(in []byte, fn func(offset int64, obj types.Object) error) error {
+	u := NewUnpickler(in)
 	for {
+		offset := u.Offset()
+		obj, err := u.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(offset, obj); err != nil {
+			return err
+		}
+	}
+}
+
+// load runs the machine until it reaches a STOP opcode
+func (u *Unpickler) load() (types.Object, error) {
+	u.stack = nil
+	u.metaStack = make([][]types.Object, 0, 16)
+	u.memo = make(map[uint32]types.Object, 256+128)
+	u.objects = 0
+	limited := u.Limits != Limits{}
+	for {
+		offset := u.pos()
+		inFrame := len(u.currentFrame) != 0
 		opcode, err := u.readOne()
 		if err != nil {
-			return nil, err
+			return nil, u.decodeError(offset, inFrame, -1, err)
 		}
 
 		opFunc := dispatch[opcode]
-		if opFunc == nil {
-			return nil, fmt.Errorf("unknown opcode: 0x%x '%c'", opcode, opcode)
+		if opFunc == nil || (u.Strict && !u.validOpcode(opcode)) {
+			return nil, u.decodeError(offset, inFrame, int(opcode), &InvalidOpcodeError{Op: opcode, Proto: u.declaredProto()})
 		}
 
 		err = opFunc(u)
 		if err != nil {
 			if p, ok := err.(pickleStop); ok {
+				u.stopOffset, u.stopInFrame = offset, inFrame
 				return p.value, nil
 			}
-			return nil, err
+			return nil, u.decodeError(offset, inFrame, int(opcode), err)
+		}
+		if limited {
+			if err := u.checkLimits(); err != nil {
+				return nil, u.decodeError(offset, inFrame, int(opcode), err)
+			}
 		}
 	}
 }
 
+// validOpcode returns true if opcode exists in the protocol the pickle declared
+func (u *Unpickler) validOpcode(opcode byte) bool {
+	if opcode == '\x80' {
+		// PROTO is how the protocol gets declared in the first place
+		return true
+	}
+	return opcodes[opcode].name != "" && opcodes[opcode].proto <= u.declaredProto()
+}
+
+// declaredProto returns the protocol declared by the PROTO opcode. Pickles of protocols 0 and 1
+// have no PROTO opcode and can't be told apart, so in that case it returns 1.
+func (u *Unpickler) declaredProto() byte {
+	if u.proto < 1 {
+		return 1
+	}
+	return u.proto
+}
+
+// pos returns the offset in the input of the next byte to be read
+func (u *Unpickler) pos() int64 {
+	// frames are sliced off the front of u.in, so whatever remains of the current frame
+	// immediately precedes u.in
+	return int64(u.size - len(u.in) - len(u.currentFrame))
+}
+
+// decodeError wraps err with the position of the opcode which failed. opcode is -1 if
+// no opcode could be read.
+func (u *Unpickler) decodeError(offset int64, inFrame bool, opcode int, err error) *DecodeError {
+	e := &DecodeError{
+		Offset:     offset,
+		InFrame:    inFrame,
+		StackDepth: len(u.stack),
+		MarkDepth:  len(u.metaStack),
+		Err:        err,
+	}
+	if opcode >= 0 {
+		e.Op = byte(opcode)
+		e.Opcode = opcodes[opcode].name
+	}
+	return e
+}
+
+// stopError returns err, if it's a *LimitError from writing the JSON of the pickle, as a
+// *DecodeError at the pickle's STOP opcode, since that's where its value is complete.
+// Other errors, like those writing the JSON, are returned as they are.
+func (u *Unpickler) stopError(err error) error {
+	if _, ok := err.(*LimitError); !ok {
+		return err
+	}
+	return &DecodeError{
+		Offset:     u.stopOffset,
+		InFrame:    u.stopInFrame,
+		Op:         '.',
+		Opcode:     opcodes['.'].name,
+		StackDepth: 1,
+		Err:        err,
+	}
+}
+
 type pickleStop struct{ value types.Object }
 
 func (p pickleStop) Error() string { return "STOP" }
@@ -110,6 +266,17 @@ func (u *Unpickler) NewString(s []byte) types.Object {
 }
 
 func (u *Unpickler) findClass(module, name string) (types.Object, error) {
+	allowed, err := u.ClassPolicy.Allows(module, name)
+	if u.AuditClass != nil {
+		u.AuditClass(module, name, allowed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, &ForbiddenClassError{Module: module, Name: name}
+	}
+
 	switch module {
 	case "collections":
 		switch name {
@@ -117,16 +284,75 @@ func (u *Unpickler) findClass(module, name string) (types.Object, error) {
 			return &types.OrderedDictClass{}, nil
 		}
 
-	case "__builtin__":
+	case "__builtin__", "builtins":
 		switch name {
 		case "object":
 			return &types.ObjectClass{}, nil
+		case "complex":
+			return &types.ComplexClass{}, nil
+		case "set":
+			return &types.SetClass{}, nil
+		case "frozenset":
+			return &types.FrozenSetClass{}, nil
+		case "bytes":
+			return &types.BytesClass{}, nil
+		case "bytearray":
+			return &types.ByteArrayClass{}, nil
+		}
+
+	case "datetime":
+		switch name {
+		case "datetime":
+			return &types.DateTimeClass{}, nil
+		case "date":
+			return &types.DateClass{}, nil
+		case "time":
+			return &types.TimeClass{}, nil
+		case "timedelta":
+			return &types.TimeDeltaClass{}, nil
+		case "timezone":
+			return &types.TimeZoneClass{}, nil
+		}
+
+	case "decimal":
+		switch name {
+		case "Decimal":
+			return &types.DecimalClass{}, nil
+		}
+
+	case "fractions":
+		switch name {
+		case "Fraction":
+			return &types.FractionClass{}, nil
+		}
+
+	case "_codecs":
+		switch name {
+		case "encode":
+			return &types.CodecsEncodeFunc{}, nil
+		}
+
+	case "copy_reg", "copyreg":
+		switch name {
+		case "_reconstructor":
+			return &types.ReconstructorFunc{}, nil
+		case "__newobj__":
+			return &types.NewObjFunc{}, nil
+		case "__newobj_ex__":
+			return &types.NewObjExFunc{}, nil
 		}
 	}
 	if u.FindClass != nil {
-		return u.FindClass(module, name)
+		class, err := u.FindClass(module, name)
+		var unknown *UnknownClassError
+		if err == nil || !u.AllowUnknownClasses || !errors.As(err, &unknown) {
+			return class, err
+		}
 	}
-	panic(fmt.Sprintf("can't unpickle type %s.%s", string(module), string(name)))
+	if u.AllowUnknownClasses {
+		return &types.GenericClass{Module: module, Name: name}, nil
+	}
+	return nil, &UnknownClassError{Module: module, Name: name}
 }
 
 func (u *Unpickler) read(n int) ([]byte, error) {
@@ -146,7 +372,9 @@ func (u *Unpickler) read(n int) ([]byte, error) {
 no_current_frame:
 
 	if len(u.in) < n {
-		return nil, io.ErrUnexpectedEOF
+		if err := u.fill(n); err != nil {
+			return nil, err
+		}
 	}
 	out, u.in = u.in[:n:n], u.in[n:]
 	return out, nil
@@ -159,35 +387,59 @@ func (u *Unpickler) readOne() (byte, error) {
 		return b, nil
 	}
 	if len(u.in) == 0 {
-		return 0, io.ErrUnexpectedEOF
+		if err := u.fill(1); err != nil {
+			return 0, err
+		}
 	}
 	b, u.in = u.in[0], u.in[1:]
 	return b, nil
 }
 
 // return the line as a []byte, without the terminating \n (which is required to be present)
+// Lines longer than Limits.MaxLength fail with a *LimitError.
 func (u *Unpickler) readLineBytes() ([]byte, error) {
-	var out []byte
-	var err error
 	if len(u.currentFrame) != 0 {
+		var out []byte
+		var err error
 		out, u.currentFrame, err = readLine(u.currentFrame)
-	} else {
-		out, u.in, err = readLine(u.in)
+		if err == nil {
+			err = u.checkLength(len(out))
+		}
+		return out, err
+	}
+	return u.readLineIn(u.Limits.MaxLength)
+}
+
+// read a line from u.in, reading more input if the line isn't all there yet. If maxLen isn't
+// zero, it fails as soon as more than maxLen bytes have been read without reaching the end of
+// the line, rather than buffering however much input a line claims to be.
+func (u *Unpickler) readLineIn(maxLen int) ([]byte, error) {
+	scanned := 0 // the part of u.in already known to have no \n, which needn't be searched again
+	for {
+		if i := bytes.IndexByte(u.in[scanned:], '\n'); i >= 0 {
+			i += scanned
+			if maxLen > 0 && i > maxLen {
+				return nil, &LimitError{Limit: "MaxLength", Max: int64(maxLen)}
+			}
+			out := u.in[:i:i]
+			u.in = u.in[i+1:]
+			return out, nil
+		}
+		scanned = len(u.in)
+		if maxLen > 0 && scanned > maxLen {
+			return nil, &LimitError{Limit: "MaxLength", Max: int64(maxLen)}
+		}
+		if err := u.fill(len(u.in) + 1); err != nil {
+			return nil, err
+		}
 	}
-	return out, err
 }
 
 // return the line as an unsafe string, without the terminating \n (which is required to be present)
-// The caller MUST NOT do anything which would hold onto the string after the current call to Unpickler.Load() finishes.
+// The caller MUST NOT do anything which would hold onto the string after the current call to Unpickler.Load() (or Next) finishes.
 // In return, this avoids copying a []byte to a string buffer.
 func (u *Unpickler) unsafeReadLine() (string, error) {
-	var out []byte
-	var err error
-	if len(u.currentFrame) != 0 {
-		out, u.currentFrame, err = readLine(u.currentFrame)
-	} else {
-		out, u.in, err = readLine(u.in)
-	}
+	out, err := u.readLineBytes()
 	// construct the string without copying. we hold the caller to the promise that they don't store the returned string
 	// after the current Unpickler.Load() call
 	return *(*string)(unsafe.Pointer(&out)), err
@@ -208,7 +460,9 @@ func (u *Unpickler) loadFrame(frameSize int) error {
 		return errors.New("beginning of a new frame before end of current frame")
 	}
 	if len(u.in) < frameSize {
-		return io.ErrUnexpectedEOF
+		if err := u.fill(frameSize); err != nil {
+			return err
+		}
 	}
 	u.currentFrame, u.in = u.in[:frameSize:frameSize], u.in[frameSize:]
 	return nil
@@ -216,6 +470,7 @@ func (u *Unpickler) loadFrame(frameSize int) error {
 
 func (u *Unpickler) append(element types.Object) {
 	u.stack = append(u.stack, element)
+	u.objects++
 }
 
 func (u *Unpickler) stackLast() (types.Object, error) {
@@ -261,7 +516,131 @@ func (u *Unpickler) popMark() ([]types.Object, error) {
 	return items, nil
 }
 
-var dispatch [math.MaxUint8]func(*Unpickler) error
+var dispatch [256]func(*Unpickler) error
+
+// opcodeInfo describes an opcode, as Python's pickletools does
+type opcodeInfo struct {
+	name   string // the mnemonic pickletools uses for the opcode
+	proto  byte   // the protocol which introduced the opcode
+	arg    string // how its argument is encoded, or "" if it has none
+	before string // the stack items it pops, separated by spaces
+	after  string // the stack items it pushes
+	doc    string
+}
+
+// opcodes describes every valid opcode. Invalid opcodes have an empty name.
+var opcodes = [256]opcodeInfo{
+	// Protocol 0 and 1
+	'(': {"MARK", 0, "", "", "mark", "Push markobject onto the stack."},
+	'.': {"STOP", 0, "", "any", "", "Stop the unpickling machine."},
+	'0': {"POP", 0, "", "any", "", "Discard the top stack item, shrinking the stack by one item."},
+	'1': {"POP_MARK", 1, "", "mark stackslice", "", "Pop all the stack objects at and above the topmost markobject."},
+	'2': {"DUP", 0, "", "any", "any any", "Push the top stack item onto the stack again, duplicating it."},
+	'F': {"FLOAT", 0, "floatnl", "", "float", "Newline-terminated decimal float literal."},
+	'I': {"INT", 0, "decimalnl_short", "", "int_or_bool", "Push an integer or bool."},
+	'J': {"BININT", 1, "int4", "", "int", "Push a four-byte signed integer."},
+	'K': {"BININT1", 1, "uint1", "", "int", "Push a one-byte unsigned integer."},
+	'L': {"LONG", 0, "decimalnl_long", "", "int", "Push a long integer."},
+	'M': {"BININT2", 1, "uint2", "", "int", "Push a two-byte unsigned integer."},
+	'N': {"NONE", 0, "", "", "None", "Push None on the stack."},
+	'P': {"PERSID", 0, "stringnl_noescape", "", "any", "Push an object identified by a persistent ID."},
+	'Q': {"BINPERSID", 1, "", "any", "any", "Push an object identified by a persistent ID."},
+	'R': {"REDUCE", 0, "", "any any", "any", "Push an object built from a callable and an argument tuple."},
+	'S': {"STRING", 0, "stringnl", "", "bytes_or_str", "Push a Python string object."},
+	'T': {"BINSTRING", 1, "string4", "", "bytes_or_str", "Push a Python string object."},
+	'U': {"SHORT_BINSTRING", 1, "string1", "", "bytes_or_str", "Push a Python string object."},
+	'V': {"UNICODE", 0, "unicodestringnl", "", "str", "Push a Python Unicode string object."},
+	'X': {"BINUNICODE", 1, "unicodestring4", "", "str", "Push a Python Unicode string object."},
+	'a': {"APPEND", 0, "", "list any", "list", "Append an object to a list."},
+	'b': {"BUILD", 0, "", "any any", "any", "Finish building an object, via __setstate__ or dict update."},
+	'c': {"GLOBAL", 0, "stringnl_noescape_pair", "", "any", "Push a global object (module.attr) on the stack."},
+	'd': {"DICT", 0, "", "mark stackslice", "dict", "Build a dict out of the topmost stack slice, after markobject."},
+	'}': {"EMPTY_DICT", 1, "", "", "dict", "Push an empty dict."},
+	'e': {"APPENDS", 1, "", "list mark stackslice", "list", "Extend a list by a slice of stack objects."},
+	'g': {"GET", 0, "decimalnl_short", "", "any", "Read an object from the memo and push it on the stack."},
+	'h': {"BINGET", 1, "uint1", "", "any", "Read an object from the memo and push it on the stack."},
+	'i': {"INST", 0, "stringnl_noescape_pair", "mark stackslice", "any", "Build a class instance."},
+	'j': {"LONG_BINGET", 1, "uint4", "", "any", "Read an object from the memo and push it on the stack."},
+	'l': {"LIST", 0, "", "mark stackslice", "list", "Build a list out of the topmost stack slice, after markobject."},
+	']': {"EMPTY_LIST", 1, "", "", "list", "Push an empty list."},
+	'o': {"OBJ", 1, "", "mark any stackslice", "any", "Build a class instance."},
+	'p': {"PUT", 0, "decimalnl_short", "", "", "Store the stack top into the memo. The stack is not popped."},
+	'q': {"BINPUT", 1, "uint1", "", "", "Store the stack top into the memo. The stack is not popped."},
+	'r': {"LONG_BINPUT", 1, "uint4", "", "", "Store the stack top into the memo. The stack is not popped."},
+	's': {"SETITEM", 0, "", "dict any any", "dict", "Add a key+value pair to an existing dict."},
+	't': {"TUPLE", 0, "", "mark stackslice", "tuple", "Build a tuple out of the topmost stack slice, after markobject."},
+	')': {"EMPTY_TUPLE", 1, "", "", "tuple", "Push an empty tuple."},
+	'u': {"SETITEMS", 1, "", "dict mark stackslice", "dict", "Add an arbitrary number of key+value pairs to an existing dict."},
+	'G': {"BINFLOAT", 1, "float8", "", "float", "Float stored in binary form, with 8 bytes of data."},
+
+	// Protocol 2
+	'\x80': {"PROTO", 2, "uint1", "", "", "Protocol version indicator."},
+	'\x81': {"NEWOBJ", 2, "", "any any", "any", "Build an object instance."},
+	'\x82': {"EXT1", 2, "uint1", "", "any", "Extension code."},
+	'\x83': {"EXT2", 2, "uint2", "", "any", "Extension code."},
+	'\x84': {"EXT4", 2, "int4", "", "any", "Extension code."},
+	'\x85': {"TUPLE1", 2, "", "any", "tuple", "Build a one-tuple out of the topmost item on the stack."},
+	'\x86': {"TUPLE2", 2, "", "any any", "tuple", "Build a two-tuple out of the top two items on the stack."},
+	'\x87': {"TUPLE3", 2, "", "any any any", "tuple", "Build a three-tuple out of the top three items on the stack."},
+	'\x88': {"NEWTRUE", 2, "", "", "bool", "Push True onto the stack."},
+	'\x89': {"NEWFALSE", 2, "", "", "bool", "Push False onto the stack."},
+	'\x8a': {"LONG1", 2, "long1", "", "int", "Long integer using one-byte length."},
+	'\x8b': {"LONG4", 2, "long4", "", "int", "Long integer using four-byte length."},
+
+	// Protocol 3 (Python 3.x)
+	'B': {"BINBYTES", 3, "bytes4", "", "bytes", "Push a Python bytes object."},
+	'C': {"SHORT_BINBYTES", 3, "bytes1", "", "bytes", "Push a Python bytes object."},
+
+	// Protocol 4
+	'\x8c': {"SHORT_BINUNICODE", 4, "unicodestring1", "", "str", "Push a Python Unicode string object."},
+	'\x8d': {"BINUNICODE8", 4, "unicodestring8", "", "str", "Push a Python Unicode string object."},
+	'\x8e': {"BINBYTES8", 4, "bytes8", "", "bytes", "Push a Python bytes object."},
+	'\x8f': {"EMPTY_SET", 4, "", "", "set", "Push an empty set."},
+	'\x90': {"ADDITEMS", 4, "", "set mark stackslice", "set", "Add an arbitrary number of items to an existing set."},
+	'\x91': {"FROZENSET", 4, "", "mark stackslice", "frozenset", "Build a frozenset out of the topmost slice, after markobject."},
+	'\x92': {"NEWOBJ_EX", 4, "", "any any any", "any", "Build an object instance."},
+	'\x93': {"STACK_GLOBAL", 4, "", "str str", "any", "Push a global object (module.attr) on the stack."},
+	'\x94': {"MEMOIZE", 4, "", "any", "any", "Store the stack top into the memo. The stack is not popped."},
+	'\x95': {"FRAME", 4, "uint8", "", "", "Indicate the beginning of a new frame."},
+
+	// Protocol 5
+	'\x96': {"BYTEARRAY8", 5, "bytearray8", "", "bytearray", "Push a Python bytearray object."},
+	'\x97': {"NEXT_BUFFER", 5, "", "", "buffer", "Push an out-of-band buffer object."},
+	'\x98': {"READONLY_BUFFER", 5, "", "buffer", "buffer", "Make an out-of-band buffer object read-only."},
+}
+
+// Opcode describes an opcode, as Python's pickletools does.
+type Opcode struct {
+	Code  byte
+	Name  string // the mnemonic, like "SETITEMS"
+	Proto int    // the protocol which introduced the opcode
+	// Arg is how the argument which follows the opcode is encoded, named as pickletools
+	// names it, like "uint1" or "stringnl", or "" if the opcode has none.
+	Arg string
+	// Before and After are the stack items the opcode pops and pushes, topmost last, named
+	// as pickletools names them. "mark" is a MARK, and "stackslice" the items above it.
+	Before []string
+	After  []string
+	Doc    string // a one line description
+}
+
+// LookupOpcode returns the description of the opcode code, or false if there is no such
+// opcode.
+func LookupOpcode(code byte) (Opcode, bool) {
+	op := &opcodes[code]
+	if op.name == "" {
+		return Opcode{}, false
+	}
+	return Opcode{
+		Code:   code,
+		Name:   op.name,
+		Proto:  int(op.proto),
+		Arg:    op.arg,
+		Before: strings.Fields(op.before),
+		After:  strings.Fields(op.after),
+		Doc:    op.doc,
+	}, true
+}
 
 func init() {
 	// Initialize `dispatch` assigning functions to opcodes
@@ -498,7 +877,7 @@ func loadLong(u *Unpickler) error {
 		u.append(types.NewInt(int64(i)))
 		return nil
 	}
-	if ne, isNe := err.(*bytesconv.NumError); isNe && ne.Err == bytesconv.ErrRange {
+	if ne, isNe := err.(*strconv.NumError); isNe && ne.Err == strconv.ErrRange {
 		bi, ok := new(big.Int).SetString(sub, 10)
 		if !ok {
 			return fmt.Errorf("invalid long data")
@@ -511,19 +890,22 @@ func loadLong(u *Unpickler) error {
 
 // push long from < 256 bytes
 func loadLong1(u *Unpickler) error {
-	length, err := u.readOne()
-	if err != nil {
-		return err
-	}
-	data, err := u.read(int(length))
+	data, err := readLong1Arg(u)
 	if err != nil {
 		return err
 	}
-
 	u.append(decodeLong(data))
 	return nil
 }
 
+func readLong1Arg(u *Unpickler) ([]byte, error) {
+	length, err := u.readOne()
+	if err != nil {
+		return nil, err
+	}
+	return u.readData(int(length))
+}
+
 // push really big long
 func loadLong4(u *Unpickler) error {
 	buf, err := u.read(4)
@@ -534,7 +916,7 @@ func loadLong4(u *Unpickler) error {
 	if length < 0 {
 		return fmt.Errorf("LONG pickle has negative byte count")
 	}
-	data, err := u.read(length)
+	data, err := u.readData(length)
 	if err != nil {
 		return err
 	}
@@ -544,6 +926,10 @@ func loadLong4(u *Unpickler) error {
 }
 
 func decodeLong(bytes []byte) types.Object {
+	if len(bytes) == 0 {
+		// LONG1 and LONG4 encode 0 as zero bytes
+		return types.NewInt(0)
+	}
 	msBitSet := bytes[len(bytes)-1]&0x80 != 0
 
 	if len(bytes) > 8 {
@@ -564,16 +950,21 @@ func decodeLong(bytes []byte) types.Object {
 		return types.NewLong(bi)
 	}
 
+	return types.NewInt(decodeSmallLong(bytes))
+}
+
+// decodeSmallLong decodes a two's complement little-endian integer of 1 to 8 bytes
+func decodeSmallLong(bytes []byte) int64 {
 	var ux, bitMask uint64
 	_ = bytes[len(bytes)-1]
 	for i := len(bytes) - 1; i >= 0; i-- {
 		ux = (ux << 8) | uint64(bytes[i])
 		bitMask = (bitMask << 8) | 0xFF
 	}
-	if msBitSet {
-		return types.NewInt(-(int64(^ux&bitMask) + 1))
+	if bytes[len(bytes)-1]&0x80 != 0 {
+		return -(int64(^ux&bitMask) + 1)
 	}
-	return types.NewInt(int64(ux))
+	return int64(ux)
 }
 
 // push float object; decimal string argument
@@ -602,17 +993,29 @@ func loadBinFloat(u *Unpickler) error {
 
 // push string; NL-terminated string argument
 func loadString(u *Unpickler) error {
-	data, err := u.readLineBytes()
+	data, err := readStringArg(u)
 	if err != nil {
 		return err
 	}
+	str, err := u.py2String(data)
+	if err != nil {
+		return err
+	}
+	u.append(str)
+	return nil
+}
+
+func readStringArg(u *Unpickler) ([]byte, error) {
+	data, err := u.readLineBytes()
+	if err != nil {
+		return nil, err
+	}
 	// Strip outermost quotes
 	if !isQuotedString(data) {
-		return fmt.Errorf("the STRING opcode argument must be quoted")
+		return nil, fmt.Errorf("the STRING opcode argument must be quoted")
 	}
 	data = data[1 : len(data)-1] // remove the quotes
-	u.append(u.NewString(data))
-	return nil
+	return codecs.EscapeDecode(data)
 }
 
 func isQuotedString(b []byte) bool {
@@ -621,31 +1024,34 @@ func isQuotedString(b []byte) bool {
 
 // push string; counted binary string argument
 func loadBinString(u *Unpickler) error {
-	// Deprecated BINSTRING uses signed 32-bit length
-	buf, err := u.read(4)
+	data, err := readBinStringArg(u)
 	if err != nil {
 		return err
 	}
-	length := decodeInt32(buf)
-	if length < 0 {
-		return fmt.Errorf("BINSTRING pickle has negative byte count")
-	}
-	data, err := u.read(length)
+	str, err := u.py2String(data)
 	if err != nil {
 		return err
 	}
-	u.append(u.NewString(data))
+	u.append(str)
 	return nil
 }
 
-// push bytes; counted binary string argument
-func loadBinBytes(u *Unpickler) error {
+func readBinStringArg(u *Unpickler) ([]byte, error) {
+	// Deprecated BINSTRING uses signed 32-bit length
 	buf, err := u.read(4)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	length := int(binary.LittleEndian.Uint32(buf))
-	buf, err = u.read(length)
+	length := decodeInt32(buf)
+	if length < 0 {
+		return nil, fmt.Errorf("BINSTRING pickle has negative byte count")
+	}
+	return u.readData(length)
+}
+
+// push bytes; counted binary string argument
+func loadBinBytes(u *Unpickler) error {
+	buf, err := readBinBytesArg(u)
 	if err != nil {
 		return err
 	}
@@ -653,9 +1059,18 @@ func loadBinBytes(u *Unpickler) error {
 	return nil
 }
 
+func readBinBytesArg(u *Unpickler) ([]byte, error) {
+	buf, err := u.read(4)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(buf))
+	return u.readData(length)
+}
+
 // push Unicode string; raw-unicode-escaped'd argument
 func loadUnicode(u *Unpickler) error {
-	line, err := u.readLineBytes()
+	line, err := readUnicodeArg(u)
 	if err != nil {
 		return err
 	}
@@ -663,14 +1078,36 @@ func loadUnicode(u *Unpickler) error {
 	return nil
 }
 
+func readUnicodeArg(u *Unpickler) ([]byte, error) {
+	line, err := u.readLineBytes()
+	if err != nil {
+		return nil, err
+	}
+	return codecs.DecodeRawUnicodeEscape(line)
+}
+
 // push Unicode string; counted UTF-8 string argument
 func loadBinUnicode(u *Unpickler) error {
-	buf, err := u.read(4)
+	buf, err := readBinUnicodeArg(u)
 	if err != nil {
 		return err
 	}
+	u.append(u.NewString(buf))
+	return nil
+}
+
+func readBinUnicodeArg(u *Unpickler) ([]byte, error) {
+	buf, err := u.read(4)
+	if err != nil {
+		return nil, err
+	}
 	length := int(binary.LittleEndian.Uint32(buf))
-	buf, err = u.read(length)
+	return u.readData(length)
+}
+
+// push very long string
+func loadBinUnicode8(u *Unpickler) error {
+	buf, err := readBinUnicode8Arg(u)
 	if err != nil {
 		return err
 	}
@@ -678,58 +1115,60 @@ func loadBinUnicode(u *Unpickler) error {
 	return nil
 }
 
-// push very long string
-func loadBinUnicode8(u *Unpickler) error {
+func readBinUnicode8Arg(u *Unpickler) ([]byte, error) {
 	buf, err := u.read(8)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	length := binary.LittleEndian.Uint64(buf)
 	if length > math.MaxInt64 {
-		return fmt.Errorf("BINUNICODE8 exceeds system's maximum size")
+		return nil, fmt.Errorf("BINUNICODE8 exceeds system's maximum size")
 	}
-	buf, err = u.read(int(length))
+	return u.readData(int(length))
+}
+
+// push very long bytes string
+func loadBinBytes8(u *Unpickler) error {
+	buf, err := readBinBytes8Arg(u)
 	if err != nil {
 		return err
 	}
-	u.append(u.NewString(buf))
+	u.append(types.ByteArray(buf))
 	return nil
 }
 
-// push very long bytes string
-func loadBinBytes8(u *Unpickler) error {
+func readBinBytes8Arg(u *Unpickler) ([]byte, error) {
 	buf, err := u.read(8)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	length := binary.LittleEndian.Uint64(buf)
 	if length > math.MaxInt64 {
-		return fmt.Errorf("BINBYTES8 exceeds system's maximum size")
+		return nil, fmt.Errorf("BINBYTES8 exceeds system's maximum size")
 	}
-	buf, err = u.read(int(length))
+	return u.readData(int(length))
+}
+
+// push bytearray
+func loadByteArray8(u *Unpickler) error {
+	buf, err := readByteArray8Arg(u)
 	if err != nil {
 		return err
 	}
-	u.append(types.ByteArray(buf))
+	u.append(types.NewByteArray(buf))
 	return nil
 }
 
-// push bytearray
-func loadByteArray8(u *Unpickler) error {
+func readByteArray8Arg(u *Unpickler) ([]byte, error) {
 	buf, err := u.read(8)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	length := binary.LittleEndian.Uint64(buf)
 	if length > math.MaxInt64 {
-		return fmt.Errorf("BYTEARRAY8 exceeds system's maximum size")
+		return nil, fmt.Errorf("BYTEARRAY8 exceeds system's maximum size")
 	}
-	buf, err = u.read(int(length))
-	if err != nil {
-		return err
-	}
-	u.append(types.NewByteArray(buf))
-	return nil
+	return u.readData(int(length))
 }
 
 // push next out-of-band buffer
@@ -764,25 +1203,29 @@ func loadReadOnlyBuffer(u *Unpickler) error {
 
 // push string; counted binary string argument < 256 bytes
 func loadShortBinString(u *Unpickler) error {
-	length, err := u.readOne()
+	data, err := readShortBinStringArg(u)
 	if err != nil {
 		return err
 	}
-	data, err := u.read(int(length))
+	str, err := u.py2String(data)
 	if err != nil {
 		return err
 	}
-	u.append(u.NewString(data))
+	u.append(str)
 	return nil
 }
 
-// push bytes; counted binary string argument < 256 bytes
-func loadShortBinBytes(u *Unpickler) error {
+func readShortBinStringArg(u *Unpickler) ([]byte, error) {
 	length, err := u.readOne()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	buf, err := u.read(int(length))
+	return u.readData(int(length))
+}
+
+// push bytes; counted binary string argument < 256 bytes
+func loadShortBinBytes(u *Unpickler) error {
+	buf, err := readShortBinBytesArg(u)
 	if err != nil {
 		return err
 	}
@@ -790,13 +1233,17 @@ func loadShortBinBytes(u *Unpickler) error {
 	return nil
 }
 
-// push short string; UTF-8 length < 256 bytes
-func loadShortBinUnicode(u *Unpickler) error {
+func readShortBinBytesArg(u *Unpickler) ([]byte, error) {
 	length, err := u.readOne()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	buf, err := u.read(int(length))
+	return u.readData(int(length))
+}
+
+// push short string; UTF-8 length < 256 bytes
+func loadShortBinUnicode(u *Unpickler) error {
+	buf, err := readShortBinUnicodeArg(u)
 	if err != nil {
 		return err
 	}
@@ -804,6 +1251,14 @@ func loadShortBinUnicode(u *Unpickler) error {
 	return nil
 }
 
+func readShortBinUnicodeArg(u *Unpickler) ([]byte, error) {
+	length, err := u.readOne()
+	if err != nil {
+		return nil, err
+	}
+	return u.readData(int(length))
+}
+
 // build tuple from topmost stack items
 func loadTuple(u *Unpickler) error {
 	items, err := u.popMark()
@@ -907,6 +1362,9 @@ func loadDict(u *Unpickler) error {
 		return err
 	}
 	itemsLen := len(items)
+	if itemsLen&1 != 0 {
+		return fmt.Errorf("DICT requires an even number of items")
+	}
 	d := types.NewDict(itemsLen/2, u.alloc_dram())
 	for i := 0; i < itemsLen-1; i += 2 {
 		d.Set(items[i], items[i+1])
@@ -1205,8 +1663,7 @@ func loadGet(u *Unpickler) error {
 	if err != nil {
 		return err
 	}
-	u.append(u.memo[uint32(i)])
-	return nil
+	return u.memoGet(uint32(i))
 }
 
 // push item from memo on stack; index is 1-byte arg
@@ -1215,8 +1672,7 @@ func loadBinGet(u *Unpickler) error {
 	if err != nil {
 		return err
 	}
-	u.append(u.memo[uint32(i)])
-	return nil
+	return u.memoGet(uint32(i))
 }
 
 // push item from memo on stack; index is 4-byte arg
@@ -1225,8 +1681,15 @@ func loadLongBinGet(u *Unpickler) error {
 	if err != nil {
 		return err
 	}
-	i := binary.LittleEndian.Uint32(buf)
-	u.append(u.memo[i])
+	return u.memoGet(binary.LittleEndian.Uint32(buf))
+}
+
+func (u *Unpickler) memoGet(i uint32) error {
+	value, ok := u.memo[i]
+	if !ok {
+		return fmt.Errorf("memo value not found at index %d", i)
+	}
+	u.append(value)
 	return nil
 }
 
@@ -1347,6 +1810,9 @@ func loadSetItems(u *Unpickler) error {
 	if !dictOk {
 		return fmt.Errorf("SETITEMS requires DictSetter")
 	}
+	if len(items)&1 != 0 {
+		return fmt.Errorf("SETITEMS requires an even number of items")
+	}
 	dict.SetMany(items)
 	return nil
 }
@@ -1389,49 +1855,57 @@ func loadBuild(u *Unpickler) error {
 		slotState = tuple.Get(1)
 	}
 
-	if stateDict, ok := state.(*types.Dict); ok {
-		_ = stateDict
-		panic("stateDict not implemented")
-		/*
-			instPds, instPdsOk := inst.(types.PyDictSettable)
-			if !instPdsOk {
-				return fmt.Errorf("BUILD requires a PyDictSettable instance: %#v", inst)
+	// as in CPython, the state and slot state must each be a dict or None
+	if _, isNone := state.(types.None); !isNone {
+		d, ok := dictItems(state)
+		if !ok {
+			return &UnsupportedStateError{Instance: inst, State: state, Reason: "state is not a dictionary"}
+		}
+		obj, ok := inst.(types.PyDictSettable)
+		if !ok {
+			return &UnsupportedStateError{Instance: inst, State: state, Reason: "it has no __dict__"}
+		}
+		for i := 0; i+1 < len(d); i += 2 {
+			if err := obj.PyDictSet(d[i], d[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, isNone := slotState.(types.None); slotState != nil && !isNone {
+		d, ok := dictItems(slotState)
+		if !ok {
+			return &UnsupportedStateError{Instance: inst, State: slotState, Reason: "slot state is not a dictionary"}
+		}
+		obj, ok := inst.(types.PyAttrSettable)
+		if !ok {
+			return &UnsupportedStateError{Instance: inst, State: slotState, Reason: "it has no settable attributes"}
+		}
+		for i := 0; i+1 < len(d); i += 2 {
+			name, ok := d[i].(types.String)
+			if !ok {
+				return fmt.Errorf("BUILD slot state attribute name must be a string: %#v", d[i])
 			}
-			for _, entry := range *stateDict {
-				err := instPds.PyDictSet(entry.Key, entry.Value)
-				if err != nil {
-					return err
-				}
+			if err := obj.PySetAttr(name.String(), d[i+1]); err != nil {
+				return err
 			}
-		*/
-	}
-
-	if slotStateDict, ok := slotState.(*types.Dict); ok {
-		_ = slotStateDict
-		panic("slotStateDict not implemented")
-		/*
-			/*
-				instSa, instOk := inst.(types.PyAttrSettable)
-				if !instOk {
-					return fmt.Errorf(
-						"BUILD requires a PyAttrSettable instance: %#v", inst)
-				}
-				for _, entry := range *slotStateDict {
-					sk, keyOk := entry.Key.(types.String)
-					if !keyOk {
-						return fmt.Errorf("BUILD requires string slot state keys")
-					}
-					err := instSa.PySetAttr(sk.String(), entry.Value)
-					if err != nil {
-						return err
-					}
-				}
-		*/
+		}
 	}
 
 	return nil
 }
 
+// dictItems returns the key, value pairs of a dict or OrderedDict
+func dictItems(obj types.Object) (types.Dict, bool) {
+	switch d := obj.(type) {
+	case *types.Dict:
+		return *d, true
+	case *types.OrderedDict:
+		return types.Dict(*d), true
+	}
+	return nil, false
+}
+
 // push special markobject on stack
 func loadMark(u *Unpickler) error {
 	u.metaStack = append(u.metaStack, u.stack)
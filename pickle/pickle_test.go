@@ -0,0 +1,394 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+func TestLoadLong(t *testing.T) {
+	tests := []struct {
+		name   string
+		pickle string
+		want   string
+	}{
+		// Python 2 pickles longs with protocols 0 and 1 as LONG, with a trailing L
+		{"small", "L5L\n.", "5"},
+		{"negative", "L-7L\n.", "-7"},
+		{"without L", "L42\n.", "42"},
+		{"max int64", "L9223372036854775807L\n.", "9223372036854775807"},
+		{"beyond int64", "L9223372036854775808L\n.", "9223372036854775808"},
+		{"below int64", "L-9223372036854775809L\n.", "-9223372036854775809"},
+		{"large", "L1000000000000000000000000000000L\n.", "1000000000000000000000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := NewUnpickler([]byte(tt.pickle))
+			obj, err := u.Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			got, err := JSON(obj)
+			if err != nil {
+				t.Fatalf("JSON: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"L\n.", "LL\n.", "L12x3L\n."} {
+		u := NewUnpickler([]byte(bad))
+		if _, err := u.Load(); err == nil {
+			t.Errorf("Load(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// TestLoadManyAttributes loads an instance with as many attributes as a large pickled object
+// can have, which took quadratic time when every attribute set searched all the others.
+func TestLoadManyAttributes(t *testing.T) {
+	const n = 40000
+	// protocol 2: c__main__.Foo, NEWOBJ with no arguments, then BUILD with a dict
+	p := []byte("\x80\x02c__main__\nFoo\n)\x81}(")
+	for i := 0; i < n; i++ {
+		key := "attribute" + strconv.Itoa(i)
+		p = append(p, 'X')
+		p = binary.LittleEndian.AppendUint32(p, uint32(len(key)))
+		p = append(p, key...)
+		p = append(p, 'J')
+		p = binary.LittleEndian.AppendUint32(p, uint32(i))
+	}
+	p = append(p, "ub."...)
+
+	u := NewUnpickler(p)
+	u.AllowUnknownClasses = true
+	obj, err := u.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	o, ok := obj.(*types.GenericObject)
+	if !ok {
+		t.Fatalf("Load returned %T, want *types.GenericObject", obj)
+	}
+	if len(o.Dict) != 2*n {
+		t.Fatalf("got %d attributes, want %d", len(o.Dict)/2, n)
+	}
+	if key, value := o.Dict[2*(n-1)].(types.String).String(), o.Dict[2*n-1]; key != "attribute39999" || value != types.Int(n-1) {
+		t.Errorf("last attribute is %s = %v", key, value)
+	}
+}
+
+// dictObject is an instance with a __dict__ but no __setstate__
+type dictObject struct{ types.Dict }
+
+func (o *dictObject) PyDictSet(key, value types.Object) error {
+	o.Dict = append(o.Dict, key, value)
+	return nil
+}
+
+type dictClass struct{ types.GenericClass }
+
+func (c *dictClass) PyNew(args ...types.Object) (types.Object, error) {
+	return &dictObject{}, nil
+}
+
+func TestLoadBuild(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string // the BUILD state, as protocol 2 opcodes
+		err   string
+		attrs int
+	}{
+		{"dict", "}X\x01\x00\x00\x00aK\x01s", "", 1},
+		{"none", "N", "", 0},
+		{"dict and no slots", "}X\x01\x00\x00\x00aK\x01sN\x86", "", 1},
+		{"no dict and no slots", "NN\x86", "", 0},
+		{"int", "K\x05", "state is not a dictionary", 0},
+		{"list", "]", "state is not a dictionary", 0},
+		{"list slot state", "N]\x86", "slot state is not a dictionary", 0},
+		{"slots", "N}X\x01\x00\x00\x00aK\x01s\x86", "it has no settable attributes", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := NewUnpickler([]byte("\x80\x02c__main__\nA\n)\x81" + tt.state + "b."))
+			u.FindClass = func(module, name string) (types.Object, error) {
+				return &dictClass{}, nil
+			}
+			obj, err := u.Load()
+			if tt.err != "" {
+				var se *UnsupportedStateError
+				if !errors.As(err, &se) || se.Reason != tt.err {
+					t.Fatalf("got error %v, want an UnsupportedStateError: %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if got := len(obj.(*dictObject).Dict) / 2; got != tt.attrs {
+				t.Errorf("got %d attributes, want %d", got, tt.attrs)
+			}
+		})
+	}
+}
+
+// TestHugeDecimal converts a decimal whose exact value would take a billion bits, which
+// hung when it was computed.
+func TestHugeDecimal(t *testing.T) {
+	p := []byte("\x80\x02cdecimal\nDecimal\nq\x00X\x0c\x00\x00\x001E+300000000q\x01\x85q\x02Rq\x03.")
+	u := NewUnpickler(p)
+	obj, err := u.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	v, err := ToGo(obj, GoOptions{})
+	if err != nil {
+		t.Fatalf("ToGo: %v", err)
+	}
+	if f, ok := v.(float64); !ok || !math.IsInf(f, 1) {
+		t.Errorf("ToGo gave %T %v, want +Inf", v, v)
+	}
+
+	var r big.Rat
+	var te *UnmarshalTypeError
+	if err := Unmarshal(p, &r); !errors.As(err, &te) {
+		t.Errorf("Unmarshal into a big.Rat gave %v, want an UnmarshalTypeError", err)
+	}
+	var f float64
+	if err := Unmarshal(p, &f); err != nil || !math.IsInf(f, 1) {
+		t.Errorf("Unmarshal into a float64 gave %v, %v, want +Inf", f, err)
+	}
+}
+
+// corpusObject returns the pickles of the object with the given index in the corpus, by name,
+// failing unless there is one for every protocol
+func corpusObject(t *testing.T, corpus []corpusPickle, index string) map[string][]byte {
+	t.Helper()
+	pickles := map[string][]byte{}
+	for _, c := range corpus {
+		if strings.HasPrefix(c.name, index+"/") {
+			pickles[c.name] = c.data
+		}
+	}
+	if len(pickles) != int(HighestProtocol)+1 {
+		t.Fatalf("found %d pickles of object %s in the corpus", len(pickles), index)
+	}
+	return pickles
+}
+
+// TestDateTimes decodes datetimes, dates, times and timedeltas pickled by Python 3 with every
+// protocol, which use the packed state as bytes or as a str decoded with latin1, and by
+// Python 2 with protocols 0 and 2, which use it as a str
+func TestDateTimes(t *testing.T) {
+	tests := []struct {
+		name   string
+		corpus string   // the index of the object in testdata/corpus.txt
+		py2    []string // the object pickled by Python 2 with protocols 0 and 2
+		iso    string
+		epoch  string
+	}{
+		{"datetime", "62", []string{
+			"cdatetime\ndatetime\np0\n(S'\\x07\\xe4\\x01\\x02\\x03\\x04\\x05\\x00\\x00\\x06'\np1\ntp2\nRp3\n.",
+			"\x80\x02cdatetime\ndatetime\nq\x00U\n\x07\xe4\x01\x02\x03\x04\x05\x00\x00\x06q\x01\x85q\x02Rq\x03.",
+		}, `"2020-01-02T03:04:05.000006"`, "1577934245.000006"},
+		{"datetime in UTC", "63", nil, `"2020-01-02T00:00:00+00:00"`, "1577923200.0"},
+		{"datetime in EST", "64", nil, `"2020-01-02T03:00:00-05:00"`, "1577952000.0"},
+		{"date", "65", []string{
+			"cdatetime\ndate\np0\n(S'\\x07\\xe4\\x05\\x06'\np1\ntp2\nRp3\n.",
+			"\x80\x02cdatetime\ndate\nq\x00U\x04\x07\xe4\x05\x06q\x01\x85q\x02Rq\x03.",
+		}, `"2020-05-06"`, `"2020-05-06"`},
+		{"time", "66", []string{
+			"cdatetime\ntime\np0\n(S'\\x01\\x02\\x03\\x00\\x00\\x04'\np1\ntp2\nRp3\n.",
+			"\x80\x02cdatetime\ntime\nq\x00U\x06\x01\x02\x03\x00\x00\x04q\x01\x85q\x02Rq\x03.",
+		}, `"01:02:03.000004"`, `"01:02:03.000004"`},
+		{"timedelta", "67", []string{
+			"cdatetime\ntimedelta\np0\n(I-1\nI5\nI7\ntp1\nRp2\n.",
+			"\x80\x02cdatetime\ntimedelta\nq\x00J\xff\xff\xff\xffK\x05K\x07\x87q\x01Rq\x02.",
+		}, `"-PT23H59M54.999993S"`, "-86394.999993"},
+	}
+	corpus := readCorpus(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pickles := corpusObject(t, corpus, tt.corpus)
+			for i, p := range tt.py2 {
+				pickles["python2/protocol"+strconv.Itoa(2*i)] = []byte(p)
+			}
+			for name, p := range pickles {
+				u := NewUnpickler(p)
+				obj, err := u.Load()
+				if err != nil {
+					t.Fatalf("%s: Load: %v", name, err)
+				}
+				for _, want := range []struct {
+					format TimeFormat
+					json   string
+				}{{TimeISO8601, tt.iso}, {TimeEpochSeconds, tt.epoch}} {
+					got, err := JSONWithOptions(obj, JSONOptions{TimeFormat: want.format})
+					if err != nil || got != want.json {
+						t.Errorf("%s: JSON with TimeFormat %d gave %s, %v, want %s", name, want.format, got, err, want.json)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestNumbers decodes decimals, fractions and complex numbers pickled by Python 3 with every
+// protocol, by Python 2 with protocols 0 and 2, and a few more values pickled with protocol 4,
+// and writes them in each format
+func TestNumbers(t *testing.T) {
+	formats := JSONOptions{DecimalFormat: DecimalString, ComplexFormat: ComplexPair, FractionFormat: FractionObject}
+	tests := []struct {
+		name    string
+		corpus  string   // the index of the object in testdata/corpus.txt, if it's there
+		pickles []string // pickles of the object by Python 2, or of values not in the corpus
+		json    string   // with the default formats
+		formats string   // with the other formats
+	}{
+		{"decimal", "68", []string{
+			"cdecimal\nDecimal\np0\n(S'1.50'\np1\ntp2\nRp3\n.",
+			"\x80\x02cdecimal\nDecimal\nq\x00U\x041.50q\x01\x85q\x02Rq\x03.",
+		}, "1.50", `"1.50"`},
+		{"decimal with exponent", "", []string{
+			"\x80\x04\x95#\x00\x00\x00\x00\x00\x00\x00\x8c\x07decimal\x94\x8c\x07Decimal\x94\x93\x94\x8c\x05-1E+5\x94\x85\x94R\x94.",
+		}, "-1E+5", `"-1E+5"`},
+		{"decimal infinity", "69", nil, "-Infinity", `"-Infinity"`},
+		{"decimal nan", "", []string{
+			"\x80\x04\x95!\x00\x00\x00\x00\x00\x00\x00\x8c\x07decimal\x94\x8c\x07Decimal\x94\x93\x94\x8c\x03NaN\x94\x85\x94R\x94.",
+		}, "NaN", `"NaN"`},
+		{"fraction", "70", []string{
+			"cfractions\nFraction\np0\n(S'-3/4'\np1\ntp2\nRp3\n.",
+			"\x80\x02cfractions\nFraction\nq\x00U\x04-3/4q\x01\x85q\x02Rq\x03.",
+		}, `"-3/4"`, `{"numerator":-3,"denominator":4}`},
+		{"whole fraction", "", []string{
+			"\x80\x04\x95\"\x00\x00\x00\x00\x00\x00\x00\x8c\x09fractions\x94\x8c\x08Fraction\x94\x93\x94K\x03K\x01\x86\x94R\x94.",
+		}, `"3"`, `{"numerator":3,"denominator":1}`},
+		{"complex", "71", []string{
+			"c__builtin__\ncomplex\np0\n(F1.0\nF-2.0\ntp1\nRp2\n.",
+			"\x80\x02c__builtin__\ncomplex\nq\x00G?\xf0\x00\x00\x00\x00\x00\x00G\xc0\x00\x00\x00\x00\x00\x00\x00\x86q\x01Rq\x02.",
+		}, `{"real":1.0,"imag":-2.0}`, "[1.0,-2.0]"},
+		{"infinite complex", "", []string{
+			"\x80\x04\x95.\x00\x00\x00\x00\x00\x00\x00\x8c\x08builtins\x94\x8c\x07complex\x94\x93\x94G\x00\x00\x00\x00\x00\x00\x00\x00G\x7f\xf0\x00\x00\x00\x00\x00\x00\x86\x94R\x94.",
+		}, `{"real":0.0,"imag":Infinity}`, "[0.0,Infinity]"},
+	}
+	corpus := readCorpus(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pickles := map[string][]byte{}
+			if tt.corpus != "" {
+				pickles = corpusObject(t, corpus, tt.corpus)
+			}
+			for i, p := range tt.pickles {
+				pickles["pickle"+strconv.Itoa(i)] = []byte(p)
+			}
+			for name, p := range pickles {
+				u := NewUnpickler(p)
+				obj, err := u.Load()
+				if err != nil {
+					t.Fatalf("%s: Load: %v", name, err)
+				}
+				if got, err := JSON(obj); err != nil || got != tt.json {
+					t.Errorf("%s: JSON gave %s, %v, want %s", name, got, err, tt.json)
+				}
+				if got, err := JSONWithOptions(obj, formats); err != nil || got != tt.formats {
+					t.Errorf("%s: JSON with %+v gave %s, %v, want %s", name, formats, got, err, tt.formats)
+				}
+			}
+		})
+	}
+}
+
+// TestNext decodes concatenated pickles with Next, from a slice and from a reader, and with
+// LoadAll, checking the offset of each pickle and of the error which ends the sequence
+func TestNext(t *testing.T) {
+	const framed = "\x80\x04\x95\x03\x00\x00\x00\x00\x00\x00\x00K\x03." // 3 with protocol 4
+	tests := []struct {
+		name    string
+		input   string
+		offsets []int64 // of each pickle, and then of the end or of the failing pickle
+		objs    []string
+		err     string // or "" for io.EOF
+	}{
+		{"empty", "", []int64{0}, nil, ""},
+		{"protocols", "I1\n.\x80\x02K\x02." + framed, []int64{0, 4, 9, 23}, []string{"1", "2", "3"}, ""},
+		{"fresh memo", "(lp0\n.g0\n.", []int64{0, 6}, []string{"[]"},
+			"pickle: GET at offset 6 (stack depth 0): memo value not found at index 0"},
+		{"fresh binary memo", "\x80\x02]q\x00.\x80\x02h\x00.", []int64{0, 6}, []string{"[]"},
+			"pickle: BINGET at offset 8 (stack depth 0): memo value not found at index 0"},
+		{"truncated", "I1\n.I2", []int64{0, 4}, []string{"1"},
+			"pickle: INT at offset 4 (stack depth 0): unexpected EOF"},
+		{"truncated frame", "I1\n." + framed[:12], []int64{0, 4}, []string{"1"},
+			"pickle: FRAME at offset 6 (stack depth 0): unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, reader := range []bool{false, true} {
+				u := NewUnpickler([]byte(tt.input))
+				if reader {
+					u = NewReaderUnpickler(strings.NewReader(tt.input))
+				}
+				var offsets []int64
+				var objs []string
+				var err error
+				for {
+					offsets = append(offsets, u.Offset())
+					var obj types.Object
+					if obj, err = u.Next(); err != nil {
+						break
+					}
+					s, _ := JSON(obj)
+					objs = append(objs, s)
+				}
+				msg := err.Error()
+				if err == io.EOF {
+					msg = ""
+				}
+				if msg != tt.err {
+					t.Errorf("Next from a reader %v gave %v, want %q", reader, err, tt.err)
+				}
+				if fmt.Sprint(offsets) != fmt.Sprint(tt.offsets) || fmt.Sprint(objs) != fmt.Sprint(tt.objs) {
+					t.Errorf("Next from a reader %v gave %v at offsets %v, want %v at %v", reader, objs, offsets, tt.objs, tt.offsets)
+				}
+			}
+
+			var offsets []int64
+			var objs []string
+			err := LoadAll([]byte(tt.input), func(offset int64, obj types.Object) error {
+				s, _ := JSON(obj)
+				offsets = append(offsets, offset)
+				objs = append(objs, s)
+				return nil
+			})
+			if (err == nil) != (tt.err == "") || err != nil && err.Error() != tt.err {
+				t.Errorf("LoadAll gave %v, want %q", err, tt.err)
+			}
+			if n := len(tt.offsets) - 1; fmt.Sprint(offsets) != fmt.Sprint(tt.offsets[:n]) || fmt.Sprint(objs) != fmt.Sprint(tt.objs) {
+				t.Errorf("LoadAll gave %v at offsets %v, want %v at %v", objs, offsets, tt.objs, tt.offsets[:n])
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err := LoadAll([]byte("I1\n.I2\n."), func(int64, types.Object) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("LoadAll called fn %d times and gave %v, want once and %v", n, err, stop)
+	}
+}
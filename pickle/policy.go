@@ -0,0 +1,70 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ClassPolicy restricts the classes a pickle may refer to with the GLOBAL,
+// INST and STACK_GLOBAL opcodes. The zero value allows every class.
+//
+// Classes are matched by their full name, the module and name joined by a
+// dot, like "datetime.datetime" or "mymodule.Outer.Inner". Patterns are globs
+// in the syntax of path.Match, in which "*" also matches dots, so
+// "collections.OrderedDict" matches that one class, "mymodule.*" matches
+// every class in mymodule and its submodules, and "*.Secret*" matches classes
+// named Secret-something in any module. Since "*" doesn't match a slash, and
+// no Python module or class has one in its name, a policy which isn't the
+// zero value forbids every class whose module or name contains a slash.
+//
+// The policy applies to the classes this package implements, like
+// "datetime.datetime" and "copyreg._reconstructor", as well as to those
+// provided by Unpickler.FindClass, so an Allow list must name those which the
+// pickles are expected to use.
+type ClassPolicy struct {
+	// Allow lists the patterns of the classes which may be loaded. If it is
+	// empty every class is allowed, except those Deny forbids.
+	Allow []string
+	// Deny lists the patterns of the classes which may not be loaded, even if
+	// Allow matches them.
+	Deny []string
+}
+
+// Allows returns whether the policy allows the class. It returns an error if
+// one of the patterns is malformed.
+func (p *ClassPolicy) Allows(module, name string) (bool, error) {
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return true, nil
+	}
+	class := module + "." + name
+	if strings.Contains(class, "/") {
+		return false, nil
+	}
+	denied, err := matchAny(p.Deny, class)
+	if err != nil || denied {
+		return false, err
+	}
+	if len(p.Allow) == 0 {
+		return true, nil
+	}
+	return matchAny(p.Allow, class)
+}
+
+// matchAny returns whether any of the patterns matches class
+func matchAny(patterns []string, class string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, class)
+		if err != nil {
+			return false, fmt.Errorf("ClassPolicy pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
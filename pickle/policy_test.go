@@ -0,0 +1,86 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"errors"
+	"path"
+	"testing"
+)
+
+func TestClassPolicyAllows(t *testing.T) {
+	tests := []struct {
+		policy        ClassPolicy
+		module, name  string
+		allowed, fail bool
+	}{
+		{ClassPolicy{}, "os", "system", true, false},
+		{ClassPolicy{}, "os/x", "system", true, false},
+		{ClassPolicy{}, "os", "[", true, false},
+		{ClassPolicy{Allow: []string{"datetime.*"}}, "datetime", "datetime", true, false},
+		{ClassPolicy{Allow: []string{"datetime.*"}}, "os", "system", false, false},
+		{ClassPolicy{Allow: []string{"mymodule.*"}}, "mymodule.sub", "Outer.Inner", true, false},
+		{ClassPolicy{Allow: []string{"*.Secret*"}}, "a.b", "SecretKey", true, false},
+		{ClassPolicy{Allow: []string{"*.Secret*"}}, "a.b", "Key", false, false},
+		{ClassPolicy{Allow: []string{"collections.OrderedDict"}}, "collections", "OrderedDictX", false, false},
+		{ClassPolicy{Deny: []string{"os.*"}}, "os", "system", false, false},
+		{ClassPolicy{Deny: []string{"os.*"}}, "datetime", "date", true, false},
+		// Deny takes precedence over Allow
+		{ClassPolicy{Allow: []string{"*"}, Deny: []string{"os.*"}}, "os", "system", false, false},
+		{ClassPolicy{Allow: []string{"os.system"}, Deny: []string{"os.system"}}, "os", "system", false, false},
+		{ClassPolicy{Allow: []string{"os.*"}, Deny: []string{"os.system"}}, "os", "path", true, false},
+		// "*" doesn't match a slash, so a class with one is forbidden by any policy
+		{ClassPolicy{Deny: []string{"os.*"}}, "os", "a/b", false, false},
+		{ClassPolicy{Allow: []string{"*"}}, "os/x", "system", false, false},
+		// malformed patterns
+		{ClassPolicy{Allow: []string{"os.[system"}}, "os", "system", false, true},
+		{ClassPolicy{Deny: []string{"os.\\"}}, "datetime", "date", false, true},
+		{ClassPolicy{Allow: []string{"datetime.*"}, Deny: []string{"x[^"}}, "datetime", "date", false, true},
+		// an Allow pattern after the one which matches isn't tried
+		{ClassPolicy{Allow: []string{"datetime.*", "["}}, "datetime", "date", true, false},
+	}
+	for _, test := range tests {
+		allowed, err := test.policy.Allows(test.module, test.name)
+		if allowed != test.allowed || (err != nil) != test.fail {
+			t.Errorf("%+v.Allows(%q, %q) = %v, %v, want %v, error %v",
+				test.policy, test.module, test.name, allowed, err, test.allowed, test.fail)
+		}
+		if err != nil && !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("%+v.Allows(%q, %q) error %v isn't a path.ErrBadPattern", test.policy, test.module, test.name, err)
+		}
+	}
+}
+
+// TestClassPolicyAudit checks that AuditClass is called with every class, whether or not the
+// policy allows it, including when a pattern is malformed.
+func TestClassPolicyAudit(t *testing.T) {
+	tests := []struct {
+		policy  ClassPolicy
+		allowed bool
+		fail    bool
+	}{
+		{ClassPolicy{}, true, false},
+		{ClassPolicy{Deny: []string{"os.*"}}, false, true},
+		{ClassPolicy{Allow: []string{"os.[system"}}, false, true},
+	}
+	for _, test := range tests {
+		u := NewUnpickler([]byte("\x80\x02cos\nsystem\nq\x00."))
+		u.AllowUnknownClasses = true
+		u.ClassPolicy = test.policy
+		var audited []string
+		var allowed bool
+		u.AuditClass = func(module, name string, ok bool) {
+			audited = append(audited, module+"."+name)
+			allowed = ok
+		}
+		_, err := u.Load()
+		if (err != nil) != test.fail {
+			t.Errorf("%+v: Load error %v, want error %v", test.policy, err, test.fail)
+		}
+		if len(audited) != 1 || audited[0] != "os.system" || allowed != test.allowed {
+			t.Errorf("%+v: audited %q, allowed %v, want [os.system], allowed %v", test.policy, audited, allowed, test.allowed)
+		}
+	}
+}
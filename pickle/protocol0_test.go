@@ -0,0 +1,55 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestProtocol0Golden loads the protocol 0 pickles in testdata/protocol0, which CPython 2.7
+// and 3.x dumped, and checks their JSON against what CPython loads from them, as
+// testdata/protocol0/gen.py writes it.
+//
+// The JSON differs from CPython 3's on purpose in one way: a UTF-16 surrogate pair escaped in
+// UNICODE, like \ud83d\ude00, is joined into the character it encodes (U+1F600), as Python 2
+// does, while Python 3 keeps it as two lone surrogates. gen.py joins them too. Lone surrogates
+// are kept, and are written as \u escapes, as json.dumps writes them.
+func TestProtocol0Golden(t *testing.T) {
+	pickles, err := filepath.Glob("testdata/protocol0/*.pickle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pickles) == 0 {
+		t.Fatal("no pickles in testdata/protocol0")
+	}
+	for _, path := range pickles {
+		name := strings.TrimSuffix(filepath.Base(path), ".pickle")
+		t.Run(name, func(t *testing.T) {
+			p, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(path, ".pickle") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := NewUnpickler(p)
+			obj, err := u.Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			got, err := JSON(obj)
+			if err != nil {
+				t.Fatalf("JSON: %v", err)
+			}
+			if got != strings.TrimSuffix(string(want), "\n") {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"unicode/utf8"
+
+	"github.com/mistsys/gopickle2json/internal/codecs"
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// Py2StringEncoding says how to decode the str objects of Python 2, which are
+// bytes, and which STRING, BINSTRING and SHORT_BINSTRING push. It is like the
+// encoding argument of Python 3's pickle.loads.
+type Py2StringEncoding int
+
+const (
+	// Py2StringRaw keeps the bytes as a string as they are. Bytes which aren't
+	// UTF-8 become U+FFFD when the string is written as JSON.
+	Py2StringRaw Py2StringEncoding = iota
+	// Py2StringASCII decodes them as ASCII, which is Python 3's default.
+	Py2StringASCII
+	// Py2StringLatin1 decodes them as latin-1, so every byte is a character.
+	// Python 3 needs this to load the datetimes pickled by Python 2.
+	Py2StringLatin1
+	// Py2StringUTF8 decodes them as UTF-8.
+	Py2StringUTF8
+	// Py2StringBytes loads them as types.ByteArray, like encoding="bytes".
+	Py2StringBytes
+)
+
+// Py2StringErrors says what to do with bytes which Py2StringASCII or
+// Py2StringUTF8 can't decode. It is like the errors argument of Python 3's
+// pickle.loads.
+type Py2StringErrors int
+
+const (
+	// Py2StringStrict fails to load the pickle.
+	Py2StringStrict Py2StringErrors = iota
+	// Py2StringReplace replaces them with U+FFFD.
+	Py2StringReplace
+	// Py2StringSurrogateEscape replaces each byte b with the lone surrogate
+	// U+DC00+b, as Python does. It is written to JSON as a "\udcXX" escape.
+	Py2StringSurrogateEscape
+)
+
+// py2String returns the object for a Python 2 str
+func (u *Unpickler) py2String(s []byte) (types.Object, error) {
+	if u.Py2StringEncoding == Py2StringBytes {
+		return types.ByteArray(s), nil
+	}
+	s, err := u.decodePy2String(s)
+	if err != nil {
+		return nil, err
+	}
+	return u.NewString(s), nil
+}
+
+// decodePy2String decodes a Python 2 str to UTF-8 as Py2StringEncoding and Py2StringErrors
+// say. It returns s itself if s is ASCII.
+func (u *Unpickler) decodePy2String(s []byte) ([]byte, error) {
+	if u.Py2StringEncoding == Py2StringRaw {
+		return s, nil
+	}
+	i := 0
+	for i < len(s) && s[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(s) {
+		return s, nil
+	}
+	if u.Py2StringEncoding == Py2StringUTF8 && utf8.Valid(s[i:]) {
+		return s, nil
+	}
+	out := make([]byte, i, len(s)+len(s)/2)
+	copy(out, s)
+	for i < len(s) {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			out = append(out, c)
+			i++
+			continue
+		}
+		n, reason := 1, "ordinal not in range(128)"
+		switch u.Py2StringEncoding {
+		case Py2StringLatin1:
+			out = utf8.AppendRune(out, rune(c))
+			i++
+			continue
+		case Py2StringUTF8:
+			var r rune
+			if r, n = utf8.DecodeRune(s[i:]); r != utf8.RuneError || n != 1 {
+				out = append(out, s[i:i+n]...)
+				i += n
+				continue
+			}
+			n, reason = codecs.InvalidUTF8(s[i:])
+		}
+		switch u.Py2StringErrors {
+		case Py2StringReplace:
+			out = utf8.AppendRune(out, utf8.RuneError)
+		case Py2StringSurrogateEscape:
+			for _, b := range s[i : i+n] {
+				out = codecs.AppendRune(out, 0xdc00+rune(b))
+			}
+		default:
+			codec := "ascii"
+			if u.Py2StringEncoding == Py2StringUTF8 {
+				codec = "utf-8"
+			}
+			return nil, &codecs.DecodeError{Codec: codec, Byte: c, Start: i, End: i + n, Reason: reason}
+		}
+		i += n
+	}
+	return out, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestPy2Strings loads Python 2 str objects pickled with protocols 0 and 2, with every
+// Py2StringEncoding and Py2StringErrors, through both Load and Transcode, whose single pass
+// decodes them separately. The strings and errors are those of Python 3's pickle.loads with the
+// same encoding and errors arguments, which has no equivalent of Py2StringRaw.
+func TestPy2Strings(t *testing.T) {
+	// Python 2's pickles of 'caf\xe9', which isn't UTF-8, 'caf\xc3\xa9', which is, and
+	// '\xe9\xc3\xa9x', which is partly UTF-8
+	latin1 := []string{"S'caf\\xe9'\np0\n.", "\x80\x02U\x04caf\xe9q\x00."}
+	utf8 := []string{"S'caf\\xc3\\xa9'\np0\n.", "\x80\x02U\x05caf\xc3\xa9q\x00."}
+	mixed := []string{"S'\\xe9\\xc3\\xa9x'\np0\n.", "\x80\x02U\x04\xe9\xc3\xa9xq\x00."}
+	tests := []struct {
+		pickles  []string
+		encoding Py2StringEncoding
+		errors   Py2StringErrors
+		want     string // the JSON, or the end of the error
+	}{
+		{latin1, Py2StringRaw, Py2StringStrict, `"caf�"`},
+		{latin1, Py2StringASCII, Py2StringStrict, "'ascii' codec can't decode byte 0xe9 in position 3: ordinal not in range(128)"},
+		{latin1, Py2StringASCII, Py2StringReplace, `"caf�"`},
+		{latin1, Py2StringASCII, Py2StringSurrogateEscape, `"caf\udce9"`},
+		{latin1, Py2StringLatin1, Py2StringStrict, `"café"`},
+		{latin1, Py2StringLatin1, Py2StringSurrogateEscape, `"café"`},
+		{latin1, Py2StringUTF8, Py2StringStrict, "'utf-8' codec can't decode byte 0xe9 in position 3: unexpected end of data"},
+		{latin1, Py2StringUTF8, Py2StringReplace, `"caf�"`},
+		{latin1, Py2StringUTF8, Py2StringSurrogateEscape, `"caf\udce9"`},
+		{latin1, Py2StringBytes, Py2StringStrict, `"Y2Fm6Q=="`},
+
+		{utf8, Py2StringRaw, Py2StringStrict, `"café"`},
+		{utf8, Py2StringASCII, Py2StringStrict, "'ascii' codec can't decode byte 0xc3 in position 3: ordinal not in range(128)"},
+		{utf8, Py2StringASCII, Py2StringReplace, `"caf��"`},
+		{utf8, Py2StringASCII, Py2StringSurrogateEscape, `"caf\udcc3\udca9"`},
+		{utf8, Py2StringLatin1, Py2StringStrict, `"cafÃ©"`},
+		{utf8, Py2StringUTF8, Py2StringStrict, `"café"`},
+		{utf8, Py2StringUTF8, Py2StringReplace, `"café"`},
+		{utf8, Py2StringUTF8, Py2StringSurrogateEscape, `"café"`},
+		{utf8, Py2StringBytes, Py2StringReplace, `"Y2Fmw6k="`},
+
+		{mixed, Py2StringRaw, Py2StringStrict, `"�éx"`},
+		{mixed, Py2StringASCII, Py2StringStrict, "'ascii' codec can't decode byte 0xe9 in position 0: ordinal not in range(128)"},
+		{mixed, Py2StringASCII, Py2StringReplace, `"���x"`},
+		{mixed, Py2StringASCII, Py2StringSurrogateEscape, `"\udce9\udcc3\udca9x"`},
+		{mixed, Py2StringLatin1, Py2StringReplace, `"éÃ©x"`},
+		{mixed, Py2StringUTF8, Py2StringStrict, "'utf-8' codec can't decode byte 0xe9 in position 0: invalid continuation byte"},
+		{mixed, Py2StringUTF8, Py2StringReplace, `"�éx"`},
+		{mixed, Py2StringUTF8, Py2StringSurrogateEscape, `"\udce9éx"`},
+		{mixed, Py2StringBytes, Py2StringSurrogateEscape, `"6cOpeA=="`},
+	}
+	for _, test := range tests {
+		want := test.want
+		for protocol, p := range test.pickles {
+			for _, via := range []string{"Encode", "Transcode"} {
+				name := fmt.Sprintf("%q with encoding %d errors %d via %s", p, test.encoding, test.errors, via)
+				var b strings.Builder
+				enc := NewJSONEncoder(&b, JSONOptions{})
+				u := NewUnpickler([]byte(p))
+				u.Py2StringEncoding = test.encoding
+				u.Py2StringErrors = test.errors
+				var err error
+				if via == "Encode" {
+					obj, lerr := u.Load()
+					if err = lerr; err == nil {
+						err = enc.Encode(obj)
+					}
+				} else {
+					err = u.Transcode(enc)
+				}
+				got := strings.TrimSuffix(b.String(), "\n")
+				if err != nil {
+					got = err.Error()
+				}
+				if got != want && !(err != nil && strings.HasSuffix(got, ": "+want)) {
+					t.Errorf("protocol %d: %s = %s, want %s", []int{0, 2}[protocol], name, got, want)
+				}
+			}
+		}
+	}
+}
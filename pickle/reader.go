@@ -0,0 +1,64 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import "io"
+
+// readChunk is the minimum amount of input a reader backed Unpickler reads at once
+const readChunk = 64 * 1024
+
+// NewReaderUnpickler returns an Unpickler which reads its input incrementally
+// from r, rather than needing the whole pickle in memory up front. Protocol 4
+// and 5 pickles are read a FRAME at a time.
+//
+// Unlike NewUnpickler, the Unpickler owns the buffers it reads into, so there
+// is no restriction on what the caller does afterwards. The Unpickler may read
+// past the end of the pickle.
+//
+// Errors and offsets are the same as those of an Unpickler made by
+// NewUnpickler from the same bytes, except that errors from r are returned as
+// is (wrapped in a *DecodeError).
+func NewReaderUnpickler(r io.Reader) Unpickler {
+	return Unpickler{
+		r: r,
+	}
+}
+
+// fill reads from u.r until at least n bytes of input are buffered in u.in.
+// If there is no u.r, or it runs out of input first, it returns io.ErrUnexpectedEOF.
+func (u *Unpickler) fill(n int) error {
+	if u.r == nil {
+		return io.ErrUnexpectedEOF
+	}
+	for len(u.in) < n {
+		if len(u.in) == cap(u.in) {
+			// objects already decoded may point into u.in, so rather than reuse it, copy what
+			// remains into a new buffer. Grow geometrically rather than trusting n, which can
+			// be an arbitrary length claimed by the pickle. Nothing is ever handed out of the
+			// spare capacity beyond len(u.in), so it can be read into until it runs out.
+			grow := len(u.in)
+			if grow < readChunk {
+				grow = readChunk
+			}
+			buf := make([]byte, len(u.in), len(u.in)+grow)
+			copy(buf, u.in)
+			u.in = buf
+		}
+		want := n - len(u.in)
+		if spare := cap(u.in) - len(u.in); want > spare {
+			want = spare
+		}
+		m, err := io.ReadAtLeast(u.r, u.in[len(u.in):cap(u.in)], want)
+		u.in = u.in[:len(u.in)+m]
+		u.size += m
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
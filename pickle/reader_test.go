@@ -0,0 +1,78 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// chunkReader returns at most n bytes from each Read, as a pipe or socket can
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	return c.r.Read(p)
+}
+
+func TestReaderUnpickler(t *testing.T) {
+	pickles := []string{
+		"I42\n.",
+		"(lp0\nI1\naVa\\u20acb\np1\naF1.5\na.",
+		"(dp0\nS'key'\np1\n(I1\nI2\ntp2\ns.",
+		"\x80\x02]q\x00(K\x01X\x03\x00\x00\x00abcq\x01e.",
+		"\x80\x04\x95\r\x00\x00\x00\x00\x00\x00\x00]\x94(K\x01\x8c\x03abc\x94e.",
+	}
+	for _, p := range pickles {
+		u := NewUnpickler([]byte(p))
+		obj, err := u.Load()
+		if err != nil {
+			t.Fatalf("Load(%q): %v", p, err)
+		}
+		want, err := JSON(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u = NewReaderUnpickler(iotest.OneByteReader(strings.NewReader(p)))
+		obj, err = u.Load()
+		if err != nil {
+			t.Fatalf("Load(%q) from a reader: %v", p, err)
+		}
+		if got, _ := JSON(obj); got != want {
+			t.Errorf("Load(%q) from a reader gave %s, want %s", p, got, want)
+		}
+	}
+}
+
+// TestReaderUnpicklerLongLine reads a long line from a reader which returns little at a time.
+// Copying the buffer on every short read, and searching the whole line again for its end,
+// made this take quadratic time.
+func TestReaderUnpicklerLongLine(t *testing.T) {
+	const n = 8 << 20
+	p := make([]byte, 0, n+10)
+	p = append(p, 'V')
+	p = append(p, bytes.Repeat([]byte{'a'}, n)...)
+	p = append(p, "\np0\n."...)
+
+	u := NewReaderUnpickler(&chunkReader{r: bytes.NewReader(p), n: 4096})
+	obj, err := u.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s, ok := obj.(types.String); !ok || len(s.String()) != n {
+		t.Errorf("Load returned %T, want a string of %d bytes", obj, n)
+	}
+}
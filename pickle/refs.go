@@ -0,0 +1,268 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// Python's memo lets a pickle refer to the same list, dict, set or instance
+// more than once, and even from inside itself. These are the objects with an
+// identity, which the JSONEncoder tracks by their pointers.
+
+// jsonLevel is a JSON list or object which the JSONEncoder is in the middle of writing
+type jsonLevel struct {
+	ptr   unsafe.Pointer // the object being written, if it has an identity, else nil
+	items []types.Object // its items, or keys and values
+	dict  bool           // whether items are keys and values
+	child int            // the index in items of the item, or key, being written
+}
+
+// jsonRef is what PreserveSharing knows about an object with an identity
+type jsonRef struct {
+	count int // number of references to the object
+	id    int // its "$id", once it has been written, else 0
+}
+
+// shallowLevels is how many levels are searched for cycles one by one. Deeper levels are
+// kept in a map, so that very deep objects don't take quadratic time.
+const shallowLevels = 32
+
+// keys of the objects written for shared objects and cycles
+var (
+	idKey     = types.SimpleString("$id")
+	valuesKey = types.SimpleString("$values")
+)
+
+// enter begins writing a container, which must be ended with leave
+func (e *JSONEncoder) enter(ptr unsafe.Pointer, items []types.Object, dict bool) error {
+	if e.maxDepth > 0 && len(e.levels) >= e.maxDepth {
+		return &LimitError{Limit: "MaxDepth", Max: int64(e.maxDepth)}
+	}
+	if ptr != nil && len(e.levels) >= shallowLevels {
+		if e.deep == nil {
+			e.deep = make(map[unsafe.Pointer]int)
+		}
+		e.deep[ptr] = len(e.levels)
+	}
+	e.levels = append(e.levels, jsonLevel{ptr: ptr, items: items, dict: dict})
+	return nil
+}
+
+func (e *JSONEncoder) leave() {
+	n := len(e.levels) - 1
+	if n >= shallowLevels && e.levels[n].ptr != nil {
+		delete(e.deep, e.levels[n].ptr)
+	}
+	e.levels[n] = jsonLevel{}
+	e.levels = e.levels[:n]
+}
+
+// active returns the level at which the object ptr is being written, or -1 if it isn't
+func (e *JSONEncoder) active(ptr unsafe.Pointer) int {
+	shallow := e.levels
+	if len(shallow) > shallowLevels {
+		shallow = shallow[:shallowLevels]
+	}
+	for i := range shallow {
+		if shallow[i].ptr == ptr {
+			return i
+		}
+	}
+	if len(e.levels) > shallowLevels {
+		if i, ok := e.deep[ptr]; ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// reference handles a reference to obj, an object with an identity, which has already been
+// written or is being written: a cycle, or with PreserveSharing, any reference after the
+// first. It returns true if it wrote obj, or failed.
+func (e *JSONEncoder) reference(obj types.Object, ptr unsafe.Pointer) (bool, error) {
+	if e.opts.PreserveSharing {
+		r := e.refs[ptr]
+		switch {
+		case r.id != 0:
+			e.buf = append(e.buf, `{"$ref":"`...)
+			e.buf = strconv.AppendInt(e.buf, int64(r.id), 10)
+			e.buf = append(e.buf, `"}`...)
+			return true, nil
+		case r.count > 1:
+			e.nextID++
+			r.id = e.nextID
+			e.refs[ptr] = r
+			return true, e.encodeWithID(obj, types.NewString([]byte(strconv.Itoa(r.id)), new([]byte)))
+		}
+		return false, nil
+	}
+
+	level := e.active(ptr)
+	if level < 0 {
+		return false, nil
+	}
+	switch e.opts.Cycles {
+	case CyclesNull:
+		e.buf = append(e.buf, "null"...)
+		return true, nil
+	case CyclesRef:
+		e.buf = append(e.buf, `{"$ref":`...)
+		e.buf = types.AppendJSONString(e.buf, []byte(e.pointer(level)))
+		e.buf = append(e.buf, '}')
+		return true, nil
+	}
+	return true, &CycleError{Path: e.pointer(len(e.levels)), Ref: e.pointer(level)}
+}
+
+// encodeWithID writes the first reference to an object which is referred to more than once
+func (e *JSONEncoder) encodeWithID(obj types.Object, id types.Object) error {
+	switch o := obj.(type) {
+	case *types.Dict:
+		return e.encodeDict(withID(*o, id), unsafe.Pointer(o))
+	case *types.OrderedDict:
+		return e.encodeDict(withID(types.Dict(*o), id), unsafe.Pointer(o))
+	case *types.List:
+		return e.encodeDict(types.Dict{&idKey, id, &valuesKey, types.Tuple(*o)}, unsafe.Pointer(o))
+	case *types.Set:
+		items := []types.Object(*o)
+		if e.opts.Canonical {
+			items = e.sortSet(items)
+		}
+		return e.encodeDict(types.Dict{&idKey, id, &valuesKey, types.Tuple(items)}, unsafe.Pointer(o))
+	case *types.GenericObject:
+		return e.encodeInstance(o, id)
+	}
+	return e.encode(obj)
+}
+
+// escapeKey returns whether a dict key is written with another "$" in front of it, so that it
+// can't be taken for one of the keys written for shared objects and cycles
+func (e *JSONEncoder) escapeKey(key types.Object) bool {
+	if !e.opts.PreserveSharing && e.opts.Cycles != CyclesRef {
+		return false
+	}
+	if key == types.Object(&idKey) || key == types.Object(&valuesKey) {
+		return false
+	}
+	s, ok := key.(types.String)
+	return ok && strings.HasPrefix(s.String(), "$")
+}
+
+// withID returns d with an "$id" key first, if id isn't nil
+func withID(d types.Dict, id types.Object) types.Dict {
+	if id == nil {
+		return d
+	}
+	return append(types.Dict{&idKey, id}, d...)
+}
+
+// pointerEscaper escapes a JSON Pointer reference token, as RFC 6901 requires
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// pointer returns the JSON Pointer, in URI fragment form like "#/a/0", of the container at
+// the given level, or of the value being written if level is len(e.levels)
+func (e *JSONEncoder) pointer(level int) string {
+	var b strings.Builder
+	b.WriteByte('#')
+	for _, l := range e.levels[:level] {
+		b.WriteByte('/')
+		if !l.dict {
+			b.WriteString(strconv.Itoa(l.child))
+			continue
+		}
+		var token string
+		if s, ok := l.items[l.child].(types.String); ok {
+			token = s.String()
+			if e.escapeKey(s) {
+				token = "$" + token
+			}
+		} else {
+			// scalar keys are written as strings which need no escaping
+			key, _ := e.appendKey(nil, l.items[l.child])
+			token = string(key[1 : len(key)-1])
+		}
+		b.WriteString(pointerEscaper.Replace(token))
+	}
+	return b.String()
+}
+
+// countRefs counts the references to each object with an identity in obj, for
+// PreserveSharing. It follows only what will be written, and goes no deeper than MaxDepth.
+func (e *JSONEncoder) countRefs(obj types.Object, depth int) {
+	if e.maxDepth > 0 && depth > e.maxDepth {
+		return
+	}
+	var ptr unsafe.Pointer
+	var items []types.Object
+	switch o := obj.(type) {
+	case *types.Dict:
+		ptr, items = unsafe.Pointer(o), *o
+	case *types.OrderedDict:
+		ptr, items = unsafe.Pointer(o), *o
+	case *types.List:
+		ptr, items = unsafe.Pointer(o), *o
+	case *types.Set:
+		ptr, items = unsafe.Pointer(o), *o
+	case types.Tuple:
+		items = o
+	case types.FrozenSet:
+		items = o
+	case *types.GenericObject:
+		if e.opts.Objects == ObjectNull {
+			return
+		}
+		ptr = unsafe.Pointer(o)
+	default:
+		return
+	}
+	if ptr != nil {
+		if e.refs == nil {
+			e.refs = make(map[unsafe.Pointer]jsonRef)
+		}
+		r := e.refs[ptr]
+		r.count++
+		e.refs[ptr] = r
+		if r.count > 1 {
+			return
+		}
+	}
+	for _, item := range items {
+		e.countRefs(item, depth+1)
+	}
+	if o, ok := obj.(*types.GenericObject); ok {
+		e.countInstanceRefs(o, depth+1)
+	}
+}
+
+// countInstanceRefs counts the references in the parts of an instance which JSONOptions.Objects
+// writes
+func (e *JSONEncoder) countInstanceRefs(o *types.GenericObject, depth int) {
+	var parts []types.Object
+	switch {
+	case e.opts.Objects == ObjectReduce:
+		parts = append(parts, o.ConstructorArgs...)
+		if o.State != nil {
+			parts = append(parts, o.State)
+		} else {
+			parts = append(parts, o.Attributes()...)
+		}
+		parts = append(parts, o.ListItems...)
+		parts = append(parts, o.DictItems...)
+	case len(o.ListItems) != 0:
+		parts = o.ListItems
+	case len(o.DictItems) != 0:
+		parts = o.DictItems
+	default:
+		parts = o.Attributes()
+	}
+	for _, part := range parts {
+		e.countRefs(part, depth)
+	}
+}
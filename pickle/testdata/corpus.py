@@ -0,0 +1,81 @@
+# Writes corpus.txt: a variety of objects pickled with each protocol, one per
+# line as "index protocol hex". Run with Python 3.8 or later:
+#
+#	python3 corpus.py > corpus.txt
+
+import collections
+import datetime
+import decimal
+import fractions
+import pickle
+
+
+class C:
+    pass
+
+
+class S:
+    __slots__ = ('x', 'y')
+
+
+class L(list):
+    pass
+
+
+class D(dict):
+    pass
+
+
+def inst(**kw):
+    c = C()
+    c.__dict__.update(kw)
+    return c
+
+
+objs = [
+    None, True, False, 0, 1, 255, 256, 65535, 65536, -1, -128, -129,
+    2**31 - 1, 2**31, -2**31, -2**31 - 1, 2**63, -2**63, 2**64, 2**200, -2**200,
+    0.0, -0.0, 1.5, 1e16, 1e-7, 0.1, float('inf'), float('-inf'),
+    '', 'a', 'hello', 'é€😀', 'a\\b\nc\r\x00\x1a', 'x' * 300,
+    b'', b'ab', b'\x00\xff' * 200,
+    (), (1,), (1, 2), (1, 2, 3), (1, 2, 3, 4), ((),),
+    [], [1], [1, 2], list(range(1001)),
+    {}, {'a': 1}, {'a': 1, 'b': [1, 2]}, {i: i for i in range(1001)}, {(1, 2): 'x'},
+    set(), {1}, {1, 2, 3}, set(range(1001)), frozenset(), frozenset([1]), frozenset([1, 2]),
+    collections.OrderedDict(), collections.OrderedDict(a=1),
+    datetime.datetime(2020, 1, 2, 3, 4, 5, 6),
+    datetime.datetime(2020, 1, 2, tzinfo=datetime.timezone.utc),
+    datetime.datetime(2020, 1, 2, 3, tzinfo=datetime.timezone(datetime.timedelta(hours=-5), 'EST')),
+    datetime.date(2020, 5, 6), datetime.time(1, 2, 3, 4),
+    datetime.timedelta(days=-1, seconds=5, microseconds=7),
+    decimal.Decimal('1.50'), decimal.Decimal('-Infinity'), fractions.Fraction(-3, 4), complex(1, -2),
+    inst(), inst(a=1, b='x'), L([1, 2]), D(a=1), L(), D(),
+]
+t = (1, 2)
+objs.append([t, t])
+s = 'shared'
+objs.append([s, s, s])
+r = []
+r.append(r)
+objs.append(r)
+d = {}
+d['self'] = d
+objs.append(d)
+o = inst()
+o.me = o
+objs.append(o)
+sl = S()
+sl.x = 1
+objs.append(sl)
+m = inst(a=1)
+objs.append([m, m])
+objs.append([[i] * 10 for i in range(50)])
+objs.append({'k%d' % i: ['v'] * 5 for i in range(200)})
+
+for i, o in enumerate(objs):
+    for p in range(6):
+        try:
+            data = pickle.dumps(o, p)
+        except TypeError:
+            continue  # protocols 0 and 1 can't pickle objects with __slots__
+        print(i, p, data.hex())
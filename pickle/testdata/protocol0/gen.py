@@ -0,0 +1,87 @@
+# -*- coding: utf-8 -*-
+# Writes the protocol 0 pickles in this directory, as CPython 2.7 and 3.x dump
+# them, and the JSON expected for each, as CPython loads it:
+#
+#	python2.7 gen.py && python3 gen.py
+#
+# Python 2 writes NAME.py2.pickle, and Python 3 writes NAME.py3.pickle and then
+# NAME.*.json for every pickle.
+from __future__ import unicode_literals
+
+import glob
+import json
+import pickle
+import re
+import sys
+
+PY2 = sys.version_info[0] == 2
+
+# objects pickled by both versions. Text is UNICODE, and on Python 2, a str is
+# STRING.
+both = {
+    'ascii': 'hello',
+    'unicode_escapes': 'tab\tnewline\nreturn\rbackslash\\ quote\' dquote" nul\0 del\x7f',
+    'unicode_latin1': 'caf\xe9 \xff',
+    'unicode_bmp': '\u20ac \u4e2d\u6587 \u2028\u2029',
+    'unicode_astral': '\U0001f600 \U00010000',
+    'unicode_looks_escaped': 'not \\u00e9 or \\x41 or \\U0001f600',
+    'nested': {'key': ['valu\xe9', 1, 2.5, None, True, False], 'k€': {'n': -7}},
+}
+
+py2 = {
+    'str_escapes': b'tab\tnewline\nreturn\rbackslash\\ quote\' dquote" nul\0 del\x7f',
+    'str_quotes': b'\'single\' and "double"',
+    'str_utf8': 'caf\xe9 € \U0001f600'.encode('utf-8'),
+    'str_invalid_utf8': b'\xff\xfe',
+    'str_high_bytes': bytes(bytearray(range(0x80, 0x100, 7))),
+}
+
+py3 = {
+    # Python 3 keeps the halves of a surrogate pair in a str as two lone
+    # surrogates, and json.dumps writes them as two \u escapes. The Unpickler
+    # joins them into the character they encode, as Python 2 does.
+    'surrogate_pair': '\ud83d\ude00',
+    'lone_surrogates': 'a\ud800b\udfffc',
+}
+
+
+def dump(objs, version):
+    for name, obj in sorted(objs.items()):
+        with open('%s.%s.pickle' % (name, version), 'wb') as f:
+            f.write(pickle.dumps(obj, 0))
+
+
+def text(obj):
+    """text converts Python 2 strs, loaded as bytes, to text as the Unpickler does"""
+    if isinstance(obj, bytes):
+        return obj.decode('utf-8', 'replace')
+    if isinstance(obj, list):
+        return [text(o) for o in obj]
+    if isinstance(obj, dict):
+        return {text(k): text(v) for k, v in obj.items()}
+    return obj
+
+
+def escape(m):
+    return '\\u%04x' % ord(m.group())
+
+
+def write_json(path):
+    with open(path, 'rb') as f:
+        obj = text(pickle.load(f, encoding='bytes'))
+    s = json.dumps(obj, ensure_ascii=False, separators=(',', ':'))
+    s = re.sub('[\ud800-\udbff][\udc00-\udfff]',
+               lambda m: m.group().encode('utf-16-le', 'surrogatepass').decode('utf-16-le'), s)
+    s = re.sub('[\u2028\u2029\ud800-\udfff]', escape, s)
+    with open(path[:-len('.pickle')] + '.json', 'w', encoding='utf-8') as f:
+        f.write(s + '\n')
+
+
+if PY2:
+    dump(both, 'py2')
+    dump(py2, 'py2')
+else:
+    dump(both, 'py3')
+    dump(py3, 'py3')
+    for path in sorted(glob.glob('*.pickle')):
+        write_json(path)
@@ -0,0 +1,466 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"unsafe"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// ToGo converts obj, which is typically the result of Unpickler.Load, to
+// plain Go values, following the same rules as JSON does:
+//
+//	str                      string
+//	int                      int64, or *big.Int if it doesn't fit
+//	float                    float64
+//	bool                     bool
+//	None                     nil
+//	bytes, bytearray         []byte
+//	list                     []any
+//	tuple                    []any, or an array (see GoOptions.Tuples)
+//	set, frozenset           []any, or map[any]struct{} (see GoOptions.Sets)
+//	dict, OrderedDict        map[string]any, map[any]any or []any (see GoOptions.Keys)
+//	datetime.datetime, date  time.Time (see types.DateTime.GoTime and types.Date.GoTime)
+//	datetime.time            time.Duration since midnight
+//	datetime.timedelta       time.Duration
+//	decimal.Decimal          *big.Rat, or float64 for NaN, the infinities and exponents beyond types.MaxRatExponent
+//	fractions.Fraction       *big.Rat
+//	complex                  complex128
+//
+// Instances of classes with no implementation of their own, and the classes
+// themselves, are converted according to GoOptions.Objects, as JSON writes
+// them. Objects of other types, such as those provided by FindClass, are
+// returned unchanged.
+//
+// A list, dict, set or instance which is referred to more than once becomes
+// one Go value referred to from each place, so an object which contains itself
+// becomes a Go value which contains itself. The bytes, *big.Int and *big.Rat
+// values share memory with obj.
+func ToGo(obj types.Object, opts GoOptions) (any, error) {
+	c := goConverter{opts: opts}
+	return c.convert(obj)
+}
+
+// GoOptions controls the conversion of objects to Go values by ToGo. The zero
+// value converts them the way JSON writes them with the zero JSONOptions.
+type GoOptions struct {
+	// Keys chooses the Go type of dicts.
+	Keys KeyPolicy
+
+	// CompositeKeys decides, as for JSON, what happens to dict keys which can't
+	// be keys of the Go map: with KeysString, keys which are neither strings nor
+	// scalars, and with KeysAny, keys whose Go value isn't comparable. With
+	// SetsMap it also applies to set elements, and CompositeKeysPairs makes such
+	// a set a []any.
+	CompositeKeys CompositeKeyPolicy
+
+	// Sets chooses the Go type of sets and frozensets.
+	Sets SetPolicy
+
+	// Tuples chooses the Go type of tuples.
+	Tuples TuplePolicy
+
+	// Objects chooses, as for JSON, how instances of classes with no
+	// implementation of their own (*types.GenericObject) and the classes
+	// themselves are converted. They become the Go values of what JSON writes.
+	Objects ObjectPolicy
+
+	// ClassKey adds a "__class__" key, as for JSON.
+	ClassKey bool
+
+	// Limits bounds the nesting of the Go values. Only its MaxDepth applies.
+	Limits Limits
+}
+
+// KeyPolicy says what Go type a dict (or OrderedDict) becomes.
+type KeyPolicy int
+
+const (
+	// KeysString makes dicts map[string]any. Scalar keys are converted to
+	// strings as JSON does: 1 becomes "1", True becomes "true" and None becomes
+	// "null".
+	KeysString KeyPolicy = iota
+	// KeysAny makes dicts map[any]any, with keys converted like any other
+	// value. Long integer keys are *big.Int, so they are compared by pointer.
+	KeysAny
+	// KeysPairs makes dicts a []any of []any{key, value} pairs, in the order of
+	// the dict, as CompositeKeysPairs writes them in JSON.
+	KeysPairs
+)
+
+// SetPolicy says what Go type a set (or frozenset) becomes.
+type SetPolicy int
+
+const (
+	// SetsSlice makes sets a []any of their elements, as JSON writes them.
+	SetsSlice SetPolicy = iota
+	// SetsMap makes sets a map[any]struct{} of their elements.
+	SetsMap
+)
+
+// TuplePolicy says what Go type a tuple becomes.
+type TuplePolicy int
+
+const (
+	// TuplesSlice makes tuples a []any, like lists, as JSON writes them.
+	TuplesSlice TuplePolicy = iota
+	// TuplesArray makes tuples an array of any, like [2]any for a pair. Unlike
+	// slices, arrays are comparable, so tuples of comparable values can be keys
+	// of a map[any]any and elements of a map[any]struct{}.
+	TuplesArray
+)
+
+// goConverter holds the state of one call to ToGo
+type goConverter struct {
+	opts  GoOptions
+	depth int                    // number of containers being converted
+	seen  map[unsafe.Pointer]any // the Go values of the objects with an identity
+}
+
+var anyType = reflect.TypeOf((*any)(nil)).Elem()
+
+func (c *goConverter) convert(obj types.Object) (any, error) {
+	switch o := obj.(type) {
+	case nil:
+		return nil, &types.UnserializableObjectError{Type: "nil"}
+	case types.String:
+		return o.String(), nil
+	case types.Int:
+		return int64(o), nil
+	case *types.Long:
+		return (*big.Int)(o), nil
+	case types.Bool:
+		return bool(o), nil
+	case types.None:
+		return nil, nil
+	case types.Float:
+		return float64(o), nil
+	case types.ByteArray:
+		return []byte(o), nil
+	case *types.Dict:
+		if v, ok := c.seen[unsafe.Pointer(o)]; ok {
+			return v, nil
+		}
+		return c.dict(*o, unsafe.Pointer(o))
+	case *types.OrderedDict:
+		if v, ok := c.seen[unsafe.Pointer(o)]; ok {
+			return v, nil
+		}
+		return c.dict(types.Dict(*o), unsafe.Pointer(o))
+	case *types.List:
+		if v, ok := c.seen[unsafe.Pointer(o)]; ok {
+			return v, nil
+		}
+		return c.list(*o, unsafe.Pointer(o))
+	case types.Tuple:
+		if c.opts.Tuples == TuplesArray {
+			return c.array(o)
+		}
+		return c.list(o, nil)
+	case *types.Set:
+		if v, ok := c.seen[unsafe.Pointer(o)]; ok {
+			return v, nil
+		}
+		return c.set(*o, unsafe.Pointer(o))
+	case types.FrozenSet:
+		return c.set(o, nil)
+	case *types.DateTime:
+		return o.GoTime(), nil
+	case *types.Date:
+		return o.GoTime(), nil
+	case *types.Time:
+		return o.SinceMidnight(), nil
+	case *types.TimeDelta:
+		return o.Duration(), nil
+	case *types.Decimal:
+		if r := o.Rat(); r != nil {
+			return r, nil
+		}
+		return o.Float64(), nil
+	case *types.Fraction:
+		return (*big.Rat)(o), nil
+	case types.Complex:
+		return complex128(o), nil
+	case *types.GenericClass:
+		switch c.opts.Objects {
+		case ObjectError:
+			return nil, &types.UnserializableObjectError{Object: o, Type: "GenericClass(" + o.String() + ")"}
+		case ObjectReduce:
+			return c.dict(types.Dict{&classKey, className(o)}, nil)
+		case ObjectNull:
+			return nil, nil
+		}
+		return o.String(), nil
+	case *types.GenericObject:
+		if v, ok := c.seen[unsafe.Pointer(o)]; ok {
+			return v, nil
+		}
+		return c.instance(o)
+	}
+	return obj, nil
+}
+
+// enter begins converting a container, which must be ended with leave
+func (c *goConverter) enter() error {
+	if limit := c.opts.Limits.MaxDepth; limit > 0 && c.depth >= limit {
+		return &LimitError{Limit: "MaxDepth", Max: int64(limit)}
+	}
+	c.depth++
+	return nil
+}
+
+func (c *goConverter) leave() {
+	c.depth--
+}
+
+// remember records v as the Go value of the object ptr, if it is one with an identity
+func (c *goConverter) remember(ptr unsafe.Pointer, v any) {
+	if ptr == nil {
+		return
+	}
+	if c.seen == nil {
+		c.seen = make(map[unsafe.Pointer]any)
+	}
+	c.seen[ptr] = v
+}
+
+// list converts items to a []any. ptr identifies the object items belong to, if it is one
+// which can be referred to more than once.
+func (c *goConverter) list(items []types.Object, ptr unsafe.Pointer) (any, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	l := make([]any, len(items))
+	c.remember(ptr, l)
+	for i, item := range items {
+		var err error
+		if l[i], err = c.convert(item); err != nil {
+			return nil, err
+		}
+	}
+	c.leave()
+	return l, nil
+}
+
+// array converts the items of a tuple to an array of any
+func (c *goConverter) array(items types.Tuple) (any, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	a := reflect.New(reflect.ArrayOf(len(items), anyType)).Elem()
+	for i, item := range items {
+		v, err := c.convert(item)
+		if err != nil {
+			return nil, err
+		}
+		a.Index(i).Set(reflect.ValueOf(&v).Elem())
+	}
+	c.leave()
+	return a.Interface(), nil
+}
+
+// set converts the elements of a set according to GoOptions.Sets. ptr is as for list.
+func (c *goConverter) set(items []types.Object, ptr unsafe.Pointer) (any, error) {
+	if c.opts.Sets != SetsMap {
+		return c.list(items, ptr)
+	}
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	elems := make([]any, 0, len(items))
+	for _, item := range items {
+		v, err := c.convert(item)
+		if err != nil {
+			return nil, err
+		}
+		if !hashable(v) {
+			switch c.opts.CompositeKeys {
+			case CompositeKeysSkip:
+				continue
+			case CompositeKeysPairs:
+				c.leave()
+				return c.list(items, ptr)
+			}
+			return nil, &UncomparableKeyError{Key: item, Value: v}
+		}
+		elems = append(elems, v)
+	}
+	s := make(map[any]struct{}, len(elems))
+	for _, v := range elems {
+		s[v] = struct{}{}
+	}
+	c.remember(ptr, s)
+	c.leave()
+	return s, nil
+}
+
+// dict converts d according to GoOptions.Keys. ptr is as for list.
+func (c *goConverter) dict(d types.Dict, ptr unsafe.Pointer) (any, error) {
+	if len(d)&1 != 0 {
+		return nil, fmt.Errorf("dict has an odd number of keys and values: %d", len(d))
+	}
+	switch c.opts.Keys {
+	case KeysPairs:
+		return c.pairs(d, ptr)
+	case KeysAny:
+		return c.anyDict(d, ptr)
+	}
+	if c.opts.CompositeKeys == CompositeKeysPairs {
+		for i := 0; i < len(d); i += 2 {
+			if !types.IsJSONKey(d[i]) {
+				return c.pairs(d, ptr)
+			}
+		}
+	}
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	m := make(map[string]any, len(d)/2)
+	c.remember(ptr, m)
+	for i := 0; i < len(d); i += 2 {
+		key, ok := keyString(d[i])
+		if !ok {
+			if c.opts.CompositeKeys == CompositeKeysSkip {
+				continue
+			}
+			return nil, &UnsupportedKeyError{Key: d[i]}
+		}
+		v, err := c.convert(d[i+1])
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	c.leave()
+	return m, nil
+}
+
+// anyDict converts d to a map[any]any
+func (c *goConverter) anyDict(d types.Dict, ptr unsafe.Pointer) (any, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	// the keys are converted first, so that the dict can become pairs if one can't be a map key
+	keys := make([]any, len(d)/2)
+	for i := 0; i < len(d); i += 2 {
+		k, err := c.convert(d[i])
+		if err != nil {
+			return nil, err
+		}
+		if !hashable(k) {
+			switch c.opts.CompositeKeys {
+			case CompositeKeysSkip:
+				keys[i/2] = skippedKey{}
+				continue
+			case CompositeKeysPairs:
+				c.leave()
+				return c.pairs(d, ptr)
+			}
+			return nil, &UncomparableKeyError{Key: d[i], Value: k}
+		}
+		keys[i/2] = k
+	}
+	m := make(map[any]any, len(keys))
+	c.remember(ptr, m)
+	for i, k := range keys {
+		if _, skip := k.(skippedKey); skip {
+			continue
+		}
+		v, err := c.convert(d[2*i+1])
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	c.leave()
+	return m, nil
+}
+
+// skippedKey marks a key which CompositeKeysSkip leaves out
+type skippedKey struct{}
+
+// pairs converts d to a []any of []any{key, value} pairs
+func (c *goConverter) pairs(d types.Dict, ptr unsafe.Pointer) (any, error) {
+	if err := c.enter(); err != nil {
+		return nil, err
+	}
+	l := make([]any, len(d)/2)
+	c.remember(ptr, l)
+	for i := 0; i < len(d); i += 2 {
+		pair, err := c.list(d[i:i+2], nil)
+		if err != nil {
+			return nil, err
+		}
+		l[i/2] = pair
+	}
+	c.leave()
+	return l, nil
+}
+
+// instance converts an instance of a GenericClass according to GoOptions.Objects
+func (c *goConverter) instance(o *types.GenericObject) (any, error) {
+	ptr := unsafe.Pointer(o)
+	switch c.opts.Objects {
+	case ObjectError:
+		return nil, &types.UnserializableObjectError{Object: o, Type: "GenericObject(" + o.Class.String() + ")"}
+	case ObjectReduce:
+		return c.dict(reduceFields(o), ptr)
+	case ObjectNull:
+		return nil, nil
+	}
+	switch {
+	case len(o.ListItems) != 0:
+		return c.list(o.ListItems, ptr)
+	case len(o.DictItems) != 0:
+		return c.dict(o.DictItems, ptr)
+	}
+	return c.dict(instanceAttributes(o, c.opts.ClassKey), ptr)
+}
+
+// keyString returns a dict key as the string JSON writes for it, or false if it is not
+// a string or scalar
+func keyString(key types.Object) (string, bool) {
+	switch k := key.(type) {
+	case types.String:
+		return k.String(), true
+	case types.Int:
+		return strconv.FormatInt(int64(k), 10), true
+	case *types.Long:
+		return (*big.Int)(k).String(), true
+	case types.Bool:
+		return strconv.FormatBool(bool(k)), true
+	case types.None:
+		return "null", true
+	case types.Float:
+		return string(k.AppendJSON(nil)), true
+	}
+	return "", false
+}
+
+// hashable returns whether v can be a key of a map[any]any. Arrays are only if their
+// elements are.
+func hashable(v any) bool {
+	if v == nil {
+		return true
+	}
+	t := reflect.TypeOf(v)
+	if !t.Comparable() {
+		return false
+	}
+	if t.Kind() == reflect.Array {
+		a := reflect.ValueOf(v)
+		for i := 0; i < a.Len(); i++ {
+			if !hashable(a.Index(i).Interface()) {
+				return false
+			}
+		}
+	}
+	return true
+}
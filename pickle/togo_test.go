@@ -0,0 +1,172 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// CPython's protocol 2 pickles of the objects in the comments. mod.C is an ordinary class,
+// and mod.L a subclass of list.
+const (
+	goDateTime  = "\x80\x02cdatetime\ndatetime\nq\x00c_codecs\nencode\nq\x01X\x0b\x00\x00\x00\x07\xc3\xa6\x01\x02\x03\x04\x05\x00\x00\x06q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07." // datetime.datetime(2022, 1, 2, 3, 4, 5, 6)
+	goDate      = "\x80\x02cdatetime\ndate\nq\x00c_codecs\nencode\nq\x01X\x05\x00\x00\x00\x07\xc3\xa6\x01\x02q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07."                             // datetime.date(2022, 1, 2)
+	goTime      = "\x80\x02cdatetime\ntime\nq\x00c_codecs\nencode\nq\x01X\x06\x00\x00\x00\x01\x02\x03\x00\x00\x04q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07."                         // datetime.time(1, 2, 3, 4)
+	goTimeDelta = "\x80\x02cdatetime\ntimedelta\nq\x00J\xff\xff\xff\xffK\x05K\x07\x87q\x01Rq\x02."                                                                                                         // datetime.timedelta(days=-1, seconds=5, microseconds=7)
+	goBytes     = "\x80\x02c_codecs\nencode\nq\x00X\x02\x00\x00\x00abq\x01X\x06\x00\x00\x00latin1q\x02\x86q\x03Rq\x04."                                                                                    // b'ab'
+	goSet       = "\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03."                                                                                                                     // {1, 2}
+	goTupleSet  = "\x80\x02c__builtin__\nset\nq\x00]q\x01K\x01K\x02\x86q\x02a\x85q\x03Rq\x04."                                                                                                             // {(1, 2)}
+	goFrozenSet = "\x80\x02c__builtin__\nfrozenset\nq\x00]q\x01K\x03a\x85q\x02Rq\x03."                                                                                                                     // frozenset([3])
+	goTuple     = "\x80\x02K\x01X\x01\x00\x00\x00aq\x00\x86q\x01."                                                                                                                                         // (1, 'a')
+	goScalars   = "\x80\x02}q\x00(K\x01X\x01\x00\x00\x00aq\x01NK\x02G?\xf8\x00\x00\x00\x00\x00\x00K\x03X\x01\x00\x00\x00xq\x02K\x04u."                                                                     // {1: 'a', None: 2, 1.5: 3, 'x': 4}
+	goLongKey   = "\x80\x02}q\x00\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@K\x01s."                                                                                                                         // {2**70: 1}
+	goComposite = "\x80\x02}q\x00(K\x01K\x02\x86q\x01X\x01\x00\x00\x00aq\x02X\x01\x00\x00\x00bq\x03K\x01u."                                                                                                // {(1, 2): 'a', 'b': 1}
+	goOrdered   = "\x80\x02ccollections\nOrderedDict\nq\x00)Rq\x01(X\x01\x00\x00\x00bq\x02K\x01X\x01\x00\x00\x00aq\x03K\x02u."                                                                             // OrderedDict(b=1, a=2)
+	goDecimal   = "\x80\x02cdecimal\nDecimal\nq\x00X\x04\x00\x00\x001.25q\x01\x85q\x02Rq\x03."                                                                                                             // decimal.Decimal('1.25')
+	goNegInf    = "\x80\x02cdecimal\nDecimal\nq\x00X\x09\x00\x00\x00-Infinityq\x01\x85q\x02Rq\x03."                                                                                                        // decimal.Decimal('-Infinity')
+	goHuge      = "\x80\x02cdecimal\nDecimal\nq\x00X\x08\x00\x00\x001E+20000q\x01\x85q\x02Rq\x03."                                                                                                         // decimal.Decimal('1E+20000')
+	goFraction  = "\x80\x02cfractions\nFraction\nq\x00J\xfd\xff\xff\xffK\x04\x86q\x01Rq\x02."                                                                                                              // fractions.Fraction(-3, 4)
+	goComplex   = "\x80\x02c__builtin__\ncomplex\nq\x00G?\xf0\x00\x00\x00\x00\x00\x00G\xc0\x00\x00\x00\x00\x00\x00\x00\x86q\x01Rq\x02."                                                                    // complex(1, -2)
+	goInstance  = "\x80\x02cmod\nC\nq\x00)\x81q\x01}q\x02X\x01\x00\x00\x00aq\x03K\x01sb."                                                                                                                  // mod.C() with a=1
+	goClass     = "\x80\x02cmod\nC\nq\x00."                                                                                                                                                                // mod.C
+	goListSub   = "\x80\x02cmod\nL\nq\x00)\x81q\x01(K\x01K\x02e."                                                                                                                                          // mod.L([1, 2])
+	goNested    = "\x80\x02]q\x00]q\x01]q\x02K\x01aaa."                                                                                                                                                    // [[[1]]]
+	goLong      = "\x80\x02\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@."                                                                                                                                     // 2**70
+)
+
+// mustLoad loads the pickle p, allowing unknown classes
+func mustLoad(t *testing.T, p string) types.Object {
+	t.Helper()
+	u := NewUnpickler([]byte(p))
+	u.AllowUnknownClasses = true
+	obj, err := u.Load()
+	if err != nil {
+		t.Fatalf("Load(%q): %v", p, err)
+	}
+	return obj
+}
+
+func TestToGo(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts GoOptions
+		want any
+		err  string
+	}{
+		{goDateTime, GoOptions{}, time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC), ""},
+		{goDate, GoOptions{}, time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC), ""},
+		{goTime, GoOptions{}, time.Hour + 2*time.Minute + 3*time.Second + 4*time.Microsecond, ""},
+		{goTimeDelta, GoOptions{}, -24*time.Hour + 5*time.Second + 7*time.Microsecond, ""},
+		{goBytes, GoOptions{}, []byte("ab"), ""},
+		{goLong, GoOptions{}, new(big.Int).Lsh(big.NewInt(1), 70), ""},
+		{goDecimal, GoOptions{}, big.NewRat(5, 4), ""},
+		{goNegInf, GoOptions{}, math.Inf(-1), ""},
+		{goHuge, GoOptions{}, math.Inf(1), ""},
+		{goFraction, GoOptions{}, big.NewRat(-3, 4), ""},
+		{goComplex, GoOptions{}, complex(1, -2), ""},
+
+		// Sets
+		{goSet, GoOptions{}, []any{int64(1), int64(2)}, ""},
+		{goSet, GoOptions{Sets: SetsMap}, map[any]struct{}{int64(1): {}, int64(2): {}}, ""},
+		{goFrozenSet, GoOptions{}, []any{int64(3)}, ""},
+		{goFrozenSet, GoOptions{Sets: SetsMap}, map[any]struct{}{int64(3): {}}, ""},
+		{goTupleSet, GoOptions{Sets: SetsMap}, nil, "key of type types.Tuple converts to []interface {}, which can't be a Go map key"},
+		{goTupleSet, GoOptions{Sets: SetsMap, CompositeKeys: CompositeKeysSkip}, map[any]struct{}{}, ""},
+		{goTupleSet, GoOptions{Sets: SetsMap, CompositeKeys: CompositeKeysPairs}, []any{[]any{int64(1), int64(2)}}, ""},
+		{goTupleSet, GoOptions{Sets: SetsMap, Tuples: TuplesArray}, map[any]struct{}{[2]any{int64(1), int64(2)}: {}}, ""},
+
+		// Tuples
+		{goTuple, GoOptions{}, []any{int64(1), "a"}, ""},
+		{goTuple, GoOptions{Tuples: TuplesArray}, [2]any{int64(1), "a"}, ""},
+
+		// Keys and CompositeKeys
+		{goScalars, GoOptions{}, map[string]any{"1": "a", "null": int64(2), "1.5": int64(3), "x": int64(4)}, ""},
+		{goScalars, GoOptions{Keys: KeysAny}, map[any]any{int64(1): "a", nil: int64(2), 1.5: int64(3), "x": int64(4)}, ""},
+		{goScalars, GoOptions{Keys: KeysPairs}, []any{
+			[]any{int64(1), "a"}, []any{nil, int64(2)}, []any{1.5, int64(3)}, []any{"x", int64(4)}}, ""},
+		{goLongKey, GoOptions{}, map[string]any{"1180591620717411303424": int64(1)}, ""},
+		{goComposite, GoOptions{}, nil, "dict key of type types.Tuple can't be a JSON object key"},
+		{goComposite, GoOptions{CompositeKeys: CompositeKeysSkip}, map[string]any{"b": int64(1)}, ""},
+		{goComposite, GoOptions{CompositeKeys: CompositeKeysPairs}, []any{
+			[]any{[]any{int64(1), int64(2)}, "a"}, []any{"b", int64(1)}}, ""},
+		{goComposite, GoOptions{Keys: KeysAny}, nil, "key of type types.Tuple converts to []interface {}, which can't be a Go map key"},
+		{goComposite, GoOptions{Keys: KeysAny, CompositeKeys: CompositeKeysSkip}, map[any]any{"b": int64(1)}, ""},
+		{goComposite, GoOptions{Keys: KeysAny, CompositeKeys: CompositeKeysPairs}, []any{
+			[]any{[]any{int64(1), int64(2)}, "a"}, []any{"b", int64(1)}}, ""},
+		{goComposite, GoOptions{Keys: KeysAny, Tuples: TuplesArray}, map[any]any{[2]any{int64(1), int64(2)}: "a", "b": int64(1)}, ""},
+		{goComposite, GoOptions{Keys: KeysPairs}, []any{
+			[]any{[]any{int64(1), int64(2)}, "a"}, []any{"b", int64(1)}}, ""},
+		{goOrdered, GoOptions{}, map[string]any{"b": int64(1), "a": int64(2)}, ""},
+		{goOrdered, GoOptions{Keys: KeysPairs}, []any{[]any{"b", int64(1)}, []any{"a", int64(2)}}, ""},
+
+		// Objects and ClassKey
+		{goInstance, GoOptions{}, map[string]any{"a": int64(1)}, ""},
+		{goInstance, GoOptions{ClassKey: true}, map[string]any{"__class__": "mod.C", "a": int64(1)}, ""},
+		{goInstance, GoOptions{Objects: ObjectReduce}, map[string]any{
+			"__class__": "mod.C", "args": []any{}, "state": map[string]any{"a": int64(1)}}, ""},
+		{goInstance, GoOptions{Objects: ObjectNull}, nil, ""},
+		{goInstance, GoOptions{Objects: ObjectError}, nil, "can't serialize GenericObject(mod.C) to JSON"},
+		{goClass, GoOptions{}, "mod.C", ""},
+		{goClass, GoOptions{Objects: ObjectReduce}, map[string]any{"__class__": "mod.C"}, ""},
+		{goClass, GoOptions{Objects: ObjectNull}, nil, ""},
+		{goClass, GoOptions{Objects: ObjectError}, nil, "can't serialize GenericClass(mod.C) to JSON"},
+		{goListSub, GoOptions{}, []any{int64(1), int64(2)}, ""},
+		{goListSub, GoOptions{Objects: ObjectReduce}, map[string]any{
+			"__class__": "mod.L", "args": []any{}, "state": nil, "items": []any{int64(1), int64(2)}}, ""},
+
+		// Limits
+		{goNested, GoOptions{Limits: Limits{MaxDepth: 3}}, []any{[]any{[]any{int64(1)}}}, ""},
+		{goNested, GoOptions{Limits: Limits{MaxDepth: 2}}, nil, "exceeded Limits.MaxDepth of 2"},
+	}
+	for _, test := range tests {
+		got, err := ToGo(mustLoad(t, test.in), test.opts)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("ToGo(%q, %+v) error %v, want %q", test.in, test.opts, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ToGo(%q, %+v): %v", test.in, test.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ToGo(%q, %+v) = %#v, want %#v", test.in, test.opts, got, test.want)
+		}
+	}
+}
+
+// TestToGoShared checks that objects referred to more than once become one Go value.
+func TestToGoShared(t *testing.T) {
+	// l = [1]; [l, l]
+	v, err := ToGo(mustLoad(t, "\x80\x02]q\x00(]q\x01K\x01ah\x01e."), GoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := v.([]any)
+	if &l[0].([]any)[0] != &l[1].([]any)[0] {
+		t.Errorf("ToGo([l, l]) = %v, with two copies of l", l)
+	}
+
+	// r = []; r.append(r)
+	v, err = ToGo(mustLoad(t, "\x80\x02]q\x00h\x00a."), GoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := v.([]any)
+	if &r[0].([]any)[0] != &r[0] {
+		t.Errorf("ToGo(r) isn't a slice which contains itself")
+	}
+
+	if _, err := ToGo(nil, GoOptions{}); err == nil {
+		t.Errorf("ToGo(nil) succeeded")
+	}
+}
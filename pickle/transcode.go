@@ -0,0 +1,660 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// Transcode decodes the pickle and writes it to enc as JSON. The result, errors included, is
+// the same as calling Load and then enc.Encode.
+//
+// Pickles made only of strings, bytes, numbers, lists, tuples, sets and dicts are transcoded
+// in a single pass, writing JSON text as the opcodes are executed instead of building objects
+// and then encoding them. Anything else (classes, REDUCE and BUILD, containers fetched from
+// the memo, a FindClass type, an error) makes Transcode start over with Load.
+//
+// Transcode keeps its working buffers in enc, so reusing one JSONEncoder for many pickles
+// avoids almost all allocation.
+//
+// The JSON is limited by the tighter of the MaxDepth and MaxOutput of u.Limits and of enc's
+// JSONOptions.Limits. Exceeding them fails with a *DecodeError at the offset of the pickle's
+// STOP opcode, wrapping the *LimitError.
+//
+// With JSONOptions.Canonical, which needs whole dicts and sets to sort them, and with
+// PreserveSharing or CyclesRef, which escape some dict keys, Transcode always uses Load.
+func (u *Unpickler) Transcode(enc *JSONEncoder) error {
+	limits := u.jsonLimits(enc)
+	if u.r == nil && !enc.opts.Canonical && !enc.opts.PreserveSharing && enc.opts.Cycles != CyclesRef {
+		if enc.tc == nil {
+			enc.tc = &transcoder{enc: enc}
+		}
+		t := enc.tc
+		in, frame, proto := u.in, u.currentFrame, u.proto
+		ok := t.run(u, &limits)
+		u.stack = nil
+		u.sram = nil
+		if ok {
+			enc.start(limits)
+			err := u.stopError(t.write())
+			t.reset()
+			u.in = nil
+			u.currentFrame = nil
+			u.proto = 0
+			return err
+		}
+		t.reset()
+		// start over the slow way
+		u.in, u.currentFrame, u.proto = in, frame, proto
+	}
+
+	obj, err := u.Load()
+	if err != nil {
+		return err
+	}
+	return u.stopError(enc.encodeWithin(obj, limits))
+}
+
+// TranscodeNext is to Transcode what Next is to Load: it decodes the next of a sequence of
+// pickles written one after another, and writes it to enc as JSON. It returns io.EOF when the
+// input ends where another pickle would begin. Its errors are those of Transcode, with the
+// offsets of the opcodes in the whole input. It always decodes the pickle with Next first.
+func (u *Unpickler) TranscodeNext(enc *JSONEncoder) error {
+	limits := u.jsonLimits(enc)
+	obj, err := u.Next()
+	if err != nil {
+		return err
+	}
+	return u.stopError(enc.encodeWithin(obj, limits))
+}
+
+// jsonLimits returns the limits of the JSON written to enc, the tighter of u's and enc's
+func (u *Unpickler) jsonLimits(enc *JSONEncoder) Limits {
+	limits := u.Limits
+	limits.MaxDepth = int(tighter(int64(limits.MaxDepth), int64(enc.opts.Limits.MaxDepth)))
+	limits.MaxOutput = tighter(limits.MaxOutput, enc.opts.Limits.MaxOutput)
+	return limits
+}
+
+// transcoder holds the state of the single pass pickle to JSON conversion done by
+// Unpickler.Transcode.
+//
+// The JSON text of every scalar is appended to out as soon as its opcode is executed. A
+// container's own text is known only later, once it is clear which values end up in it, so
+// each value and MARK is preceded in out by a slot of slotSize bytes, which start out zero and
+// are filled in with the container's brackets, commas, colons and key quotes as they become
+// known. Empty slot bytes are dropped when the text is written out. The text of a scalar never
+// contains bytes below 0x20, so slot bytes can't be confused with it.
+type transcoder struct {
+	enc     *JSONEncoder
+	out     []byte
+	stack   []tcValue
+	marks   []tcMark
+	memo    []tcMemo
+	memoN   int             // number of memo entries in use, which is the index MEMOIZE uses
+	objects int             // number of values pushed, as Limits.MaxObjects counts them
+	obj     [1]types.Object // stack for the Unpickler's own opcode functions
+}
+
+const (
+	slotCloser = iota // closing bracket of the previous container, or closing quote of the previous key
+	slotSep           // ',' or ':'
+	slotTuples        // number of tuples which begin with this value, each of which needs a '['
+	slotOpen          // '[' or '{' of a container built from a MARK, or the opening quote of a key
+	slotSize
+
+	maxSlotTuples = ' ' - 1
+)
+
+type tcKind byte
+
+const (
+	tcString    tcKind = iota // a string, which can be a dict key
+	tcQuotable                // an int, bool or None, which can be a dict key once quoted
+	tcScalar                  // a float or bytes
+	tcComposite               // a tuple or frozenset, which is complete
+	tcList                    // a list, which can still be appended to
+	tcSet                     // a set, which can still be added to
+	tcDict                    // a dict, which can still be added to
+)
+
+// tcValue is an item on the transcoder's stack
+type tcValue struct {
+	slot  int // offset in out of the value's slot. The value's text follows it
+	kind  tcKind
+	n     int // number of items in an open container, counting dict keys and values separately
+	depth int // nesting depth of the containers in the value, as Limits.MaxDepth counts it
+}
+
+type tcMark struct {
+	depth int // length of the stack at the MARK
+	slot  int
+}
+
+type tcMemo struct {
+	start, end int // text of a scalar in out
+	kind       tcKind
+	set        bool
+}
+
+func (t *transcoder) reset() {
+	t.out = t.out[:0]
+	t.stack = t.stack[:0]
+	t.marks = t.marks[:0]
+	t.memo = t.memo[:0]
+	t.memoN = 0
+	t.objects = 0
+	t.obj[0] = nil
+}
+
+// run executes the pickle. It returns false if the pickle can't be transcoded in one pass,
+// or comes close to exceeding limits, in which case Load and Encode decide what to do.
+func (t *transcoder) run(u *Unpickler, limits *Limits) bool {
+	limited := *limits != Limits{}
+	for {
+		inFrame := len(u.currentFrame) != 0
+		op, err := u.readOne()
+		if err != nil {
+			return false
+		}
+		if u.Strict && !u.validOpcode(op) {
+			return false
+		}
+
+		switch op {
+		case '\x80': // PROTO
+			err = loadProto(u)
+		case '\x95': // FRAME
+			err = loadFrame(u)
+		case '.': // STOP
+			if len(t.marks) != 0 || len(t.stack) != 1 {
+				return false
+			}
+			t.finish(t.stack)
+			u.stopOffset, u.stopInFrame = u.pos()-1, inFrame
+			return true
+
+		case 'N': // NONE
+			t.push(tcQuotable)
+			t.out = append(t.out, "null"...)
+		case '\x88': // NEWTRUE
+			t.push(tcQuotable)
+			t.out = append(t.out, "true"...)
+		case '\x89': // NEWFALSE
+			t.push(tcQuotable)
+			t.out = append(t.out, "false"...)
+		case 'J': // BININT
+			var buf []byte
+			if buf, err = u.read(4); err == nil {
+				t.push(tcQuotable)
+				t.out = strconv.AppendInt(t.out, int64(decodeInt32(buf)), 10)
+			}
+		case 'K': // BININT1
+			var i byte
+			if i, err = u.readOne(); err == nil {
+				t.push(tcQuotable)
+				t.out = strconv.AppendInt(t.out, int64(i), 10)
+			}
+		case 'M': // BININT2
+			var buf []byte
+			if buf, err = u.read(2); err == nil {
+				t.push(tcQuotable)
+				t.out = strconv.AppendInt(t.out, int64(binary.LittleEndian.Uint16(buf)), 10)
+			}
+		case '\x8a': // LONG1
+			var data []byte
+			if data, err = readLong1Arg(u); err == nil {
+				t.push(tcQuotable)
+				if len(data) <= 8 {
+					var i int64
+					if len(data) != 0 {
+						i = decodeSmallLong(data)
+					}
+					t.out = strconv.AppendInt(t.out, i, 10)
+				} else {
+					t.out = (*big.Int)(decodeLong(data).(*types.Long)).Append(t.out, 10)
+				}
+			}
+		case 'G': // BINFLOAT
+			var buf []byte
+			if buf, err = u.read(8); err == nil {
+				t.push(tcScalar)
+				t.out, err = t.enc.appendFloat(t.out, types.Float(math.Float64frombits(binary.BigEndian.Uint64(buf))))
+			}
+		case 'I', 'L', '\x8b', 'F', 'S', 'V': // INT, LONG, LONG4, FLOAT, STRING, UNICODE
+			if !t.load(u, op) {
+				return false
+			}
+
+		case 'T': // BINSTRING
+			var data []byte
+			if data, err = readBinStringArg(u); err == nil {
+				err = t.pushPy2String(u, data)
+			}
+		case 'U': // SHORT_BINSTRING
+			var data []byte
+			if data, err = readShortBinStringArg(u); err == nil {
+				err = t.pushPy2String(u, data)
+			}
+		case 'X': // BINUNICODE
+			err = t.pushString(readBinUnicodeArg(u))
+		case '\x8c': // SHORT_BINUNICODE
+			err = t.pushString(readShortBinUnicodeArg(u))
+		case '\x8d': // BINUNICODE8
+			err = t.pushString(readBinUnicode8Arg(u))
+		case 'B': // BINBYTES
+			err = t.pushBytes(readBinBytesArg(u))
+		case 'C': // SHORT_BINBYTES
+			err = t.pushBytes(readShortBinBytesArg(u))
+		case '\x8e': // BINBYTES8
+			err = t.pushBytes(readBinBytes8Arg(u))
+		case '\x96': // BYTEARRAY8
+			err = t.pushBytes(readByteArray8Arg(u))
+
+		case '(': // MARK
+			t.marks = append(t.marks, tcMark{depth: len(t.stack), slot: len(t.out)})
+			t.out = append(t.out, 0, 0, 0, 0)
+		case ')': // EMPTY_TUPLE
+			t.push(tcComposite)
+			t.out = append(t.out, "[]"...)
+		case ']': // EMPTY_LIST
+			t.push(tcList)
+			t.out = append(t.out, '[')
+		case '}': // EMPTY_DICT
+			t.push(tcDict)
+			t.out = append(t.out, '{')
+		case '\x8f': // EMPTY_SET
+			t.push(tcSet)
+			t.out = append(t.out, '[')
+		case '\x85': // TUPLE1
+			if !t.tupleN(1) {
+				return false
+			}
+		case '\x86': // TUPLE2
+			if !t.tupleN(2) {
+				return false
+			}
+		case '\x87': // TUPLE3
+			if !t.tupleN(3) {
+				return false
+			}
+		case 't', '\x91', 'l': // TUPLE, FROZENSET, LIST
+			if len(t.marks) == 0 {
+				return false
+			}
+			m := t.popMark()
+			items := t.stack[m.depth:]
+			t.finish(items)
+			t.join(items, 0)
+			t.out[m.slot+slotOpen] = '['
+			v := tcValue{slot: m.slot, kind: tcComposite, depth: 1 + maxDepth(items)}
+			if op == 'l' {
+				v.kind, v.n = tcList, len(items)
+			} else {
+				t.out = append(t.out, ']')
+			}
+			t.stack = append(t.stack[:m.depth], v)
+			t.objects++
+		case 'd': // DICT
+			if len(t.marks) == 0 {
+				return false
+			}
+			m := t.popMark()
+			items := t.stack[m.depth:]
+			if len(items)&1 != 0 || !t.pairs(items, 0) {
+				return false
+			}
+			t.finish(items)
+			t.out[m.slot+slotOpen] = '{'
+			t.stack = append(t.stack[:m.depth], tcValue{slot: m.slot, kind: tcDict, n: len(items), depth: 1 + maxDepth(items)})
+			t.objects++
+		case 'a': // APPEND
+			if !t.addItems(tcList, len(t.stack)-1) {
+				return false
+			}
+		case 's': // SETITEM
+			if !t.addItems(tcDict, len(t.stack)-2) {
+				return false
+			}
+		case 'e', 'u', '\x90': // APPENDS, SETITEMS, ADDITEMS
+			if len(t.marks) == 0 {
+				return false
+			}
+			kind := tcList
+			if op == 'u' {
+				kind = tcDict
+			} else if op == '\x90' {
+				kind = tcSet
+			}
+			if !t.addItems(kind, t.popMark().depth) {
+				return false
+			}
+
+		case 'p': // PUT
+			var line string
+			if line, err = u.unsafeReadLine(); err == nil {
+				var i uint64
+				if i, err = strconv.ParseUint(line, 10, 32); err == nil && !t.put(uint32(i)) {
+					return false
+				}
+			}
+		case 'q': // BINPUT
+			var i byte
+			if i, err = u.readOne(); err == nil && !t.put(uint32(i)) {
+				return false
+			}
+		case 'r': // LONG_BINPUT
+			var buf []byte
+			if buf, err = u.read(4); err == nil && !t.put(binary.LittleEndian.Uint32(buf)) {
+				return false
+			}
+		case '\x94': // MEMOIZE
+			if !t.put(uint32(t.memoN)) {
+				return false
+			}
+		case 'g': // GET
+			var line string
+			if line, err = u.unsafeReadLine(); err == nil {
+				var i uint64
+				if i, err = strconv.ParseUint(line, 10, 32); err == nil && !t.get(uint32(i)) {
+					return false
+				}
+			}
+		case 'h': // BINGET
+			var i byte
+			if i, err = u.readOne(); err == nil && !t.get(uint32(i)) {
+				return false
+			}
+		case 'j': // LONG_BINGET
+			var buf []byte
+			if buf, err = u.read(4); err == nil && !t.get(binary.LittleEndian.Uint32(buf)) {
+				return false
+			}
+
+		default:
+			// opcodes which need objects, and those which don't exist
+			return false
+		}
+		if err != nil {
+			return false
+		}
+		if limited && t.exceeds(limits) {
+			return false
+		}
+	}
+}
+
+// exceeds returns true if the pickle has exceeded the limits, or its text might have
+func (t *transcoder) exceeds(limits *Limits) bool {
+	return (limits.MaxObjects > 0 && t.objects > limits.MaxObjects) ||
+		(limits.MaxMemo > 0 && t.memoN > limits.MaxMemo) ||
+		(limits.MaxMarks > 0 && len(t.marks) > limits.MaxMarks) ||
+		(limits.MaxDepth > 0 && len(t.stack) != 0 && t.stack[len(t.stack)-1].depth > limits.MaxDepth) ||
+		// out is longer than the text, by the slots
+		(limits.MaxOutput > 0 && int64(len(t.out)) > limits.MaxOutput)
+}
+
+// push starts a new value on the stack
+func (t *transcoder) push(kind tcKind) {
+	v := tcValue{slot: len(t.out), kind: kind}
+	if kind >= tcComposite {
+		v.depth = 1
+	}
+	t.stack = append(t.stack, v)
+	t.out = append(t.out, 0, 0, 0, 0)
+	t.objects++
+}
+
+func (t *transcoder) pushString(s []byte, err error) error {
+	if err == nil {
+		t.push(tcString)
+		t.out = types.AppendJSONString(t.out, s)
+	}
+	return err
+}
+
+// pushPy2String pushes a Python 2 str, as Unpickler.Py2StringEncoding says
+func (t *transcoder) pushPy2String(u *Unpickler, s []byte) error {
+	if u.Py2StringEncoding == Py2StringBytes {
+		return t.pushBytes(s, nil)
+	}
+	return t.pushString(u.decodePy2String(s))
+}
+
+func (t *transcoder) pushBytes(a []byte, err error) error {
+	if err == nil {
+		t.push(tcScalar)
+		t.out = t.enc.appendBytes(t.out, a)
+	}
+	return err
+}
+
+// load pushes a scalar using the Unpickler's own function for opcode op
+func (t *transcoder) load(u *Unpickler, op byte) bool {
+	u.stack = t.obj[:0]
+	err := dispatch[op](u)
+	obj := t.obj[0]
+	t.obj[0] = nil
+	if err != nil {
+		return false
+	}
+	switch o := obj.(type) {
+	case *types.SimpleString:
+		t.pushString(*o, nil)
+	case *types.EscapedString:
+		t.pushString(*o, nil)
+	case types.ByteArray:
+		t.pushBytes(o, nil)
+	case types.Int:
+		t.push(tcQuotable)
+		t.out = strconv.AppendInt(t.out, int64(o), 10)
+	case *types.Long:
+		t.push(tcQuotable)
+		t.out = (*big.Int)(o).Append(t.out, 10)
+	case types.Bool:
+		t.push(tcQuotable)
+		t.out = strconv.AppendBool(t.out, bool(o))
+	case types.Float:
+		t.push(tcScalar)
+		t.out, err = t.enc.appendFloat(t.out, o)
+		return err == nil
+	default:
+		return false
+	}
+	return true
+}
+
+// base returns the index of the first value on the stack above the topmost MARK
+func (t *transcoder) base() int {
+	if len(t.marks) == 0 {
+		return 0
+	}
+	return t.marks[len(t.marks)-1].depth
+}
+
+func (t *transcoder) popMark() tcMark {
+	m := t.marks[len(t.marks)-1]
+	t.marks = t.marks[:len(t.marks)-1]
+	return m
+}
+
+// finish writes the closing brackets of any open containers in items, which have become
+// part of another container
+func (t *transcoder) finish(items []tcValue) {
+	for i, v := range items {
+		var c byte
+		switch v.kind {
+		case tcList, tcSet:
+			c = ']'
+		case tcDict:
+			c = '}'
+		default:
+			continue
+		}
+		if i+1 < len(items) {
+			t.out[items[i+1].slot+slotCloser] = c
+		} else {
+			t.out = append(t.out, c)
+		}
+	}
+}
+
+// join separates items which follow n items of a list
+func (t *transcoder) join(items []tcValue, n int) {
+	for i, v := range items {
+		if i != 0 || n != 0 {
+			t.out[v.slot+slotSep] = ','
+		}
+	}
+}
+
+// pairs separates the keys and values in items, which follow n items of a dict. It returns
+// false if a key can't be a JSON object key.
+func (t *transcoder) pairs(items []tcValue, n int) bool {
+	for i := 0; i < len(items); i += 2 {
+		k, v := items[i], items[i+1]
+		switch k.kind {
+		case tcString:
+		case tcQuotable:
+			t.out[k.slot+slotOpen] = '"'
+			t.out[v.slot+slotCloser] = '"'
+		default:
+			return false
+		}
+		if i != 0 || n != 0 {
+			t.out[k.slot+slotSep] = ','
+		}
+		t.out[v.slot+slotSep] = ':'
+	}
+	return true
+}
+
+// tupleN makes a tuple of the top n values
+func (t *transcoder) tupleN(n int) bool {
+	first := len(t.stack) - n
+	if first < t.base() {
+		return false
+	}
+	items := t.stack[first:]
+	t.finish(items)
+	t.join(items, 0)
+	slot := items[0].slot
+	if t.out[slot+slotTuples] == maxSlotTuples {
+		return false
+	}
+	t.out[slot+slotTuples]++
+	t.out = append(t.out, ']')
+	t.stack = append(t.stack[:first], tcValue{slot: slot, kind: tcComposite, depth: 1 + maxDepth(items)})
+	t.objects++
+	return true
+}
+
+// maxDepth returns the greatest depth of items
+func maxDepth(items []tcValue) int {
+	d := 0
+	for _, v := range items {
+		if v.depth > d {
+			d = v.depth
+		}
+	}
+	return d
+}
+
+// addItems adds the values from first up to the top of the stack to the container of the
+// given kind just below them
+func (t *transcoder) addItems(kind tcKind, first int) bool {
+	if first-1 < t.base() {
+		return false
+	}
+	target := &t.stack[first-1]
+	items := t.stack[first:]
+	if target.kind != kind {
+		return false
+	}
+	if kind == tcDict {
+		if len(items)&1 != 0 || !t.pairs(items, target.n) {
+			return false
+		}
+	} else {
+		t.join(items, target.n)
+	}
+	t.finish(items)
+	target.n += len(items)
+	if d := 1 + maxDepth(items); d > target.depth {
+		target.depth = d
+	}
+	t.stack = t.stack[:first]
+	return true
+}
+
+// put memoizes the value on top of the stack. Containers are memoized so that they can be
+// counted, but can't be fetched.
+func (t *transcoder) put(i uint32) bool {
+	if len(t.stack) == t.base() {
+		return false
+	}
+	if int64(i) >= int64(len(t.memo)) {
+		if int64(i) > int64(len(t.memo))+1024 {
+			// a sparse memo isn't worth the memory
+			return false
+		}
+		for len(t.memo) <= int(i) {
+			t.memo = append(t.memo, tcMemo{})
+		}
+	}
+	if !t.memo[i].set {
+		t.memoN++
+	}
+	v := t.stack[len(t.stack)-1]
+	t.memo[i] = tcMemo{start: v.slot + slotSize, end: len(t.out), kind: v.kind, set: true}
+	return true
+}
+
+// get pushes a copy of a memoized scalar
+func (t *transcoder) get(i uint32) bool {
+	if int64(i) >= int64(len(t.memo)) {
+		return false
+	}
+	m := t.memo[i]
+	if !m.set || m.kind > tcScalar {
+		return false
+	}
+	t.push(m.kind)
+	t.out = append(t.out, t.out[m.start:m.end]...)
+	return true
+}
+
+// write writes out to the encoder's writer, filling in the slots
+func (t *transcoder) write() error {
+	e := t.enc
+	out := t.out
+	for len(out) != 0 {
+		if len(e.buf) >= jsonFlushSize {
+			if err := e.flush(); err != nil {
+				e.buf = e.buf[:0]
+				return err
+			}
+		}
+		i := 0
+		for i < len(out) && out[i] >= ' ' {
+			i++
+		}
+		e.buf = append(e.buf, out[:i]...)
+		for ; i < len(out) && out[i] < ' '; i++ {
+			for n := out[i]; n != 0; n-- {
+				e.buf = append(e.buf, '[')
+			}
+		}
+		out = out[i:]
+	}
+	err := e.flush()
+	e.buf = e.buf[:0]
+	return err
+}
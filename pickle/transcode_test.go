@@ -0,0 +1,194 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// corpusPickle is one of the pickles in testdata/corpus.txt, which testdata/corpus.py writes
+type corpusPickle struct {
+	name     string // the index of the object, and the protocol it was pickled with
+	protocol int
+	data     []byte
+}
+
+func readCorpus(tb testing.TB) []corpusPickle {
+	f, err := os.Open("testdata/corpus.txt")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	var corpus []corpusPickle
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 {
+			tb.Fatalf("bad corpus line %q", sc.Text())
+		}
+		data, err := hex.DecodeString(fields[2])
+		if err != nil {
+			tb.Fatal(err)
+		}
+		protocol, err := strconv.Atoi(fields[1])
+		if err != nil {
+			tb.Fatal(err)
+		}
+		corpus = append(corpus, corpusPickle{name: fields[0] + "/protocol" + fields[1], protocol: protocol, data: data})
+	}
+	if err := sc.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return corpus
+}
+
+// transcode writes the JSON of p with Transcode
+func transcode(enc *JSONEncoder, p []byte) error {
+	u := NewUnpickler(p)
+	u.AllowUnknownClasses = true
+	return u.Transcode(enc)
+}
+
+// loadEncode writes the JSON of p with Load and Encode
+func loadEncode(enc *JSONEncoder, p []byte) error {
+	u := NewUnpickler(p)
+	u.AllowUnknownClasses = true
+	obj, err := u.Load()
+	if err != nil {
+		return err
+	}
+	return enc.Encode(obj)
+}
+
+func TestTranscode(t *testing.T) {
+	corpus := readCorpus(t)
+	for _, opts := range []JSONOptions{
+		{},
+		{CompositeKeys: CompositeKeysPairs, Cycles: CyclesRef},
+		{CompositeKeys: CompositeKeysSkip, Cycles: CyclesNull, Objects: ObjectReduce, ClassKey: true},
+		{PreserveSharing: true, Indent: "  "},
+	} {
+		var want, got bytes.Buffer
+		wantEnc, gotEnc := NewJSONEncoder(&want, opts), NewJSONEncoder(&got, opts)
+		for _, p := range corpus {
+			want.Reset()
+			got.Reset()
+			wantErr := loadEncode(wantEnc, p.data)
+			err := transcode(gotEnc, p.data)
+			if (err == nil) != (wantErr == nil) || (err != nil && err.Error() != wantErr.Error()) {
+				t.Errorf("%s %+v: Transcode gave error %v, want %v", p.name, opts, err, wantErr)
+			} else if got.String() != want.String() {
+				t.Errorf("%s %+v: Transcode gave\n%.200s\nwant\n%.200s", p.name, opts, got.String(), want.String())
+			}
+		}
+	}
+}
+
+func BenchmarkTranscode(b *testing.B) {
+	benchmarkJSON(b, transcode)
+}
+
+func BenchmarkLoadEncode(b *testing.B) {
+	benchmarkJSON(b, loadEncode)
+}
+
+func benchmarkJSON(b *testing.B, toJSON func(*JSONEncoder, []byte) error) {
+	corpus := readCorpus(b)
+	var size int64
+	for _, p := range corpus {
+		size += int64(len(p.data))
+	}
+	enc := NewJSONEncoder(io.Discard, JSONOptions{CompositeKeys: CompositeKeysPairs, Cycles: CyclesRef})
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range corpus {
+			if err := toJSON(enc, p.data); err != nil {
+				b.Fatalf("%s: %v", p.name, err)
+			}
+		}
+	}
+}
+
+// TestTranscodeLimits checks that limits on the JSON fail at the offset of the pickle's STOP,
+// whether Transcode takes the single pass or uses Load
+func TestTranscodeLimits(t *testing.T) {
+	tests := []struct {
+		pickle string
+		opts   JSONOptions
+		err    string
+	}{
+		{"(lp0\n(lp1\n(lp2\nI1\naaa.", JSONOptions{Limits: Limits{MaxDepth: 2}},
+			"pickle: STOP at offset 21 (stack depth 1): exceeded Limits.MaxDepth of 2"},
+		{"\x80\x04\x95\x0c\x00\x00\x00\x00\x00\x00\x00]\x94]\x94]\x94K\x01aaa.", JSONOptions{Limits: Limits{MaxDepth: 2}},
+			"pickle: STOP at offset 22 in frame (stack depth 1): exceeded Limits.MaxDepth of 2"},
+		{"(lp0\nVabcdefghij\na.", JSONOptions{Limits: Limits{MaxOutput: 10}},
+			"pickle: STOP at offset 18 (stack depth 1): exceeded Limits.MaxOutput of 10"},
+		{"(lp0\nVabcdefghij\na.", JSONOptions{Indent: "  ", Limits: Limits{MaxOutput: 16}},
+			"pickle: STOP at offset 18 (stack depth 1): exceeded Limits.MaxOutput of 16"},
+	}
+	for _, tt := range tests {
+		for _, reader := range []bool{false, true} {
+			u := NewUnpickler([]byte(tt.pickle))
+			if reader {
+				u = NewReaderUnpickler(strings.NewReader(tt.pickle))
+			}
+			err := u.Transcode(NewJSONEncoder(io.Discard, tt.opts))
+			var le *LimitError
+			if !errors.As(err, &le) || err.Error() != tt.err {
+				t.Errorf("Transcode(%q) from a reader %v gave %v, want %s", tt.pickle, reader, err, tt.err)
+			}
+		}
+	}
+}
+
+// TestTranscodeNext checks that TranscodeNext writes each of several pickles as Transcode does,
+// and that its errors have the offsets of the opcodes in the whole input
+func TestTranscodeNext(t *testing.T) {
+	const input = "I1\n.(lp0\n(lp1\n(lp2\nI1\naaa.\x80\x04\x95\x03\x00\x00\x00\x00\x00\x00\x00K\x02."
+	tests := []struct {
+		opts JSONOptions
+		out  string
+		err  string
+	}{
+		{JSONOptions{}, "1\n[[[1]]]\n2\n", ""},
+		{JSONOptions{Limits: Limits{MaxDepth: 2}}, "1\n",
+			"pickle: STOP at offset 25 (stack depth 1): exceeded Limits.MaxDepth of 2"},
+	}
+	for _, tt := range tests {
+		for _, reader := range []bool{false, true} {
+			u := NewUnpickler([]byte(input))
+			if reader {
+				u = NewReaderUnpickler(strings.NewReader(input))
+			}
+			var buf bytes.Buffer
+			enc := NewJSONEncoder(&buf, tt.opts)
+			var err error
+			for {
+				if err = u.TranscodeNext(enc); err != nil {
+					break
+				}
+				buf.WriteByte('\n')
+			}
+			msg := ""
+			if err != io.EOF {
+				msg = err.Error()
+			}
+			if buf.String() != tt.out || msg != tt.err {
+				t.Errorf("TranscodeNext with %+v from a reader %v wrote %q and gave %v, want %q and %q", tt.opts, reader, buf.String(), err, tt.out, tt.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,648 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+// Unmarshaler is implemented by types which decode themselves from a loaded
+// object. UnmarshalPickle is called with the object, which may be None, in
+// place of the rules of Unmarshal. It can use UnmarshalObject to decode parts
+// of the object.
+type Unmarshaler interface {
+	UnmarshalPickle(obj types.Object) error
+}
+
+// Unmarshal loads the pickle in data and stores the result in the value
+// pointed to by v. Classes which have no implementation of their own are
+// loaded as *types.GenericObject, as with Unpickler.AllowUnknownClasses, so
+// that their attributes can fill in structs. To control the loading, use an
+// Unpickler and UnmarshalObject.
+func Unmarshal(data []byte, v any) error {
+	u := NewUnpickler(data)
+	u.AllowUnknownClasses = true
+	obj, err := u.Load()
+	if err != nil {
+		return err
+	}
+	return UnmarshalObject(obj, v)
+}
+
+// UnmarshalObject stores obj, which is typically the result of
+// Unpickler.Load, in the value pointed to by v, much as encoding/json's
+// Unmarshal stores JSON:
+//
+// Pointers are allocated as needed, and None sets pointers, slices, maps and
+// interfaces to nil, and leaves other values unchanged. A value whose pointer
+// implements Unmarshaler decodes itself.
+//
+// Python's str, int, float, bool and complex can be stored in Go values of the
+// corresponding kind, provided they fit. An int can also be stored in a
+// big.Int, a float in an int if it is whole, and an int in a float. Bytes and
+// bytearrays can be stored in a []byte or [N]byte, or in a string, and a str
+// in a []byte as UTF-8.
+//
+// Lists, tuples and sets can be stored in slices and arrays, and dicts in maps
+// and structs. Sets can also be stored in maps whose values are bool or
+// struct{}. A dict key is stored in a map key of kind string as the string JSON
+// writes for it, and in any other map key like any other value. Instances
+// (*types.GenericObject) are stored as the dict of their attributes, or as
+// their items if they are instances of subclasses of list or dict.
+//
+// A dict key, or an attribute, is stored in the struct field whose name, or
+// name given by a `pickle:"name"` tag, is the same, or failing that, the same
+// but for case. Fields tagged `pickle:"-"` are ignored, as are keys with no
+// field. The fields of embedded structs are treated as if they were in the
+// outer struct.
+//
+// A datetime or date can be stored in a time.Time, a timedelta in a
+// time.Duration, and a decimal or fraction in a big.Rat, except for decimals
+// which types.Decimal.Rat can't convert. Anything can be stored in an empty
+// interface, as the value ToGo returns for it, or in a types.Object as itself.
+//
+// A list, dict, set or instance which is referred to more than once is
+// stored once for each pointer type it is stored through, so shared objects,
+// and objects which contain themselves, can be decoded into Go pointers.
+//
+// If obj can't be stored in v, UnmarshalObject returns an
+// *UnmarshalTypeError, having stored what it could.
+func UnmarshalObject(obj types.Object, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("UnmarshalObject requires a non-nil pointer, not %T", v)
+	}
+	d := unmarshaler{}
+	if ptr := objectPointer(obj); ptr != nil {
+		// so that references back to obj refer to v
+		d.pointers = map[identity]reflect.Value{{ptr, rv.Type()}: rv}
+	}
+	return d.decode(obj, rv.Elem())
+}
+
+// unmarshaler holds the state of one call to UnmarshalObject
+type unmarshaler struct {
+	field    string                     // the path of the struct field being decoded, like "Outer.Inner"
+	pointers map[identity]reflect.Value // the pointers which objects with an identity have been decoded into
+	active   map[identity]bool          // the objects with an identity being decoded into other values
+	conv     goConverter                // for empty interfaces, so they share values too
+}
+
+// identity is an object with an identity, and the type of Go value it is being decoded into
+type identity struct {
+	ptr unsafe.Pointer
+	typ reflect.Type
+}
+
+var (
+	objectType      = reflect.TypeOf((*types.Object)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+	bigIntType      = reflect.TypeOf(big.Int{})
+	bigRatType      = reflect.TypeOf(big.Rat{})
+)
+
+// objectPointer returns the pointer which identifies a list, dict, set or instance, or nil
+func objectPointer(obj types.Object) unsafe.Pointer {
+	switch o := obj.(type) {
+	case *types.Dict:
+		return unsafe.Pointer(o)
+	case *types.OrderedDict:
+		return unsafe.Pointer(o)
+	case *types.List:
+		return unsafe.Pointer(o)
+	case *types.Set:
+		return unsafe.Pointer(o)
+	case *types.GenericObject:
+		return unsafe.Pointer(o)
+	}
+	return nil
+}
+
+func (d *unmarshaler) decode(obj types.Object, rv reflect.Value) error {
+	if rv.CanAddr() && rv.Addr().Type().Implements(unmarshalerType) {
+		return rv.Addr().Interface().(Unmarshaler).UnmarshalPickle(obj)
+	}
+	_, isNone := obj.(types.None)
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if isNone {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		ptr := objectPointer(obj)
+		if ptr != nil {
+			if p, ok := d.pointers[identity{ptr, rv.Type()}]; ok {
+				rv.Set(p)
+				return nil
+			}
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		if ptr != nil {
+			if d.pointers == nil {
+				d.pointers = make(map[identity]reflect.Value)
+			}
+			d.pointers[identity{ptr, rv.Type()}] = rv
+		}
+		return d.decode(obj, rv.Elem())
+	case reflect.Interface:
+		switch {
+		case isNone:
+			rv.Set(reflect.Zero(rv.Type()))
+		case rv.NumMethod() == 0:
+			v, err := d.conv.convert(obj)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				rv.Set(reflect.Zero(rv.Type()))
+			} else {
+				rv.Set(reflect.ValueOf(v))
+			}
+		case obj != nil && (rv.Type() == objectType || reflect.TypeOf(obj).Implements(rv.Type())):
+			rv.Set(reflect.ValueOf(obj))
+		default:
+			return d.typeError(obj, rv.Type())
+		}
+		return nil
+	case reflect.Slice, reflect.Map:
+		if isNone {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+	}
+	if isNone {
+		return nil
+	}
+
+	switch rv.Type() {
+	case timeType:
+		switch o := obj.(type) {
+		case *types.DateTime:
+			rv.Set(reflect.ValueOf(o.GoTime()))
+		case *types.Date:
+			rv.Set(reflect.ValueOf(o.GoTime()))
+		default:
+			return d.typeError(obj, rv.Type())
+		}
+		return nil
+	case durationType:
+		if o, ok := obj.(*types.TimeDelta); ok {
+			rv.SetInt(int64(o.Duration()))
+			return nil
+		}
+	case bigIntType:
+		i := rv.Addr().Interface().(*big.Int)
+		switch o := obj.(type) {
+		case types.Int:
+			i.SetInt64(int64(o))
+		case *types.Long:
+			i.Set((*big.Int)(o))
+		case types.Bool:
+			i.SetInt64(boolInt(o))
+		default:
+			return d.typeError(obj, rv.Type())
+		}
+		return nil
+	case bigRatType:
+		r := rv.Addr().Interface().(*big.Rat)
+		switch o := obj.(type) {
+		case types.Int:
+			r.SetInt64(int64(o))
+		case *types.Long:
+			r.SetInt((*big.Int)(o))
+		case *types.Fraction:
+			r.Set((*big.Rat)(o))
+		case *types.Decimal:
+			dr := o.Rat()
+			if dr == nil {
+				return d.typeError(obj, rv.Type())
+			}
+			r.Set(dr)
+		default:
+			return d.typeError(obj, rv.Type())
+		}
+		return nil
+	}
+
+	if ptr := objectPointer(obj); ptr != nil {
+		id := identity{ptr, rv.Type()}
+		if d.active[id] {
+			return fmt.Errorf("%T contains itself, and can only be unmarshaled into %s through a pointer", obj, rv.Type())
+		}
+		if d.active == nil {
+			d.active = make(map[identity]bool)
+		}
+		d.active[id] = true
+		defer delete(d.active, id)
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(types.Bool)
+		if !ok {
+			return d.typeError(obj, rv.Type())
+		}
+		rv.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := intValue(obj)
+		if !ok || !i.IsInt64() || rv.OverflowInt(i.Int64()) {
+			return d.typeError(obj, rv.Type())
+		}
+		rv.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := intValue(obj)
+		if !ok || !i.IsUint64() || rv.OverflowUint(i.Uint64()) {
+			return d.typeError(obj, rv.Type())
+		}
+		rv.SetUint(i.Uint64())
+	case reflect.Float32, reflect.Float64:
+		f, ok := floatValue(obj)
+		if !ok {
+			return d.typeError(obj, rv.Type())
+		}
+		rv.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		if c, ok := obj.(types.Complex); ok {
+			rv.SetComplex(complex128(c))
+			return nil
+		}
+		f, ok := floatValue(obj)
+		if !ok {
+			return d.typeError(obj, rv.Type())
+		}
+		rv.SetComplex(complex(f, 0))
+	case reflect.String:
+		switch o := obj.(type) {
+		case types.String:
+			rv.SetString(o.String())
+		case types.ByteArray:
+			rv.SetString(string(o))
+		default:
+			return d.typeError(obj, rv.Type())
+		}
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			switch o := obj.(type) {
+			case types.ByteArray:
+				rv.SetBytes(append([]byte(nil), o...))
+				return nil
+			case types.String:
+				rv.SetBytes([]byte(o.String()))
+				return nil
+			}
+		}
+		items, ok := sequence(obj)
+		if !ok {
+			return d.typeError(obj, rv.Type())
+		}
+		s := reflect.MakeSlice(rv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := d.decode(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+	case reflect.Array:
+		if b, ok := obj.(types.ByteArray); ok && rv.Type().Elem().Kind() == reflect.Uint8 {
+			reflect.Copy(rv, reflect.ValueOf([]byte(b)))
+			return nil
+		}
+		items, ok := sequence(obj)
+		if !ok {
+			return d.typeError(obj, rv.Type())
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i < len(items) {
+				if err := d.decode(items[i], rv.Index(i)); err != nil {
+					return err
+				}
+			} else {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+			}
+		}
+	case reflect.Map:
+		return d.decodeMap(obj, rv)
+	case reflect.Struct:
+		return d.decodeStruct(obj, rv)
+	default:
+		return d.typeError(obj, rv.Type())
+	}
+	return nil
+}
+
+// decodeMap stores a dict, or a set, in a map
+func (d *unmarshaler) decodeMap(obj types.Object, rv reflect.Value) error {
+	t := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+	if elems, ok := setElements(obj); ok {
+		var present reflect.Value
+		switch {
+		case t.Elem().Kind() == reflect.Bool:
+			present = reflect.ValueOf(true).Convert(t.Elem())
+		case t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0:
+			present = reflect.Zero(t.Elem())
+		default:
+			return d.typeError(obj, t)
+		}
+		for _, elem := range elems {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.decodeKey(elem, key); err != nil {
+				return err
+			}
+			rv.SetMapIndex(key, present)
+		}
+		return nil
+	}
+	items, ok := mapping(obj)
+	if !ok {
+		return d.typeError(obj, t)
+	}
+	for i := 0; i+1 < len(items); i += 2 {
+		key := reflect.New(t.Key()).Elem()
+		if err := d.decodeKey(items[i], key); err != nil {
+			return err
+		}
+		value := reflect.New(t.Elem()).Elem()
+		if err := d.decode(items[i+1], value); err != nil {
+			return err
+		}
+		rv.SetMapIndex(key, value)
+	}
+	return nil
+}
+
+// decodeKey stores a dict key, or set element, in a map key
+func (d *unmarshaler) decodeKey(obj types.Object, key reflect.Value) error {
+	if key.Kind() == reflect.String && !reflect.PointerTo(key.Type()).Implements(unmarshalerType) {
+		s, ok := keyString(obj)
+		if !ok {
+			return d.typeError(obj, key.Type())
+		}
+		key.SetString(s)
+		return nil
+	}
+	return d.decode(obj, key)
+}
+
+// decodeStruct stores a dict, or the attributes of an instance, in the fields of a struct
+func (d *unmarshaler) decodeStruct(obj types.Object, rv reflect.Value) error {
+	items, ok := mapping(obj)
+	if !ok {
+		return d.typeError(obj, rv.Type())
+	}
+	fields := structFields(rv.Type())
+	outer := d.field
+	for i := 0; i+1 < len(items); i += 2 {
+		key, ok := items[i].(types.String)
+		if !ok {
+			continue
+		}
+		f := fields.find(key.String())
+		if f == nil {
+			continue
+		}
+		fv, err := fieldByIndex(rv, f.index)
+		if err != nil {
+			return err
+		}
+		switch {
+		case outer != "":
+			d.field = outer + "." + f.goName
+		case rv.Type().Name() != "":
+			d.field = rv.Type().Name() + "." + f.goName
+		default:
+			d.field = f.goName
+		}
+		if err := d.decode(items[i+1], fv); err != nil {
+			return err
+		}
+	}
+	d.field = outer
+	return nil
+}
+
+// fieldByIndex returns the field of rv at index, allocating embedded pointers on the way
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return rv, fmt.Errorf("can't set embedded pointer to unexported struct %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+func (d *unmarshaler) typeError(obj types.Object, t reflect.Type) error {
+	return &UnmarshalTypeError{Object: obj, Type: t, Field: d.field}
+}
+
+// sequence returns the items of a list, tuple, set or instance of a subclass of list
+func sequence(obj types.Object) ([]types.Object, bool) {
+	switch o := obj.(type) {
+	case *types.List:
+		return *o, true
+	case types.Tuple:
+		return o, true
+	case *types.Set:
+		return *o, true
+	case types.FrozenSet:
+		return o, true
+	case *types.GenericObject:
+		return o.ListItems, len(o.DictItems) == 0
+	}
+	return nil, false
+}
+
+// setElements returns the elements of a set or frozenset
+func setElements(obj types.Object) ([]types.Object, bool) {
+	switch o := obj.(type) {
+	case *types.Set:
+		return *o, true
+	case types.FrozenSet:
+		return o, true
+	}
+	return nil, false
+}
+
+// mapping returns the keys and values of a dict, or of the attributes or items of an instance
+func mapping(obj types.Object) (types.Dict, bool) {
+	switch o := obj.(type) {
+	case *types.Dict:
+		return *o, true
+	case *types.OrderedDict:
+		return types.Dict(*o), true
+	case *types.GenericObject:
+		switch {
+		case len(o.ListItems) != 0:
+			return nil, false
+		case len(o.DictItems) != 0:
+			return o.DictItems, true
+		}
+		return o.Attributes(), true
+	}
+	return nil, false
+}
+
+// intValue returns the value of an int, or of a float which is whole
+func intValue(obj types.Object) (*big.Int, bool) {
+	switch o := obj.(type) {
+	case types.Int:
+		return big.NewInt(int64(o)), true
+	case *types.Long:
+		return (*big.Int)(o), true
+	case types.Bool:
+		return big.NewInt(boolInt(o)), true
+	case types.Float:
+		f := big.NewFloat(float64(o))
+		if f.IsInt() {
+			i, _ := f.Int(nil)
+			return i, true
+		}
+	}
+	return nil, false
+}
+
+// floatValue returns the value of a float, int, decimal or fraction, as a float64
+func floatValue(obj types.Object) (float64, bool) {
+	switch o := obj.(type) {
+	case types.Float:
+		return float64(o), true
+	case types.Int:
+		return float64(o), true
+	case *types.Long:
+		f, _ := new(big.Float).SetInt((*big.Int)(o)).Float64()
+		return f, true
+	case types.Bool:
+		return float64(boolInt(o)), true
+	case *types.Decimal:
+		return o.Float64(), true
+	case *types.Fraction:
+		f, _ := (*big.Rat)(o).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+func boolInt(b types.Bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// structField is a field of a struct which Unmarshal can set and Marshal writes
+type structField struct {
+	name      string // the name in the pickle
+	goName    string
+	index     []int // as for reflect.Value.FieldByIndex
+	omitEmpty bool  // the omitempty option was given
+}
+
+type fieldList []structField
+
+// find returns the field with the name, or failing that, the name but for case
+func (l fieldList) find(name string) *structField {
+	for i := range l {
+		if l[i].name == name {
+			return &l[i]
+		}
+	}
+	for i := range l {
+		if strings.EqualFold(l[i].name, name) {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+var fieldCache sync.Map // reflect.Type -> fieldList
+
+// structFields returns the fields of a struct type which can be set, including those
+// of embedded structs which aren't hidden by fields of the outer struct
+func structFields(t reflect.Type) fieldList {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(fieldList)
+	}
+	var fields fieldList
+	seen := map[string]bool{}
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	level := []embedded{{t, nil}}
+	visited := map[reflect.Type]bool{}
+	for len(level) != 0 {
+		var next []embedded
+		var found fieldList
+		for _, e := range level {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				tag := sf.Tag.Get("pickle")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), e.index...), i)
+				ft := sf.Type
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, index})
+					continue
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				if name == "" {
+					name = sf.Name
+				}
+				found = append(found, structField{
+					name:      name,
+					goName:    sf.Name,
+					index:     index,
+					omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+				})
+			}
+		}
+		// fields at shallower depths hide those of the same name deeper down
+		for _, f := range found {
+			if !seen[f.name] {
+				fields = append(fields, f)
+			}
+		}
+		for _, f := range found {
+			seen[f.name] = true
+		}
+		level = next
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pickle
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistsys/gopickle2json/types"
+)
+
+type unmarshalPerson struct {
+	Name   string
+	Age    int    `pickle:"age"`
+	Tag    string `pickle:"tag"`
+	Skip   int    `pickle:"-"`
+	hidden int
+}
+
+type unmarshalInner struct {
+	N int
+}
+
+type unmarshalOuter struct {
+	Name string
+	In   unmarshalInner
+}
+
+type unmarshalEmbedded struct {
+	unmarshalInner
+	Name string
+	Age  int
+}
+
+type unmarshalNode struct {
+	Next *unmarshalNode
+}
+
+type unmarshalCycle map[string]unmarshalCycle
+
+// unmarshalUpper is a string which stores strs in upper case
+type unmarshalUpper string
+
+func (u *unmarshalUpper) UnmarshalPickle(obj types.Object) error {
+	s, ok := obj.(types.String)
+	if !ok {
+		return errors.New("not a str")
+	}
+	*u = unmarshalUpper(strings.ToUpper(s.String()))
+	return nil
+}
+
+func bigInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 10)
+	return i
+}
+
+// The pickles are CPython's, with protocol 2 or 3, of the objects in the comments.
+var unmarshalTests = []struct {
+	in  string
+	ptr any // a pointer to the zero value to unmarshal into
+	out any // what ptr points to afterwards
+	err string
+}{
+	// 1
+	{in: "\x80\x02K\x01.", ptr: new(int), out: 1},
+	{in: "\x80\x02K\x01.", ptr: new(uint8), out: uint8(1)},
+	{in: "\x80\x02K\x01.", ptr: new(float32), out: float32(1)},
+	{in: "\x80\x02K\x01.", ptr: new(complex64), out: complex64(1)},
+	{in: "\x80\x02K\x01.", ptr: new(big.Int), out: *big.NewInt(1)},
+	{in: "\x80\x02K\x01.", ptr: new(big.Rat), out: *big.NewRat(1, 1)},
+	{in: "\x80\x02K\x01.", ptr: new(**int), out: func() **int { i := 1; p := &i; return &p }()},
+	{in: "\x80\x02K\x01.", ptr: new(any), out: int64(1)},
+	{in: "\x80\x02K\x01.", ptr: new(types.Object), out: types.Object(types.Int(1))},
+	{in: "\x80\x02K\x01.", ptr: new(string), out: "",
+		err: "can't unmarshal types.Int into Go value of type string"},
+	{in: "\x80\x02K\x01.", ptr: new(bool), out: false,
+		err: "can't unmarshal types.Int into Go value of type bool"},
+	{in: "\x80\x02K\x01.", ptr: new(error), out: error(nil),
+		err: "can't unmarshal types.Int into Go value of type error"},
+	// 300
+	{in: "\x80\x02M,\x01.", ptr: new(int8), out: int8(0),
+		err: "can't unmarshal types.Int into Go value of type int8"},
+	// -1
+	{in: "\x80\x02J\xff\xff\xff\xff.", ptr: new(int), out: -1},
+	{in: "\x80\x02J\xff\xff\xff\xff.", ptr: new(uint), out: uint(0),
+		err: "can't unmarshal types.Int into Go value of type uint"},
+	// 2**70
+	{in: "\x80\x02\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@.", ptr: new(big.Int), out: *bigInt("1180591620717411303424")},
+	{in: "\x80\x02\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@.", ptr: new(float64), out: 1180591620717411303424.0},
+	{in: "\x80\x02\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@.", ptr: new(int64), out: int64(0),
+		err: "can't unmarshal *types.Long into Go value of type int64"},
+	// 2.0, 2.5
+	{in: "\x80\x02G@\x00\x00\x00\x00\x00\x00\x00.", ptr: new(int), out: 2},
+	{in: "\x80\x02G@\x04\x00\x00\x00\x00\x00\x00.", ptr: new(float64), out: 2.5},
+	{in: "\x80\x02G@\x04\x00\x00\x00\x00\x00\x00.", ptr: new(int), out: 0,
+		err: "can't unmarshal types.Float into Go value of type int"},
+	// True
+	{in: "\x80\x02\x88.", ptr: new(bool), out: true},
+	{in: "\x80\x02\x88.", ptr: new(int), out: 1},
+	// 'héllo'
+	{in: "\x80\x02X\x06\x00\x00\x00h\xc3\xa9lloq\x00.", ptr: new(string), out: "héllo"},
+	{in: "\x80\x02X\x06\x00\x00\x00h\xc3\xa9lloq\x00.", ptr: new([]byte), out: []byte("héllo")},
+	{in: "\x80\x02X\x06\x00\x00\x00h\xc3\xa9lloq\x00.", ptr: new(int), out: 0,
+		err: "can't unmarshal *types.EscapedString into Go value of type int"},
+	{in: "\x80\x02X\x06\x00\x00\x00h\xc3\xa9lloq\x00.", ptr: new(unmarshalUpper), out: unmarshalUpper("HÉLLO")},
+	{in: "\x80\x02X\x06\x00\x00\x00h\xc3\xa9lloq\x00.", ptr: new(map[unmarshalUpper]int), out: map[unmarshalUpper]int{},
+		err: "can't unmarshal *types.EscapedString into Go value of type map[pickle.unmarshalUpper]int"},
+	{in: "\x80\x02K\x01.", ptr: new(unmarshalUpper), out: unmarshalUpper(""), err: "not a str"},
+	// b'ab'
+	{in: "\x80\x03C\x02abq\x00.", ptr: new([]byte), out: []byte("ab")},
+	{in: "\x80\x03C\x02abq\x00.", ptr: new(string), out: "ab"},
+	{in: "\x80\x03C\x02abq\x00.", ptr: new([3]byte), out: [3]byte{'a', 'b'}},
+	// complex(1, -2)
+	{in: "\x80\x02c__builtin__\ncomplex\nq\x00G?\xf0\x00\x00\x00\x00\x00\x00G\xc0\x00\x00\x00\x00\x00\x00\x00\x86q\x01Rq\x02.",
+		ptr: new(complex128), out: complex(1, -2)},
+	{in: "\x80\x02c__builtin__\ncomplex\nq\x00G?\xf0\x00\x00\x00\x00\x00\x00G\xc0\x00\x00\x00\x00\x00\x00\x00\x86q\x01Rq\x02.",
+		ptr: new(float64), out: 0.0, err: "can't unmarshal types.Complex into Go value of type float64"},
+	// [1, 2, 3]
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new([]int), out: []int{1, 2, 3}},
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new([2]int), out: [2]int{1, 2}},
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new([4]int), out: [4]int{1, 2, 3, 0}},
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new(any), out: []any{int64(1), int64(2), int64(3)}},
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new([]string), out: []string(nil),
+		err: "can't unmarshal types.Int into Go value of type string"},
+	{in: "\x80\x02]q\x00(K\x01K\x02K\x03e.", ptr: new(map[string]int), out: map[string]int{},
+		err: "can't unmarshal *types.List into Go value of type map[string]int"},
+	// ('a', 'b')
+	{in: "\x80\x02X\x01\x00\x00\x00aq\x00X\x01\x00\x00\x00bq\x01\x86q\x02.", ptr: new([]string), out: []string{"a", "b"}},
+	// {1, 2}
+	{in: "\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03.", ptr: new([]int), out: []int{1, 2}},
+	{in: "\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03.", ptr: new(map[int]bool), out: map[int]bool{1: true, 2: true}},
+	{in: "\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03.", ptr: new(map[string]struct{}), out: map[string]struct{}{"1": {}, "2": {}}},
+	{in: "\x80\x02c__builtin__\nset\nq\x00]q\x01(K\x01K\x02e\x85q\x02Rq\x03.", ptr: new(map[int]int), out: map[int]int{},
+		err: "can't unmarshal *types.Set into Go value of type map[int]int"},
+	// {'a': 1, 'b': 2}
+	{in: "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01K\x01X\x01\x00\x00\x00bq\x02K\x02u.", ptr: new(map[string]int), out: map[string]int{"a": 1, "b": 2}},
+	{in: "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01K\x01X\x01\x00\x00\x00bq\x02K\x02u.", ptr: new(any), out: map[string]any{"a": int64(1), "b": int64(2)}},
+	{in: "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01K\x01X\x01\x00\x00\x00bq\x02K\x02u.", ptr: new(map[unmarshalUpper]int), out: map[unmarshalUpper]int{"A": 1, "B": 2}},
+	{in: "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01K\x01X\x01\x00\x00\x00bq\x02K\x02u.", ptr: new(map[int]int), out: map[int]int{},
+		err: "can't unmarshal *types.SimpleString into Go value of type int"},
+	{in: "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01K\x01X\x01\x00\x00\x00bq\x02K\x02u.", ptr: new([]int), out: []int(nil),
+		err: "can't unmarshal *types.Dict into Go value of type []int"},
+	// {1: 2}
+	{in: "\x80\x02}q\x00K\x01K\x02s.", ptr: new(map[int]int), out: map[int]int{1: 2}},
+	{in: "\x80\x02}q\x00K\x01K\x02s.", ptr: new(map[string]int), out: map[string]int{"1": 2}},
+	// {(1, 2): 3}
+	{in: "\x80\x02}q\x00K\x01K\x02\x86q\x01K\x03s.", ptr: new(map[[2]int]int), out: map[[2]int]int{{1, 2}: 3}},
+	{in: "\x80\x02}q\x00K\x01K\x02\x86q\x01K\x03s.", ptr: new(map[string]int), out: map[string]int{},
+		err: "can't unmarshal types.Tuple into Go value of type string"},
+	// OrderedDict(b=1, a=2)
+	{in: "\x80\x02ccollections\nOrderedDict\nq\x00)Rq\x01(X\x01\x00\x00\x00bq\x02K\x01X\x01\x00\x00\x00aq\x03K\x02u.",
+		ptr: new(map[string]int), out: map[string]int{"a": 2, "b": 1}},
+	// {'Name': 'x', 'AGE': 3, 'tag': 't', 'Skip': 1, 'Other': 2}
+	{in: "\x80\x02}q\x00(X\x04\x00\x00\x00Nameq\x01X\x01\x00\x00\x00xq\x02X\x03\x00\x00\x00AGEq\x03K\x03X\x03\x00\x00\x00tagq\x04X\x01\x00\x00\x00tq\x05X\x04\x00\x00\x00Skipq\x06K\x01X\x05\x00\x00\x00Otherq\x07K\x02u.",
+		ptr: new(unmarshalPerson), out: unmarshalPerson{Name: "x", Age: 3, Tag: "t"}},
+	// {'Name': 'x', 'In': {'N': 'y'}}
+	{in: "\x80\x02}q\x00(X\x04\x00\x00\x00Nameq\x01X\x01\x00\x00\x00xq\x02X\x02\x00\x00\x00Inq\x03}q\x04X\x01\x00\x00\x00Nq\x05X\x01\x00\x00\x00yq\x06su.",
+		ptr: new(unmarshalOuter), out: unmarshalOuter{Name: "x"},
+		err: "can't unmarshal *types.SimpleString into Go struct field unmarshalOuter.In.N of type int"},
+	{in: "\x80\x02}q\x00(X\x04\x00\x00\x00Nameq\x01X\x01\x00\x00\x00xq\x02X\x02\x00\x00\x00Inq\x03}q\x04X\x01\x00\x00\x00Nq\x05X\x01\x00\x00\x00yq\x06su.",
+		ptr: new(struct{ Name []int }), out: struct{ Name []int }{},
+		err: "can't unmarshal *types.SimpleString into Go struct field Name of type []int"},
+	// mod.C instance with Name='x', Age=3
+	{in: "\x80\x02cmod\nC\nq\x00)\x81q\x01}q\x02(X\x04\x00\x00\x00Nameq\x03X\x01\x00\x00\x00xq\x04X\x03\x00\x00\x00Ageq\x05K\x03ub.",
+		ptr: new(unmarshalEmbedded), out: unmarshalEmbedded{Name: "x", Age: 3}},
+	{in: "\x80\x02cmod\nC\nq\x00)\x81q\x01}q\x02(X\x04\x00\x00\x00Nameq\x03X\x01\x00\x00\x00xq\x04X\x03\x00\x00\x00Ageq\x05K\x03ub.",
+		ptr: new(*unmarshalPerson), out: &unmarshalPerson{Name: "x", Age: 3}},
+	{in: "\x80\x02cmod\nC\nq\x00)\x81q\x01}q\x02(X\x04\x00\x00\x00Nameq\x03X\x01\x00\x00\x00xq\x04X\x03\x00\x00\x00Ageq\x05K\x03ub.",
+		ptr: new(map[string]any), out: map[string]any{"Name": "x", "Age": int64(3)}},
+	// None
+	{in: "\x80\x02N.", ptr: func() *int { i := 1; return &i }(), out: 1},
+	{in: "\x80\x02N.", ptr: func() **int { i := 1; p := &i; return &p }(), out: (*int)(nil)},
+	{in: "\x80\x02N.", ptr: &[]int{1}, out: []int(nil)},
+	{in: "\x80\x02N.", ptr: &map[string]int{"a": 1}, out: map[string]int(nil)},
+	{in: "\x80\x02N.", ptr: func() *any { var v any = 1; return &v }(), out: nil},
+	// datetime.datetime(2022, 1, 2, 3, 4, 5, 6)
+	{in: "\x80\x02cdatetime\ndatetime\nq\x00c_codecs\nencode\nq\x01X\x0b\x00\x00\x00\x07\xc3\xa6\x01\x02\x03\x04\x05\x00\x00\x06q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07.",
+		ptr: new(time.Time), out: time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)},
+	// datetime.date(2022, 1, 2)
+	{in: "\x80\x02cdatetime\ndate\nq\x00c_codecs\nencode\nq\x01X\x05\x00\x00\x00\x07\xc3\xa6\x01\x02q\x02X\x06\x00\x00\x00latin1q\x03\x86q\x04Rq\x05\x85q\x06Rq\x07.",
+		ptr: new(time.Time), out: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+	// datetime.timedelta(days=1, seconds=2)
+	{in: "\x80\x02cdatetime\ntimedelta\nq\x00K\x01K\x02K\x00\x87q\x01Rq\x02.", ptr: new(time.Duration), out: 24*time.Hour + 2*time.Second},
+	{in: "\x80\x02cdatetime\ntimedelta\nq\x00K\x01K\x02K\x00\x87q\x01Rq\x02.", ptr: new(time.Time), out: time.Time{},
+		err: "can't unmarshal *types.TimeDelta into Go value of type time.Time"},
+	// fractions.Fraction(-3, 4)
+	{in: "\x80\x02cfractions\nFraction\nq\x00J\xfd\xff\xff\xffK\x04\x86q\x01Rq\x02.", ptr: new(big.Rat), out: *big.NewRat(-3, 4)},
+	{in: "\x80\x02cfractions\nFraction\nq\x00J\xfd\xff\xff\xffK\x04\x86q\x01Rq\x02.", ptr: new(float64), out: -0.75},
+	// decimal.Decimal('1.25'), decimal.Decimal('NaN')
+	{in: "\x80\x02cdecimal\nDecimal\nq\x00X\x04\x00\x00\x001.25q\x01\x85q\x02Rq\x03.", ptr: new(big.Rat), out: *big.NewRat(5, 4)},
+	{in: "\x80\x02cdecimal\nDecimal\nq\x00X\x04\x00\x00\x001.25q\x01\x85q\x02Rq\x03.", ptr: new(float32), out: float32(1.25)},
+	{in: "\x80\x02cdecimal\nDecimal\nq\x00X\x03\x00\x00\x00NaNq\x01\x85q\x02Rq\x03.", ptr: new(big.Rat), out: big.Rat{},
+		err: "can't unmarshal *types.Decimal into Go value of type big.Rat"},
+	// r = {}; r['Next'] = r
+	{in: "\x80\x02}q\x00X\x04\x00\x00\x00Nextq\x01h\x00s.", ptr: new(unmarshalCycle), out: unmarshalCycle{},
+		err: "*types.Dict contains itself, and can only be unmarshaled into pickle.unmarshalCycle through a pointer"},
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, test := range unmarshalTests {
+		err := Unmarshal([]byte(test.in), test.ptr)
+		if test.err == "" && err != nil {
+			t.Errorf("Unmarshal(%q, %T): %v", test.in, test.ptr, err)
+			continue
+		}
+		if test.err != "" {
+			var te *UnmarshalTypeError
+			switch {
+			case err == nil:
+				t.Errorf("Unmarshal(%q, %T) succeeded, want error %q", test.in, test.ptr, test.err)
+				continue
+			case err.Error() != test.err:
+				t.Errorf("Unmarshal(%q, %T) error %q, want %q", test.in, test.ptr, err, test.err)
+			case !errors.As(err, &te) && strings.HasPrefix(test.err, "can't unmarshal"):
+				t.Errorf("Unmarshal(%q, %T) error is a %T, not an *UnmarshalTypeError", test.in, test.ptr, err)
+			}
+		}
+		got := reflect.ValueOf(test.ptr).Elem().Interface()
+		if !reflect.DeepEqual(got, test.out) {
+			t.Errorf("Unmarshal(%q, %T) = %#v, want %#v", test.in, test.ptr, got, test.out)
+		}
+	}
+}
+
+// TestUnmarshalShared checks that objects referred to more than once are stored once for each
+// pointer type they are stored through.
+func TestUnmarshalShared(t *testing.T) {
+	// l = [1, 2]; [l, l]
+	var shared []*[]int
+	if err := Unmarshal([]byte("\x80\x02]q\x00(]q\x01(K\x01K\x02eh\x01e."), &shared); err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 2 || shared[0] != shared[1] || !reflect.DeepEqual(*shared[0], []int{1, 2}) {
+		t.Errorf("Unmarshal([l, l]) = %v, want two pointers to the same [1 2]", shared)
+	}
+	var copies [][]int
+	if err := Unmarshal([]byte("\x80\x02]q\x00(]q\x01(K\x01K\x02eh\x01e."), &copies); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(copies, [][]int{{1, 2}, {1, 2}}) {
+		t.Errorf("Unmarshal([l, l]) = %v, want [[1 2] [1 2]]", copies)
+	}
+
+	// r = {}; r['Next'] = r
+	var node unmarshalNode
+	if err := Unmarshal([]byte("\x80\x02}q\x00X\x04\x00\x00\x00Nextq\x01h\x00s."), &node); err != nil {
+		t.Fatal(err)
+	}
+	if node.Next != &node {
+		t.Errorf("Unmarshal(r) = %+v, want a node whose Next is itself", node)
+	}
+}
+
+func TestUnmarshalObjectNonPointer(t *testing.T) {
+	var i int
+	for _, v := range []any{i, (*int)(nil), nil} {
+		err := UnmarshalObject(types.Int(1), v)
+		if err == nil {
+			t.Errorf("UnmarshalObject(1, %#v) succeeded", v)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package types
 
 import (
 	"encoding/base64"
+	"fmt"
 	"strings"
 )
 
@@ -18,8 +19,112 @@ func NewByteArray(bytes []byte) ByteArray {
 	return ByteArray(bytes)
 }
 
+// JSON writes the ByteArray as a JSON string holding its standard base64 encoding.
 func (a ByteArray) JSON(b *strings.Builder) {
+	b.WriteByte('"')
 	w := base64.NewEncoder(base64.StdEncoding, b)
 	w.Write([]byte(a))
 	w.Close()
+	b.WriteByte('"')
+}
+
+// CodecsEncodeFunc represents Python "_codecs.encode" function. Python 3 uses
+// it to pickle bytes objects with protocols 0 to 2, which have no opcode for
+// bytes, as _codecs.encode(str, "latin1").
+type CodecsEncodeFunc struct{}
+
+var _ Callable = &CodecsEncodeFunc{}
+
+// Call returns the ByteArray encoding of a string. Only the latin-1 and UTF-8
+// encodings are supported.
+func (*CodecsEncodeFunc) Call(args ...Object) (Object, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("CodecsEncodeFunc.Call unprocessable args: %#v", args)
+	}
+	return encodeString("CodecsEncodeFunc.Call", args[0], args[1])
+}
+
+// encodeString returns the encoding of a string, for the function or method named caller
+func encodeString(caller string, str, enc Object) (ByteArray, error) {
+	s, ok1 := str.(String)
+	encoding, ok2 := enc.(String)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s unprocessable args: %#v", caller, []Object{str, enc})
+	}
+	switch strings.ToLower(encoding.String()) {
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		str := s.String()
+		a := make(ByteArray, 0, len(str))
+		for _, r := range str {
+			if r > 0xff {
+				return nil, fmt.Errorf("%s: %q can't be encoded as latin-1", caller, r)
+			}
+			a = append(a, byte(r))
+		}
+		return a, nil
+	case "utf-8", "utf8":
+		return ByteArray(s.String()), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported encoding %q", caller, encoding.String())
+}
+
+func (f *CodecsEncodeFunc) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: f, Type: "CodecsEncodeFunc"})
+}
+
+// BytesClass represents Python "bytes" class (a builtin type). Python 3
+// pickles an empty bytes object as bytes() with protocols 0 to 2.
+type BytesClass struct{}
+
+var _ Callable = &BytesClass{}
+
+// Call returns a new ByteArray. The arguments are either nothing, a bytes
+// object, or a string and the name of its encoding, as for _codecs.encode.
+func (*BytesClass) Call(args ...Object) (Object, error) {
+	a, ok, err := bytesArgs("BytesClass.Call", args)
+	if !ok {
+		return nil, fmt.Errorf("BytesClass.Call unprocessable args: %#v", args)
+	}
+	return a, err
+}
+
+func (c *BytesClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "BytesClass"})
+}
+
+// ByteArrayClass represents Python "bytearray" class (a builtin type).
+// Protocols 0 to 4 pickle a bytearray as bytearray(bytes), and Python 2 as
+// bytearray(str, "latin-1"). It is loaded as a ByteArray, like bytes.
+type ByteArrayClass struct{}
+
+var _ Callable = &ByteArrayClass{}
+
+// Call returns a new ByteArray, from the same arguments as BytesClass.Call.
+func (*ByteArrayClass) Call(args ...Object) (Object, error) {
+	a, ok, err := bytesArgs("ByteArrayClass.Call", args)
+	if !ok {
+		return nil, fmt.Errorf("ByteArrayClass.Call unprocessable args: %#v", args)
+	}
+	return a, err
+}
+
+func (c *ByteArrayClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "ByteArrayClass"})
+}
+
+// bytesArgs returns the ByteArray made from the arguments of bytes() or bytearray(),
+// and false if they aren't supported
+func bytesArgs(caller string, args []Object) (ByteArray, bool, error) {
+	switch len(args) {
+	case 0:
+		return ByteArray{}, true, nil
+	case 1:
+		if a, ok := args[0].(ByteArray); ok {
+			return append(ByteArray{}, a...), true, nil
+		}
+	case 2:
+		a, err := encodeString(caller, args[0], args[1])
+		return a, true, err
+	}
+	return nil, false, nil
 }
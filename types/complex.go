@@ -0,0 +1,63 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ComplexClass represents Python "complex" class (a builtin type).
+type ComplexClass struct{}
+
+var _ Callable = &ComplexClass{}
+
+// Call returns a new Complex. The arguments are the real and imaginary parts,
+// both optional, as complex.__reduce__ produces.
+func (*ComplexClass) Call(args ...Object) (Object, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("ComplexClass.Call unprocessable args: %#v", args)
+	}
+	var parts [2]float64
+	for i, a := range args {
+		switch x := a.(type) {
+		case Float:
+			parts[i] = float64(x)
+		case Int:
+			parts[i] = float64(x)
+		case *Long:
+			parts[i], _ = new(big.Float).SetInt((*big.Int)(x)).Float64()
+		case Bool:
+			if x {
+				parts[i] = 1
+			}
+		default:
+			return nil, fmt.Errorf("ComplexClass.Call unprocessable args: %#v", args)
+		}
+	}
+	return NewComplex(complex(parts[0], parts[1])), nil
+}
+
+func (c *ComplexClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "ComplexClass"})
+}
+
+// Complex represents a Python "complex" object.
+type Complex complex128
+
+func NewComplex(c complex128) Complex {
+	return Complex(c)
+}
+
+// JSON writes the complex number as an object {"real": re, "imag": im}, with
+// the parts written as Float.JSON writes them.
+func (c Complex) JSON(b *strings.Builder) {
+	b.WriteString(`{"real":`)
+	Float(real(c)).JSON(b)
+	b.WriteString(`,"imag":`)
+	Float(imag(c)).JSON(b)
+	b.WriteByte('}')
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReconstructorFunc represents Python "copyreg._reconstructor" function
+// ("copy_reg._reconstructor" in Python 2). Protocols 0 and 1 use it to pickle
+// instances of classes which don't define __reduce__.
+type ReconstructorFunc struct{}
+
+var _ Callable = &ReconstructorFunc{}
+
+// Call returns a new instance of a class. The arguments are the class, the
+// builtin class it derives from, and the value of that builtin part of the
+// instance. When the base is object the value is None and the class is
+// simply instantiated. When it is list or dict, and the instance is a
+// ListAppender or DictSetter, the items are added to the instance, as
+// base.__init__ would. Otherwise the value is passed to the class's PyNew,
+// as Python passes it to base.__new__.
+func (*ReconstructorFunc) Call(args ...Object) (Object, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("ReconstructorFunc.Call unprocessable args: %#v", args)
+	}
+	class, ok := args[0].(PyNewable)
+	if !ok {
+		return nil, fmt.Errorf("ReconstructorFunc.Call unprocessable args: %#v", args)
+	}
+	if _, ok := args[1].(*ObjectClass); ok {
+		return class.PyNew()
+	}
+	switch state := args[2].(type) {
+	case *List:
+		obj, err := class.PyNew()
+		if l, ok := obj.(ListAppender); ok && err == nil {
+			l.AppendMany(append([]Object(nil), *state...))
+			return obj, nil
+		}
+	case *Dict:
+		obj, err := class.PyNew()
+		if d, ok := obj.(DictSetter); ok && err == nil {
+			d.SetMany(append([]Object(nil), *state...))
+			return obj, nil
+		}
+	}
+	return class.PyNew(args[2])
+}
+
+func (f *ReconstructorFunc) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: f, Type: "ReconstructorFunc"})
+}
+
+// NewObjFunc represents Python "copyreg.__newobj__" function, which
+// __reduce_ex__ returns in place of the NEWOBJ opcode.
+type NewObjFunc struct{}
+
+var _ Callable = &NewObjFunc{}
+
+// Call returns the result of the PyNew method of the first argument, a class,
+// called with the remaining arguments.
+func (*NewObjFunc) Call(args ...Object) (Object, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("NewObjFunc.Call called with no arguments")
+	}
+	class, ok := args[0].(PyNewable)
+	if !ok {
+		return nil, fmt.Errorf("NewObjFunc.Call unprocessable args: %#v", args)
+	}
+	return class.PyNew(args[1:]...)
+}
+
+func (f *NewObjFunc) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: f, Type: "NewObjFunc"})
+}
+
+// NewObjExFunc represents Python "copyreg.__newobj_ex__" function, which
+// __reduce_ex__ returns in place of the NEWOBJ_EX opcode.
+type NewObjExFunc struct{}
+
+var _ Callable = &NewObjExFunc{}
+
+// Call returns the result of the PyNew method of a class. The arguments are
+// the class, a tuple of positional arguments and a dict of keyword arguments.
+// As for the NEWOBJ_EX opcode, PyNew is passed the positional arguments
+// followed by the keyword arguments dict.
+func (*NewObjExFunc) Call(args ...Object) (Object, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("NewObjExFunc.Call unprocessable args: %#v", args)
+	}
+	class, ok1 := args[0].(PyNewable)
+	classArgs, ok2 := args[1].(Tuple)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("NewObjExFunc.Call unprocessable args: %#v", args)
+	}
+	allArgs := make([]Object, 0, len(classArgs)+1)
+	allArgs = append(allArgs, classArgs...)
+	allArgs = append(allArgs, args[2])
+	return class.PyNew(allArgs...)
+}
+
+func (f *NewObjExFunc) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: f, Type: "NewObjExFunc"})
+}
@@ -0,0 +1,324 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateTimeClass represents Python "datetime.datetime" class.
+type DateTimeClass struct{}
+
+var _ Callable = &DateTimeClass{}
+
+// Call returns a new DateTime. The arguments are either the packed bytes state
+// and optional tzinfo which datetime.__reduce__ produces, or those of the
+// Python constructor: year, month, day, and optionally hour, minute, second,
+// microsecond and tzinfo.
+func (*DateTimeClass) Call(args ...Object) (Object, error) {
+	if len(args) >= 1 && len(args) <= 2 {
+		if state, ok := bytesArg(args[0], 10); ok && state[2]&0x7f >= 1 && state[2]&0x7f <= 12 {
+			dt := &DateTime{
+				Date: Date{
+					Year:  int(state[0])<<8 | int(state[1]),
+					Month: int(state[2] & 0x7f),
+					Day:   int(state[3]),
+				},
+				Time: Time{
+					Hour:        int(state[4]),
+					Minute:      int(state[5]),
+					Second:      int(state[6]),
+					Microsecond: int(state[7])<<16 | int(state[8])<<8 | int(state[9]),
+					Fold:        state[2]&0x80 != 0,
+				},
+			}
+			if len(args) == 2 {
+				dt.TZInfo = tzinfoArg(args[1])
+			}
+			return dt, nil
+		}
+	}
+	if len(args) < 3 || len(args) > 8 {
+		return nil, fmt.Errorf("DateTimeClass.Call unprocessable args: %#v", args)
+	}
+	var f [7]int
+	for i := 0; i < len(f) && i < len(args); i++ {
+		var ok bool
+		if f[i], ok = intArg(args[i]); !ok {
+			return nil, fmt.Errorf("DateTimeClass.Call unprocessable args: %#v", args)
+		}
+	}
+	dt := &DateTime{
+		Date: Date{Year: f[0], Month: f[1], Day: f[2]},
+		Time: Time{Hour: f[3], Minute: f[4], Second: f[5], Microsecond: f[6]},
+	}
+	if len(args) == 8 {
+		dt.TZInfo = tzinfoArg(args[7])
+	}
+	return dt, nil
+}
+
+func (c *DateTimeClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "DateTimeClass"})
+}
+
+// DateTime represents a Python "datetime.datetime" object. The tzinfo, if
+// any, is in Time.TZInfo.
+type DateTime struct {
+	Date
+	Time
+}
+
+// GoTime returns the datetime as a time.Time. A datetime whose tzinfo is a
+// *TimeZone is in a fixed zone with the same offset. Naive datetimes, and
+// those with any other tzinfo, are taken to be UTC.
+func (dt *DateTime) GoTime() time.Time {
+	return time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, dt.Microsecond*1000, dt.location())
+}
+
+// Timestamp returns the seconds since the Unix epoch, as Python's
+// datetime.timestamp() does, except that naive datetimes are taken to be UTC
+// rather than local time.
+func (dt *DateTime) Timestamp() float64 {
+	t := time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, 0, time.UTC)
+	us := t.Unix()*1000000 + int64(dt.Microsecond)
+	if tz, ok := dt.TZInfo.(*TimeZone); ok {
+		// the offset may have microseconds, which a time.Location can't
+		us -= (int64(tz.Offset.Days)*86400+int64(tz.Offset.Seconds))*1000000 + int64(tz.Offset.Microseconds)
+	}
+	return microsecondsToSeconds(us)
+}
+
+// JSON writes the datetime as an ISO 8601 string, as Python's
+// datetime.isoformat() does.
+func (dt *DateTime) JSON(b *strings.Builder) {
+	var buf [48]byte
+	b.WriteByte('"')
+	b.Write(dt.AppendISO(buf[:0]))
+	b.WriteByte('"')
+}
+
+// AppendISO appends the text of Python's datetime.isoformat(), like
+// "2022-10-05T01:02:03.004000+00:00", to dst.
+func (dt *DateTime) AppendISO(dst []byte) []byte {
+	dst = dt.Date.AppendISO(dst)
+	dst = append(dst, 'T')
+	return dt.Time.AppendISO(dst)
+}
+
+// DateClass represents Python "datetime.date" class.
+type DateClass struct{}
+
+var _ Callable = &DateClass{}
+
+// Call returns a new Date. The arguments are either the packed bytes state
+// which date.__reduce__ produces, or the year, month and day.
+func (*DateClass) Call(args ...Object) (Object, error) {
+	if len(args) == 1 {
+		if state, ok := bytesArg(args[0], 4); ok && state[2] >= 1 && state[2] <= 12 {
+			return &Date{
+				Year:  int(state[0])<<8 | int(state[1]),
+				Month: int(state[2]),
+				Day:   int(state[3]),
+			}, nil
+		}
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("DateClass.Call unprocessable args: %#v", args)
+	}
+	var f [3]int
+	for i := range f {
+		var ok bool
+		if f[i], ok = intArg(args[i]); !ok {
+			return nil, fmt.Errorf("DateClass.Call unprocessable args: %#v", args)
+		}
+	}
+	return &Date{Year: f[0], Month: f[1], Day: f[2]}, nil
+}
+
+func (c *DateClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "DateClass"})
+}
+
+// Date represents a Python "datetime.date" object.
+type Date struct {
+	Year, Month, Day int
+}
+
+// GoTime returns midnight UTC at the start of the date.
+func (d *Date) GoTime() time.Time {
+	return time.Date(d.Year, time.Month(d.Month), d.Day, 0, 0, 0, 0, time.UTC)
+}
+
+// JSON writes the date as an ISO 8601 string, as Python's date.isoformat()
+// does.
+func (d *Date) JSON(b *strings.Builder) {
+	var buf [16]byte
+	b.WriteByte('"')
+	b.Write(d.AppendISO(buf[:0]))
+	b.WriteByte('"')
+}
+
+// AppendISO appends the text of Python's date.isoformat(), like "2022-10-05",
+// to dst.
+func (d *Date) AppendISO(dst []byte) []byte {
+	dst = appendDigits(dst, d.Year, 4)
+	dst = append(dst, '-')
+	dst = appendDigits(dst, d.Month, 2)
+	dst = append(dst, '-')
+	return appendDigits(dst, d.Day, 2)
+}
+
+// TimeClass represents Python "datetime.time" class.
+type TimeClass struct{}
+
+var _ Callable = &TimeClass{}
+
+// Call returns a new Time. The arguments are either the packed bytes state and
+// optional tzinfo which time.__reduce__ produces, or those of the Python
+// constructor: hour, minute, second, microsecond and tzinfo, all optional.
+func (*TimeClass) Call(args ...Object) (Object, error) {
+	if len(args) >= 1 && len(args) <= 2 {
+		if state, ok := bytesArg(args[0], 6); ok && state[0]&0x7f < 24 {
+			t := &Time{
+				Hour:        int(state[0] & 0x7f),
+				Minute:      int(state[1]),
+				Second:      int(state[2]),
+				Microsecond: int(state[3])<<16 | int(state[4])<<8 | int(state[5]),
+				Fold:        state[0]&0x80 != 0,
+			}
+			if len(args) == 2 {
+				t.TZInfo = tzinfoArg(args[1])
+			}
+			return t, nil
+		}
+	}
+	if len(args) > 5 {
+		return nil, fmt.Errorf("TimeClass.Call unprocessable args: %#v", args)
+	}
+	var f [4]int
+	for i := 0; i < len(f) && i < len(args); i++ {
+		var ok bool
+		if f[i], ok = intArg(args[i]); !ok {
+			return nil, fmt.Errorf("TimeClass.Call unprocessable args: %#v", args)
+		}
+	}
+	t := &Time{Hour: f[0], Minute: f[1], Second: f[2], Microsecond: f[3]}
+	if len(args) == 5 {
+		t.TZInfo = tzinfoArg(args[4])
+	}
+	return t, nil
+}
+
+func (c *TimeClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "TimeClass"})
+}
+
+// Time represents a Python "datetime.time" object.
+type Time struct {
+	Hour, Minute, Second, Microsecond int
+	Fold                              bool   // which of two repeated wall times this is, as in PEP 495
+	TZInfo                            Object // nil, a *TimeZone, or some other tzinfo object
+}
+
+// SinceMidnight returns the time of day as a time.Duration, ignoring TZInfo.
+func (t *Time) SinceMidnight() time.Duration {
+	return time.Duration(t.Hour)*time.Hour + time.Duration(t.Minute)*time.Minute +
+		time.Duration(t.Second)*time.Second + time.Duration(t.Microsecond)*time.Microsecond
+}
+
+// JSON writes the time as an ISO 8601 string, as Python's time.isoformat()
+// does.
+func (t *Time) JSON(b *strings.Builder) {
+	var buf [32]byte
+	b.WriteByte('"')
+	b.Write(t.AppendISO(buf[:0]))
+	b.WriteByte('"')
+}
+
+// AppendISO appends the text of Python's time.isoformat(), like
+// "01:02:03.004000+05:30", to dst. The UTC offset is only known, and so only
+// written, when TZInfo is a *TimeZone.
+func (t *Time) AppendISO(dst []byte) []byte {
+	dst = appendDigits(dst, t.Hour, 2)
+	dst = append(dst, ':')
+	dst = appendDigits(dst, t.Minute, 2)
+	dst = append(dst, ':')
+	dst = appendDigits(dst, t.Second, 2)
+	if t.Microsecond != 0 {
+		dst = append(dst, '.')
+		dst = appendDigits(dst, t.Microsecond, 6)
+	}
+	if tz, ok := t.TZInfo.(*TimeZone); ok {
+		dst = tz.Offset.appendOffset(dst)
+	}
+	return dst
+}
+
+func (t *Time) location() *time.Location {
+	if tz, ok := t.TZInfo.(*TimeZone); ok {
+		return tz.Location()
+	}
+	return time.UTC
+}
+
+// bytesArg returns the contents of a bytes object, or of a Python 2 str, which has n bytes.
+// A str which was decoded as latin-1, as Python 3 needs to load it, is encoded back.
+func bytesArg(arg Object, n int) ([]byte, bool) {
+	var b []byte
+	switch a := arg.(type) {
+	case ByteArray:
+		return a, len(a) == n
+	case *SimpleString:
+		b = *a
+	case *EscapedString:
+		b = *a
+	default:
+		return nil, false
+	}
+	if len(b) == n {
+		return b, true
+	}
+	latin1 := make([]byte, 0, n)
+	for _, r := range string(b) {
+		if r >= 0x100 || len(latin1) == n {
+			return nil, false
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	return latin1, len(latin1) == n
+}
+
+func intArg(arg Object) (int, bool) {
+	i, ok := arg.(Int)
+	return int(i), ok
+}
+
+// tzinfoArg returns the tzinfo argument, which is None for naive times
+func tzinfoArg(arg Object) Object {
+	if _, ok := arg.(None); ok {
+		return nil
+	}
+	return arg
+}
+
+// appendDigits appends i with at least n digits
+func appendDigits(dst []byte, i int, n int) []byte {
+	if i < 0 {
+		dst = append(dst, '-')
+		i = -i
+	}
+	var buf [20]byte
+	p := len(buf)
+	for i != 0 || n > 0 {
+		p--
+		buf[p] = byte('0' + i%10)
+		i /= 10
+		n--
+	}
+	return append(dst, buf[p:]...)
+}
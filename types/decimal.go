@@ -0,0 +1,247 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DecimalClass represents Python "decimal.Decimal" class.
+type DecimalClass struct{}
+
+var _ Callable = &DecimalClass{}
+
+// Call returns a new Decimal. The argument is a string, as Decimal.__reduce__
+// produces, or an int.
+func (*DecimalClass) Call(args ...Object) (Object, error) {
+	if len(args) == 1 {
+		switch a := args[0].(type) {
+		case String:
+			d, err := ParseDecimal(a.String())
+			if err != nil {
+				return nil, fmt.Errorf("DecimalClass.Call: %w", err)
+			}
+			return d, nil
+		case Int:
+			return &Decimal{Negative: a < 0, Coefficient: new(big.Int).Abs(big.NewInt(int64(a)))}, nil
+		case *Long:
+			return &Decimal{Negative: (*big.Int)(a).Sign() < 0, Coefficient: new(big.Int).Abs((*big.Int)(a))}, nil
+		}
+	}
+	return nil, fmt.Errorf("DecimalClass.Call unprocessable args: %#v", args)
+}
+
+func (c *DecimalClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "DecimalClass"})
+}
+
+// DecimalForm says whether a Decimal is a number, an infinity or a NaN
+type DecimalForm int
+
+const (
+	DecimalFinite DecimalForm = iota
+	DecimalInfinite
+	DecimalNaN          // a quiet NaN
+	DecimalSignalingNaN // an sNaN
+)
+
+// Decimal represents a Python "decimal.Decimal" object. Its value is exactly
+// (-1)**Negative * Coefficient * 10**Exponent. Like Python's, it remembers
+// trailing zeros, so 1.20 and 1.2 are different Decimals.
+type Decimal struct {
+	Negative    bool
+	Coefficient *big.Int // never negative. For NaNs, the diagnostic payload, usually 0
+	Exponent    int
+	Form        DecimalForm
+}
+
+// ParseDecimal parses a decimal number in the syntax Python's Decimal
+// constructor accepts, like "1.20", "-1E+5", "Infinity" or "NaN".
+func ParseDecimal(s string) (*Decimal, error) {
+	text := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(s, "_", "")))
+	d := &Decimal{Coefficient: new(big.Int)}
+	if text != "" && (text[0] == '-' || text[0] == '+') {
+		d.Negative = text[0] == '-'
+		text = text[1:]
+	}
+	switch {
+	case text == "inf" || text == "infinity":
+		d.Form = DecimalInfinite
+		return d, nil
+	case strings.HasPrefix(text, "nan"):
+		d.Form, text = DecimalNaN, text[3:]
+	case strings.HasPrefix(text, "snan"):
+		d.Form, text = DecimalSignalingNaN, text[4:]
+	}
+	if d.Form != DecimalFinite {
+		// the payload of a NaN is optional digits
+		if text != "" && !isDigits(text) {
+			return nil, fmt.Errorf("invalid decimal %q", s)
+		}
+		if text != "" {
+			d.Coefficient.SetString(text, 10)
+		}
+		return d, nil
+	}
+
+	mantissa := text
+	if i := strings.IndexByte(text, 'e'); i >= 0 {
+		mantissa = text[:i]
+		exp, err := strconv.Atoi(text[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal %q", s)
+		}
+		d.Exponent = exp
+	}
+	intPart, fracPart := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		intPart, fracPart = mantissa[:i], mantissa[i+1:]
+	}
+	if (intPart == "" && fracPart == "") || (intPart != "" && !isDigits(intPart)) || (fracPart != "" && !isDigits(fracPart)) {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	d.Coefficient.SetString(intPart+fracPart, 10)
+	d.Exponent -= len(fracPart)
+	return d, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the decimal in the form of Python's str(Decimal), like "1.20",
+// "-1E+5" or "NaN".
+func (d *Decimal) String() string {
+	return string(d.AppendString(nil))
+}
+
+// AppendString appends the text String returns to dst.
+func (d *Decimal) AppendString(dst []byte) []byte {
+	if d.Negative {
+		dst = append(dst, '-')
+	}
+	switch d.Form {
+	case DecimalInfinite:
+		return append(dst, "Infinity"...)
+	case DecimalNaN, DecimalSignalingNaN:
+		if d.Form == DecimalSignalingNaN {
+			dst = append(dst, 's')
+		}
+		dst = append(dst, "NaN"...)
+		if d.Coefficient.Sign() != 0 {
+			dst = d.Coefficient.Append(dst, 10)
+		}
+		return dst
+	}
+
+	// this is the to-sci-string conversion of the General Decimal Arithmetic
+	// specification, as Python implements it
+	start := len(dst)
+	dst = d.Coefficient.Append(dst, 10)
+	digits := len(dst) - start
+	leftDigits := d.Exponent + digits
+	dotPlace := 1
+	if d.Exponent <= 0 && leftDigits > -6 {
+		dotPlace = leftDigits
+	}
+	switch {
+	case dotPlace <= 0:
+		// 0.000ddd: move the digits right to make room for the zeros
+		n := 2 - dotPlace
+		dst = append(dst, make([]byte, n)...)
+		copy(dst[start+n:], dst[start:start+digits])
+		dst[start], dst[start+1] = '0', '.'
+		for i := 0; i < -dotPlace; i++ {
+			dst[start+2+i] = '0'
+		}
+	case dotPlace >= digits:
+		for i := digits; i < dotPlace; i++ {
+			dst = append(dst, '0')
+		}
+	default:
+		dst = append(dst, 0)
+		copy(dst[start+dotPlace+1:], dst[start+dotPlace:])
+		dst[start+dotPlace] = '.'
+	}
+	if leftDigits != dotPlace {
+		dst = append(dst, 'E')
+		if leftDigits-dotPlace > 0 {
+			dst = append(dst, '+')
+		}
+		dst = strconv.AppendInt(dst, int64(leftDigits-dotPlace), 10)
+	}
+	return dst
+}
+
+// MaxRatExponent is the largest exponent, positive or negative, of a decimal
+// which Rat converts. Beyond it, the exact value is too costly to compute: a
+// few bytes of pickle, like Decimal('1E+300000000'), can stand for a number of
+// a billion bits.
+const MaxRatExponent = 10000
+
+// Rat returns the value of a finite decimal as a big.Rat. It returns nil for
+// infinities and NaNs, and for decimals whose exponent is beyond
+// ±MaxRatExponent.
+func (d *Decimal) Rat() *big.Rat {
+	if d.Form != DecimalFinite || abs(d.Exponent) > MaxRatExponent {
+		return nil
+	}
+	r := new(big.Rat).SetInt(d.Coefficient)
+	if d.Exponent != 0 {
+		e := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(d.Exponent))), nil)
+		if d.Exponent > 0 {
+			r.Mul(r, new(big.Rat).SetInt(e))
+		} else {
+			r.Quo(r, new(big.Rat).SetInt(e))
+		}
+	}
+	if d.Negative {
+		r.Neg(r)
+	}
+	return r
+}
+
+// Float64 returns the nearest float64 to the decimal, as Python's float()
+// does, except that signaling NaNs become NaN rather than an error.
+func (d *Decimal) Float64() float64 {
+	switch d.Form {
+	case DecimalInfinite:
+		if d.Negative {
+			return math.Inf(-1)
+		}
+		return math.Inf(1)
+	case DecimalNaN, DecimalSignalingNaN:
+		return math.NaN()
+	}
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// JSON writes a finite decimal as a JSON number with all its digits, like
+// "1.20" or "-1E+5". Infinities and NaNs are written as Float.JSON writes
+// them.
+func (d *Decimal) JSON(b *strings.Builder) {
+	if d.Form != DecimalFinite {
+		Float(d.Float64()).JSON(b)
+		return
+	}
+	b.Write(d.AppendString(nil))
+}
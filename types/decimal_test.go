@@ -0,0 +1,42 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecimalRat(t *testing.T) {
+	tests := []struct {
+		decimal string
+		want    string // the big.Rat, or "" for nil
+	}{
+		{"1.20", "6/5"},
+		{"-1E+5", "-100000/1"},
+		{"0E-7", "0/1"},
+		{"1E+10000", "1" + strings.Repeat("0", 10000) + "/1"},
+		{"1E-10000", "1/1" + strings.Repeat("0", 10000)},
+		// the exact values of these would take a billion bits
+		{"1E+300000000", ""},
+		{"-1E-300000000", ""},
+		{"1E+10001", ""},
+		{"Infinity", ""},
+		{"NaN", ""},
+	}
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.decimal)
+		if err != nil {
+			t.Fatalf("ParseDecimal(%q): %v", tt.decimal, err)
+		}
+		r := d.Rat()
+		switch {
+		case tt.want == "" && r != nil:
+			t.Errorf("Decimal(%q).Rat() = %.20s..., want nil", tt.decimal, r)
+		case tt.want != "" && (r == nil || r.String() != tt.want):
+			t.Errorf("Decimal(%q).Rat() = %.20v..., want %.20s...", tt.decimal, r, tt.want)
+		}
+	}
+}
@@ -5,6 +5,8 @@
 package types
 
 import (
+	"math/big"
+	"strconv"
 	"strings"
 )
 
@@ -45,17 +47,120 @@ func (d *Dict) SetMany(kv []Object) {
 	*d = append(*d, kv...)
 }
 
+// JSON writes the Dict as a JSON object. Scalar keys which are not strings are
+// converted to strings, as Python's json.dumps does. If any key is composite
+// (a tuple, say) the Dict is instead written as a list of [key, value] pairs,
+// as pickle.CompositeKeysPairs writes it, since JSON can't return an error.
+// The pickle package's JSONEncoder, which can, fails on such keys by default.
 func (d *Dict) JSON(b *strings.Builder) {
+	for i := 0; i < len(*d); i += 2 {
+		if !IsJSONKey((*d)[i]) {
+			d.jsonPairs(b)
+			return
+		}
+	}
 	b.WriteByte('{')
 	for i, x := range *d {
 		if i&1 == 0 {
 			if i != 0 {
 				b.WriteByte(',')
 			}
+			WriteJSONKey(b, x)
 		} else {
 			b.WriteByte(':')
+			x.JSON(b)
 		}
-		x.JSON(b)
 	}
 	b.WriteByte('}')
 }
+
+func (d *Dict) jsonPairs(b *strings.Builder) {
+	b.WriteByte('[')
+	for i, x := range *d {
+		if i&1 == 0 {
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			b.WriteByte('[')
+		} else {
+			b.WriteByte(',')
+		}
+		x.JSON(b)
+		if i&1 != 0 {
+			b.WriteByte(']')
+		}
+	}
+	b.WriteByte(']')
+}
+
+// IsJSONKey returns true if key can be written as a JSON object key by
+// WriteJSONKey. These are the strings and scalar types Python's json.dumps
+// accepts as keys.
+func IsJSONKey(key Object) bool {
+	switch key.(type) {
+	case String, Int, *Long, Float, Bool, None:
+		return true
+	}
+	return false
+}
+
+// WriteJSONKey writes key as a JSON object key, converting scalars to strings
+// like Python's json.dumps does: 1 becomes "1", True becomes "true" and None
+// becomes "null". It returns false, having written nothing, if key is not a
+// string or scalar.
+func WriteJSONKey(b *strings.Builder, key Object) bool {
+	switch k := key.(type) {
+	case String:
+		k.JSON(b)
+	case Int, *Long, Bool, None:
+		// these never need escaping
+		b.WriteByte('"')
+		k.JSON(b)
+		b.WriteByte('"')
+	case Float:
+		var buf [32]byte
+		b.WriteByte('"')
+		b.Write(k.AppendJSON(buf[:0]))
+		b.WriteByte('"')
+	default:
+		return false
+	}
+	return true
+}
+
+// AppendJSONKey is like WriteJSONKey, but appends to dst.
+func AppendJSONKey(dst []byte, key Object) ([]byte, bool) {
+	switch k := key.(type) {
+	case *SimpleString:
+		dst = append(dst, '"')
+		dst = append(dst, *k...)
+		dst = append(dst, '"')
+	case *EscapedString:
+		dst = AppendJSONString(dst, *k)
+	case String:
+		dst = AppendJSONString(dst, []byte(k.String()))
+	case Int:
+		dst = append(dst, '"')
+		dst = strconv.AppendInt(dst, int64(k), 10)
+		dst = append(dst, '"')
+	case *Long:
+		dst = append(dst, '"')
+		dst = (*big.Int)(k).Append(dst, 10)
+		dst = append(dst, '"')
+	case Bool:
+		if k {
+			dst = append(dst, `"true"`...)
+		} else {
+			dst = append(dst, `"false"`...)
+		}
+	case None:
+		dst = append(dst, `"null"`...)
+	case Float:
+		dst = append(dst, '"')
+		dst = k.AppendJSON(dst)
+		dst = append(dst, '"')
+	default:
+		return dst, false
+	}
+	return dst, true
+}
@@ -0,0 +1,27 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDictJSON(t *testing.T) {
+	d := Dict{str("a"), Int(1), Int(2), None{}, None{}, Bool(true)}
+	var b strings.Builder
+	d.JSON(&b)
+	if got, want := b.String(), `{"a":1,"2":null,"null":true}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// with a composite key, the dict is written as pairs
+	b.Reset()
+	d = Dict{str("a"), Int(1), Tuple{Int(1), Int(2)}, Int(3)}
+	d.JSON(&b)
+	if got, want := b.String(), `[["a",1],[[1,2],3]]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+// UnserializableObjectError is returned (or, from the JSON methods which
+// cannot return an error, panicked) when an object has no JSON representation.
+// Classes and other callables are the usual culprits.
+type UnserializableObjectError struct {
+	Object Object
+	Type   string // human readable description of Object, like "GenericClass(foo.Bar)"
+}
+
+func (e *UnserializableObjectError) Error() string {
+	return "can't serialize " + e.Type + " to JSON"
+}
@@ -5,6 +5,8 @@
 package types
 
 import (
+	"bytes"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -15,8 +17,55 @@ func NewFloat(f float64) Float {
 	return Float(f)
 }
 
+// JSON writes the float as Python's json.dumps does: like repr(f), except that
+// NaN and the infinities become NaN, Infinity and -Infinity. Strictly speaking
+// those aren't JSON, but most JSON parsers accept them.
 func (f Float) JSON(b *strings.Builder) {
-	dst := make([]byte, 0, 32) // TODO sync.Pool if this is a hot spot
-	dst = strconv.AppendFloat(dst, float64(f), 'G', -1, 64)
-	b.Write(dst)
+	var buf [32]byte
+	b.Write(f.AppendJSON(buf[:0]))
+}
+
+// AppendJSON appends the text Float.JSON writes to dst.
+func (f Float) AppendJSON(dst []byte) []byte {
+	x := float64(f)
+	switch {
+	case math.IsNaN(x):
+		return append(dst, "NaN"...)
+	case math.IsInf(x, 1):
+		return append(dst, "Infinity"...)
+	case math.IsInf(x, -1):
+		return append(dst, "-Infinity"...)
+	}
+	return f.AppendRepr(dst)
+}
+
+// AppendRepr appends to dst the text of Python's repr(f), such as "1.0", "1e+21"
+// or "nan".
+func (f Float) AppendRepr(dst []byte) []byte {
+	x := float64(f)
+	switch {
+	case math.IsNaN(x):
+		return append(dst, "nan"...)
+	case math.IsInf(x, 1):
+		return append(dst, "inf"...)
+	case math.IsInf(x, -1):
+		return append(dst, "-inf"...)
+	}
+	// like Python, use the shortest representation which round trips, in scientific
+	// notation when the decimal exponent is < -4 or >= 16
+	start := len(dst)
+	dst = strconv.AppendFloat(dst, x, 'e', -1, 64)
+	exp := 0
+	if x != 0 {
+		e := bytes.LastIndexByte(dst[start:], 'e')
+		exp, _ = strconv.Atoi(string(dst[start+e+1:]))
+	}
+	if exp < -4 || exp >= 16 {
+		return dst
+	}
+	dst = strconv.AppendFloat(dst[:start], x, 'f', -1, 64)
+	if bytes.IndexByte(dst[start:], '.') < 0 {
+		dst = append(dst, ".0"...)
+	}
+	return dst
 }
@@ -0,0 +1,71 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// FractionClass represents Python "fractions.Fraction" class.
+type FractionClass struct{}
+
+var _ Callable = &FractionClass{}
+
+// Call returns a new Fraction. The arguments are the numerator and optional
+// denominator, as Fraction.__reduce__ produces, or a string like "-3/4", as
+// it produced before Python 3.12.
+func (*FractionClass) Call(args ...Object) (Object, error) {
+	if len(args) == 1 {
+		if s, ok := args[0].(String); ok {
+			r, ok := new(big.Rat).SetString(strings.TrimSpace(s.String()))
+			if !ok {
+				return nil, fmt.Errorf("FractionClass.Call: invalid fraction %q", s.String())
+			}
+			return (*Fraction)(r), nil
+		}
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("FractionClass.Call unprocessable args: %#v", args)
+	}
+	var parts [2]*big.Int
+	parts[1] = big.NewInt(1)
+	for i, a := range args {
+		switch x := a.(type) {
+		case Int:
+			parts[i] = big.NewInt(int64(x))
+		case *Long:
+			parts[i] = (*big.Int)(x)
+		default:
+			return nil, fmt.Errorf("FractionClass.Call unprocessable args: %#v", args)
+		}
+	}
+	if parts[1].Sign() == 0 {
+		return nil, fmt.Errorf("FractionClass.Call: zero denominator")
+	}
+	return (*Fraction)(new(big.Rat).SetFrac(parts[0], parts[1])), nil
+}
+
+func (c *FractionClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "FractionClass"})
+}
+
+// Fraction represents a Python "fractions.Fraction" object. Like Python's it
+// is always in lowest terms with a positive denominator.
+type Fraction big.Rat
+
+// String returns the fraction as Python's str() does, like "-3/4", or "3" if
+// the denominator is 1.
+func (f *Fraction) String() string {
+	return (*big.Rat)(f).RatString()
+}
+
+// JSON writes the fraction as a JSON string, like "-3/4".
+func (f *Fraction) JSON(b *strings.Builder) {
+	b.WriteByte('"')
+	b.WriteString(f.String())
+	b.WriteByte('"')
+}
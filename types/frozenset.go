@@ -4,7 +4,31 @@
 
 package types
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
+
+// FrozenSetClass represents Python "frozenset" class (a builtin type).
+// Protocols 0 to 3, which have no opcodes for sets, pickle a frozenset as
+// frozenset(list).
+type FrozenSetClass struct{}
+
+var _ Callable = &FrozenSetClass{}
+
+// Call returns a new FrozenSet holding the items of the optional argument, a
+// list, tuple, set or frozenset.
+func (*FrozenSetClass) Call(args ...Object) (Object, error) {
+	items, ok := iterableArg(args)
+	if !ok {
+		return nil, fmt.Errorf("FrozenSetClass.Call unprocessable args: %#v", args)
+	}
+	return FrozenSet(items), nil
+}
+
+func (c *FrozenSetClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "FrozenSetClass"})
+}
 
 // FrozenSet represents a Python "frozenset" (builtin type).
 type FrozenSet []Object
@@ -4,24 +4,42 @@
 
 package types
 
-import (
-	"fmt"
-	"strings"
-)
+import "strings"
 
+// GenericClass stands in for a Python class which has no implementation of its
+// own. Its instances are GenericObjects, which record whatever the pickle
+// gives them.
 type GenericClass struct {
 	Module string
 	Name   string
 }
 
 var _ PyNewable = &GenericClass{}
+var _ Callable = &GenericClass{}
 var _ Object = &GenericClass{}
 
+// GenericObject is an instance of a GenericClass. BUILD sets its attributes:
+// those in the state dict go in Dict, and those in the slot state in Slots.
+// Any other state is kept in State. Instances of subclasses of list and dict
+// also get items, from APPEND and SETITEM.
 type GenericObject struct {
 	Class           *GenericClass
 	ConstructorArgs []Object
+	Dict            Dict   // the instance's __dict__, as key, value pairs
+	Slots           Dict   // attributes held in __slots__, as name, value pairs
+	State           Object // BUILD state which is neither a dict nor a (dict, slot dict) pair, or nil
+	ListItems       List   // items appended by APPEND and APPENDS
+	DictItems       Dict   // items set by SETITEM and SETITEMS, as key, value pairs
+
+	dictIndex, slotIndex attrIndex
 }
 
+var _ PyStateSettable = &GenericObject{}
+var _ PyDictSettable = &GenericObject{}
+var _ PyAttrSettable = &GenericObject{}
+var _ ListAppender = &GenericObject{}
+var _ DictSetter = &GenericObject{}
+
 func NewGenericClass(module, name String) *GenericClass {
 	return &GenericClass{Module: module.String(), Name: name.String()}
 }
@@ -33,10 +51,173 @@ func (g *GenericClass) PyNew(args ...Object) (Object, error) {
 	}, nil
 }
 
-func (g *GenericClass) JSON(*strings.Builder) {
-	panic(fmt.Sprintf("can't serialize GenericClass(%s.%s) to JSON", g.Module, g.Name))
+// Call returns a new GenericObject, as PyNew does.
+func (g *GenericClass) Call(args ...Object) (Object, error) {
+	return g.PyNew(args...)
+}
+
+// String returns the module and name of the class, like "mymodule.MyClass".
+func (g *GenericClass) String() string {
+	return g.Module + "." + g.Name
+}
+
+// JSON writes the module and name of the class as a JSON string.
+func (g *GenericClass) JSON(b *strings.Builder) {
+	b.Write(AppendJSONString(nil, []byte(g.String())))
+}
+
+// PySetState applies BUILD state. A dict sets entries of the instance's
+// __dict__, and a (dict, slot dict) pair also sets attributes held in slots.
+// Either dict may be None. Any other state is kept in State.
+func (g *GenericObject) PySetState(state Object) error {
+	if t, ok := state.(Tuple); ok && len(t) == 2 {
+		dict, ok1 := stateDict(t[0])
+		slots, ok2 := stateDict(t[1])
+		if ok1 && ok2 {
+			for i := 0; i+1 < len(dict); i += 2 {
+				setAttr(&g.Dict, &g.dictIndex, dict[i], dict[i+1])
+			}
+			for i := 0; i+1 < len(slots); i += 2 {
+				setAttr(&g.Slots, &g.slotIndex, slots[i], slots[i+1])
+			}
+			return nil
+		}
+	}
+	if dict, ok := stateDict(state); ok {
+		for i := 0; i+1 < len(dict); i += 2 {
+			setAttr(&g.Dict, &g.dictIndex, dict[i], dict[i+1])
+		}
+		return nil
+	}
+	g.State = state
+	return nil
+}
+
+// stateDict returns the items of a dict or OrderedDict, or none for None
+func stateDict(obj Object) (Dict, bool) {
+	switch d := obj.(type) {
+	case *Dict:
+		return *d, true
+	case *OrderedDict:
+		return Dict(*d), true
+	case None:
+		return nil, true
+	}
+	return nil, false
+}
+
+// PyDictSet sets an entry of the instance's __dict__, replacing any entry with
+// the same name.
+func (g *GenericObject) PyDictSet(key, value Object) error {
+	setAttr(&g.Dict, &g.dictIndex, key, value)
+	return nil
+}
+
+// PySetAttr sets an attribute held in a slot.
+func (g *GenericObject) PySetAttr(name string, value Object) error {
+	setAttr(&g.Slots, &g.slotIndex, NewString([]byte(name), new([]byte)), value)
+	return nil
+}
+
+// attrIndex finds the string keys of the attributes of a GenericObject, once
+// there are too many to search one by one
+type attrIndex struct {
+	names map[string]int // the offset in the Dict of each name
+	first *Object        // the first element of the Dict the index is for
+	n     int            // and its length
+}
+
+// indexAttrs is the number of attributes beyond which an attrIndex is kept
+const indexAttrs = 8
+
+// setAttr sets key to value in d, replacing the value of a string key which
+// is already present, as assigning to a Python attribute does
+func setAttr(d *Dict, idx *attrIndex, key, value Object) {
+	k, isString := key.(String)
+	var name string
+	if isString {
+		name = k.String()
+		if i, ok := idx.find(*d, name); ok {
+			(*d)[i+1] = value
+			return
+		}
+	}
+	*d = append(*d, key, value)
+	if idx.names != nil {
+		if isString {
+			idx.names[name] = len(*d) - 2
+		}
+		idx.first, idx.n = &(*d)[0], len(*d)
+	}
+}
+
+// find returns the offset in d of the string key name. The index is rebuilt
+// if the Dict, which is exported, has been replaced or resized by anything but
+// setAttr.
+func (idx *attrIndex) find(d Dict, name string) (int, bool) {
+	if len(d) <= 2*indexAttrs {
+		idx.names = nil
+		for i := 0; i+1 < len(d); i += 2 {
+			if s, ok := d[i].(String); ok && s.String() == name {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	if idx.names == nil || idx.n != len(d) || idx.first != &d[0] {
+		idx.names = make(map[string]int, len(d)/2)
+		for i := len(d) - 2; i >= 0; i -= 2 {
+			// the first of any duplicate names is the one which is set
+			if s, ok := d[i].(String); ok {
+				idx.names[s.String()] = i
+			}
+		}
+		idx.first, idx.n = &d[0], len(d)
+	}
+	i, ok := idx.names[name]
+	return i, ok
+}
+
+// Append appends an item, as to a list.
+func (g *GenericObject) Append(obj Object) {
+	g.ListItems.Append(obj)
+}
+
+func (g *GenericObject) AppendMany(objs []Object) {
+	g.ListItems.AppendMany(objs)
+}
+
+// Set sets an item, as in a dict.
+func (g *GenericObject) Set(key, value Object) {
+	g.DictItems.Set(key, value)
+}
+
+func (g *GenericObject) SetMany(kv []Object) {
+	g.DictItems.SetMany(kv)
+}
+
+// Attributes returns the instance's attributes: the entries of its __dict__
+// followed by those held in slots.
+func (g *GenericObject) Attributes() Dict {
+	if len(g.Slots) == 0 {
+		return g.Dict
+	}
+	attrs := make(Dict, 0, len(g.Dict)+len(g.Slots))
+	attrs = append(attrs, g.Dict...)
+	return append(attrs, g.Slots...)
 }
 
-func (g *GenericObject) JSON(*strings.Builder) {
-	panic(fmt.Sprintf("can't serialize GenericObject(%s.%s) to JSON", g.Class.Module, g.Class.Name))
+// JSON writes the instance as Python's json.dumps would: an instance of a
+// subclass of list or dict as its items, and any other instance as a JSON
+// object of its attributes. The constructor arguments are left out.
+func (g *GenericObject) JSON(b *strings.Builder) {
+	switch {
+	case len(g.ListItems) != 0:
+		g.ListItems.JSON(b)
+	case len(g.DictItems) != 0:
+		g.DictItems.JSON(b)
+	default:
+		attrs := g.Attributes()
+		attrs.JSON(b)
+	}
 }
@@ -0,0 +1,77 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"strconv"
+	"testing"
+)
+
+func str(s string) Object {
+	return NewString([]byte(s), new([]byte))
+}
+
+func TestGenericObjectSetAttr(t *testing.T) {
+	for _, n := range []int{3, indexAttrs, 100} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			g := &GenericObject{}
+			state := Dict{}
+			for i := 0; i < n; i++ {
+				state = append(state, str("a"+strconv.Itoa(i)), Int(i))
+			}
+			if err := g.PySetState(&state); err != nil {
+				t.Fatal(err)
+			}
+			// setting an attribute again replaces it, in place
+			for i := 0; i < n; i += 2 {
+				g.PyDictSet(str("a"+strconv.Itoa(i)), Int(-i))
+			}
+			g.PyDictSet(str("new"), None{})
+			if len(g.Dict) != 2*(n+1) {
+				t.Fatalf("got %d attributes, want %d", len(g.Dict)/2, n+1)
+			}
+			for i := 0; i < n; i++ {
+				want := Int(i)
+				if i%2 == 0 {
+					want = Int(-i)
+				}
+				if key := g.Dict[2*i].(String).String(); key != "a"+strconv.Itoa(i) {
+					t.Errorf("attribute %d is %s", i, key)
+				}
+				if g.Dict[2*i+1] != want {
+					t.Errorf("a%d = %v, want %v", i, g.Dict[2*i+1], want)
+				}
+			}
+
+			// the index copes with the exported Dict being changed behind its back
+			g.Dict = append(Dict{str("first"), Int(1)}, g.Dict...)
+			g.PyDictSet(str("first"), Int(2))
+			g.PyDictSet(str("a1"), Int(3))
+			if len(g.Dict) != 2*(n+2) || g.Dict[1] != Int(2) || g.Dict[5] != Int(3) {
+				t.Errorf("setting attributes after changing Dict gave %v", g.Dict[:6])
+			}
+		})
+	}
+}
+
+func TestGenericObjectManyAttributes(t *testing.T) {
+	const n = 40000
+	g := &GenericObject{}
+	for i := 0; i < n; i++ {
+		g.PyDictSet(str("attribute"+strconv.Itoa(i)), Int(i))
+	}
+	if len(g.Dict) != 2*n {
+		t.Fatalf("got %d attributes, want %d", len(g.Dict)/2, n)
+	}
+	// replacing an attribute costs no more than converting its name to a string, however
+	// many attributes there are
+	key := str("attribute" + strconv.Itoa(n/2))
+	allocs := testing.AllocsPerRun(100, func() {
+		g.PyDictSet(key, Int(0))
+	})
+	if allocs > 1 {
+		t.Errorf("PyDictSet made %v allocations, want at most 1", allocs)
+	}
+}
@@ -27,6 +27,6 @@ func (o *ObjectClass) PyNew(args ...Object) (Object, error) {
 	}
 }
 
-func (*ObjectClass) JSON(*strings.Builder) {
-	panic("can't serialize ObjectClass to JSON")
+func (o *ObjectClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: o, Type: "ObjectClass"})
 }
@@ -28,8 +28,8 @@ func (*OrderedDictClass) Call(args ...Object) (Object, error) {
 	return NewOrderedDict(), nil
 }
 
-func (*OrderedDictClass) JSON(*strings.Builder) {
-	panic("can't serialize OrderedDictClass to JSON")
+func (o *OrderedDictClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: o, Type: "OrderedDictClass"})
 }
 
 // OrderedDict is a minimal and trivial implementation of an ordered map,
@@ -4,7 +4,57 @@
 
 package types
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
+
+// SetClass represents Python "set" class (a builtin type). Protocols 0 to 3,
+// which have no opcodes for sets, pickle a set as set(list).
+type SetClass struct{}
+
+var _ Callable = &SetClass{}
+
+// Call returns a new Set holding the items of the optional argument, a list,
+// tuple, set or frozenset. Like Python's, the items aren't checked for
+// duplicates.
+func (*SetClass) Call(args ...Object) (Object, error) {
+	items, ok := iterableArg(args)
+	if !ok {
+		return nil, fmt.Errorf("SetClass.Call unprocessable args: %#v", args)
+	}
+	s := Set(items)
+	return &s, nil
+}
+
+func (c *SetClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "SetClass"})
+}
+
+// iterableArg returns a copy of the items of the single, optional argument, which
+// must be a list, tuple, set or frozenset
+func iterableArg(args []Object) ([]Object, bool) {
+	if len(args) == 0 {
+		return nil, true
+	}
+	if len(args) != 1 {
+		return nil, false
+	}
+	var items []Object
+	switch a := args[0].(type) {
+	case *List:
+		items = *a
+	case Tuple:
+		items = a
+	case *Set:
+		items = *a
+	case FrozenSet:
+		items = a
+	default:
+		return nil, false
+	}
+	return append([]Object(nil), items...), true
+}
 
 // SetAdder is implemented by any value that exhibits a set-like behaviour,
 // allowing arbitrary values to be added.
@@ -6,6 +6,7 @@ package types
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
 type String interface {
@@ -33,49 +34,81 @@ func NewString(s []byte, ram *[]byte) Object {
 }
 
 func (s *EscapedString) JSON(b *strings.Builder) {
-	b.WriteByte('"')
-	// the rule in JSON in that JSON text must be UTF-8, or if you must, use unicode \uxxxx notation.
-	// only ascii control chars (<0x20), \ and " need to be escaped, and some control chars can use \[bfnrt/] instead of \u00xx encoding.
-	// (why / might need to be escaped I don't know, but it's there on json.org's flow chart. The code in stdlib doesn't escaping it, so I won't either)
-	for _, r := range string(*s) {
-		switch r {
-		case '"':
-			b.WriteByte('\\')
-			b.WriteByte('"')
-		case '\\':
-			b.WriteByte('\\')
-			b.WriteByte('\\')
-		case '\u2028':
-			b.WriteString("\\u2028")
-		case '\u2029':
-			b.WriteString("\\u2029")
-		case '\b':
-			b.WriteByte('\\')
-			b.WriteByte('b')
-		case '\f':
-			b.WriteByte('\\')
-			b.WriteByte('f')
-		case '\n':
-			b.WriteByte('\\')
-			b.WriteByte('n')
-		case '\r':
-			b.WriteByte('\\')
-			b.WriteByte('r')
-		case '\t':
-			b.WriteByte('\\')
-			b.WriteByte('t')
-		default:
-			if r < 0x20 {
-				b.WriteString("\\u00")
-				b.WriteByte('0' + byte(r>>4))
-				b.WriteByte(hex[r&0xf])
+	b.Write(AppendJSONString(make([]byte, 0, len(*s)+2), *s))
+}
+
+// AppendJSONString appends s to dst as a quoted and escaped JSON string. Lone UTF-16
+// surrogates, which Python strings can hold and which are kept as their 3 byte encoding,
+// are written as \uXXXX escapes, as json.dumps does. Other invalid UTF-8 sequences
+// become U+FFFD.
+func AppendJSONString(dst []byte, s []byte) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if e := jsonEscape(rune(c)); e != "" {
+				dst = append(dst, e...)
 			} else {
-				// all other runes, even those >= 0x80, can be themselves. JSON source is UTF-8
-				b.WriteRune(r)
+				dst = append(dst, c)
 			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(s[i:])
+		switch {
+		case n == 1 && isSurrogate(s[i:]):
+			r = rune(s[i]&0xf)<<12 | rune(s[i+1]&0x3f)<<6 | rune(s[i+2]&0x3f)
+			dst = append(dst, '\\', 'u', hex[r>>12], hex[r>>8&0xf], hex[r>>4&0xf], hex[r&0xf])
+			n = 3
+		case r == '\u2028' || r == '\u2029':
+			dst = append(dst, jsonEscape(r)...)
+		default:
+			dst = utf8.AppendRune(dst, r)
+		}
+		i += n
+	}
+	return append(dst, '"')
+}
+
+// isSurrogate reports whether s begins with the 3 byte encoding of a UTF-16 surrogate,
+// which isn't valid UTF-8
+func isSurrogate(s []byte) bool {
+	return len(s) >= 3 && s[0] == 0xed && s[1]&0xe0 == 0xa0 && s[2]&0xc0 == 0x80
+}
+
+// jsonEscape returns the escape sequence for r in a JSON string, or "" if r can be itself.
+// the rule in JSON in that JSON text must be UTF-8, or if you must, use unicode \uxxxx notation.
+// only ascii control chars (<0x20), \ and " need to be escaped, and some control chars can use \[bfnrt/] instead of \u00xx encoding.
+// (why / might need to be escaped I don't know, but it's there on json.org's flow chart. The code in stdlib doesn't escaping it, so I won't either)
+func jsonEscape(r rune) string {
+	switch {
+	case r < 0x20:
+		return controlEscapes[r]
+	case r == '"':
+		return `\"`
+	case r == '\\':
+		return `\\`
+	case r == '\u2028':
+		return `\u2028`
+	case r == '\u2029':
+		return `\u2029`
+	}
+	return ""
+}
+
+var controlEscapes = [0x20]string{
+	'\b': `\b`,
+	'\f': `\f`,
+	'\n': `\n`,
+	'\r': `\r`,
+	'\t': `\t`,
+}
+
+func init() {
+	for r, e := range controlEscapes {
+		if e == "" {
+			controlEscapes[r] = `\u00` + string(hex[r>>4]) + string(hex[r&0xf])
 		}
 	}
-	b.WriteByte('"')
 }
 
 const hex = "0123456789abcdef"
@@ -0,0 +1,242 @@
+// Copyright 2022 Juniper Networks/Mist Systems. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// TimeDeltaClass represents Python "datetime.timedelta" class.
+type TimeDeltaClass struct{}
+
+var _ Callable = &TimeDeltaClass{}
+
+// Call returns a new TimeDelta of the given days, seconds and microseconds,
+// all of which are optional integers.
+func (*TimeDeltaClass) Call(args ...Object) (Object, error) {
+	if len(args) > 3 {
+		return nil, fmt.Errorf("TimeDeltaClass.Call unprocessable args: %#v", args)
+	}
+	var f [3]int
+	for i := range args {
+		var ok bool
+		if f[i], ok = intArg(args[i]); !ok {
+			return nil, fmt.Errorf("TimeDeltaClass.Call unprocessable args: %#v", args)
+		}
+	}
+	return NewTimeDelta(f[0], f[1], f[2]), nil
+}
+
+func (c *TimeDeltaClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "TimeDeltaClass"})
+}
+
+// TimeDelta represents a Python "datetime.timedelta" object. Like Python's, it
+// is normalized so that 0 <= Seconds < 86400 and 0 <= Microseconds < 1000000.
+// Negative durations have negative Days.
+type TimeDelta struct {
+	Days, Seconds, Microseconds int
+}
+
+// NewTimeDelta makes and returns a new normalized TimeDelta.
+func NewTimeDelta(days, seconds, microseconds int) *TimeDelta {
+	seconds += floorDiv(&microseconds, 1000000)
+	days += floorDiv(&seconds, 86400)
+	return &TimeDelta{Days: days, Seconds: seconds, Microseconds: microseconds}
+}
+
+// floorDiv divides *a by b, rounding down, sets *a to the remainder and
+// returns the quotient
+func floorDiv(a *int, b int) int {
+	q := *a / b
+	if *a%b < 0 {
+		q--
+	}
+	*a -= q * b
+	return q
+}
+
+// Duration returns the timedelta as a time.Duration. Timedeltas longer than
+// time.Duration can hold, about 292 years, overflow.
+func (td *TimeDelta) Duration() time.Duration {
+	return time.Duration(td.Days)*24*time.Hour + time.Duration(td.Seconds)*time.Second +
+		time.Duration(td.Microseconds)*time.Microsecond
+}
+
+// TotalSeconds returns the length of the timedelta in seconds, as Python's
+// timedelta.total_seconds() does.
+func (td *TimeDelta) TotalSeconds() float64 {
+	const maxDays = math.MaxInt64 / 86400000000 // beyond this many days, the microseconds overflow an int64
+	if td.Days > -maxDays && td.Days < maxDays {
+		return microsecondsToSeconds((int64(td.Days)*86400+int64(td.Seconds))*1000000 + int64(td.Microseconds))
+	}
+	us := big.NewInt(int64(td.Days))
+	us.Mul(us, big.NewInt(86400))
+	us.Add(us, big.NewInt(int64(td.Seconds)))
+	us.Mul(us, big.NewInt(1000000))
+	us.Add(us, big.NewInt(int64(td.Microseconds)))
+	f, _ := new(big.Rat).SetFrac(us, big.NewInt(1000000)).Float64()
+	return f
+}
+
+// microsecondsToSeconds divides us by a million, rounding correctly, as
+// Python's int / int does
+func microsecondsToSeconds(us int64) float64 {
+	if us > -1<<53 && us < 1<<53 {
+		// us is exact as a float64, and so the result of the division is correctly rounded
+		return float64(us) / 1e6
+	}
+	f, _ := new(big.Rat).SetFrac(big.NewInt(us), big.NewInt(1000000)).Float64()
+	return f
+}
+
+// JSON writes the timedelta as an ISO 8601 duration string, like "P1DT2H3M4.5S".
+// Python has no such format, so this is this package's own choice.
+func (td *TimeDelta) JSON(b *strings.Builder) {
+	var buf [48]byte
+	b.WriteByte('"')
+	b.Write(td.AppendISO(buf[:0]))
+	b.WriteByte('"')
+}
+
+// AppendISO appends the timedelta as an ISO 8601 duration, like "P1DT2H3M4.5S",
+// to dst. Negative timedeltas are written with a leading "-", as in "-PT1S",
+// and zero is "PT0S".
+func (td *TimeDelta) AppendISO(dst []byte) []byte {
+	days, seconds, us := td.Days, td.Seconds, td.Microseconds
+	if days < 0 {
+		dst = append(dst, '-')
+		days, seconds, us = -days, -seconds, -us
+		seconds += floorDiv(&us, 1000000)
+		days += floorDiv(&seconds, 86400)
+	}
+	dst = append(dst, 'P')
+	if days != 0 {
+		dst = appendDigits(dst, days, 1)
+		dst = append(dst, 'D')
+		if seconds == 0 && us == 0 {
+			return dst
+		}
+	}
+	dst = append(dst, 'T')
+	if h := seconds / 3600; h != 0 {
+		dst = appendDigits(dst, h, 1)
+		dst = append(dst, 'H')
+	}
+	if m := seconds / 60 % 60; m != 0 {
+		dst = appendDigits(dst, m, 1)
+		dst = append(dst, 'M')
+	}
+	if s := seconds % 60; s != 0 || us != 0 || seconds == 0 {
+		dst = appendDigits(dst, s, 1)
+		if us != 0 {
+			dst = append(dst, '.')
+			n := 6
+			for us%10 == 0 {
+				us /= 10
+				n--
+			}
+			dst = appendDigits(dst, us, n)
+		}
+		dst = append(dst, 'S')
+	}
+	return dst
+}
+
+// appendOffset appends the timedelta as a UTC offset, like "+05:30", as
+// Python's isoformat() methods write them
+func (td *TimeDelta) appendOffset(dst []byte) []byte {
+	// a UTC offset is always less than a day
+	seconds, us := td.Days*86400+td.Seconds, td.Microseconds
+	if seconds < 0 {
+		dst = append(dst, '-')
+		seconds, us = -seconds, -us
+		seconds += floorDiv(&us, 1000000)
+	} else {
+		dst = append(dst, '+')
+	}
+	dst = appendDigits(dst, seconds/3600, 2)
+	dst = append(dst, ':')
+	dst = appendDigits(dst, seconds/60%60, 2)
+	if seconds%60 != 0 || us != 0 {
+		dst = append(dst, ':')
+		dst = appendDigits(dst, seconds%60, 2)
+		if us != 0 {
+			dst = append(dst, '.')
+			dst = appendDigits(dst, us, 6)
+		}
+	}
+	return dst
+}
+
+// TimeZoneClass represents Python "datetime.timezone" class.
+type TimeZoneClass struct{}
+
+var _ Callable = &TimeZoneClass{}
+
+// Call returns a new TimeZone. The arguments are the UTC offset, a
+// *TimeDelta, and an optional name.
+func (*TimeZoneClass) Call(args ...Object) (Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("TimeZoneClass.Call unprocessable args: %#v", args)
+	}
+	offset, ok := args[0].(*TimeDelta)
+	if !ok {
+		return nil, fmt.Errorf("TimeZoneClass.Call unprocessable args: %#v", args)
+	}
+	tz := &TimeZone{Offset: *offset}
+	if len(args) == 2 {
+		switch name := args[1].(type) {
+		case String:
+			tz.Name = name.String()
+		case None:
+		default:
+			return nil, fmt.Errorf("TimeZoneClass.Call unprocessable args: %#v", args)
+		}
+	}
+	return tz, nil
+}
+
+func (c *TimeZoneClass) JSON(*strings.Builder) {
+	panic(&UnserializableObjectError{Object: c, Type: "TimeZoneClass"})
+}
+
+// TimeZone represents a Python "datetime.timezone" object, a fixed offset from
+// UTC.
+type TimeZone struct {
+	Offset TimeDelta
+	Name   string // empty if the timezone wasn't given a name
+}
+
+// Location returns a time.Location with the same name and offset. Since
+// time.Location offsets are whole seconds, any microseconds are dropped.
+func (tz *TimeZone) Location() *time.Location {
+	if tz.Name == "" && tz.Offset == (TimeDelta{}) {
+		return time.UTC
+	}
+	return time.FixedZone(tz.String(), tz.Offset.Days*86400+tz.Offset.Seconds)
+}
+
+// String returns the name of the timezone, as Python's str() does: the name it
+// was given, else "UTC" or an offset like "UTC+05:30".
+func (tz *TimeZone) String() string {
+	if tz.Name != "" {
+		return tz.Name
+	}
+	if tz.Offset == (TimeDelta{}) {
+		return "UTC"
+	}
+	var buf [32]byte
+	return string(tz.Offset.appendOffset(append(buf[:0], "UTC"...)))
+}
+
+// JSON writes the timezone's name as a JSON string.
+func (tz *TimeZone) JSON(b *strings.Builder) {
+	b.Write(AppendJSONString(nil, []byte(tz.String())))
+}